@@ -0,0 +1,516 @@
+package cache
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// FsyncPolicy says how often an AOF flushes its writes to stable storage.
+type FsyncPolicy int
+
+const (
+	// Flush after every write. No acknowledged write is lost on a crash, at
+	// the cost of a disk flush on every write to the cache.
+	FsyncAlways FsyncPolicy = iota
+	// Flush once a second. At most about a second of writes is lost.
+	FsyncEverySecond
+	// Leave flushing to the operating system.
+	FsyncNever
+)
+
+// AOFOptions configure an AOF.
+type AOFOptions struct {
+	Fsync FsyncPolicy
+	// If positive, the log is compacted in the background once it is at
+	// least this many bytes and has doubled in size since it was last
+	// compacted.
+	RewriteMinSize int64
+}
+
+// ErrAOFCorrupt is returned when replaying an append-only file that has a
+// damaged record before its end.
+var ErrAOFCorrupt = errors.New("cache: append-only file is corrupt")
+
+// AOF is an append-only file of the changes made to a Cache, from which the
+// cache's contents can be restored by replaying it. The file is a sequence of
+// records, each a 4-byte big-endian length and a 4-byte CRC-32 of a
+// Gob-encoded payload. Compacting the file replaces it with a single record
+// holding a snapshot of the cache, followed by the changes made while the
+// snapshot was taken.
+//
+// Values are encoded with Gob, so their types must be registered with
+// gob.Register before a file holding them is replayed in a new process.
+type AOF struct {
+	c    *cache
+	path string
+	opts AOFOptions
+
+	mu          sync.Mutex
+	f           *os.File
+	size        int64
+	rewriteSize int64
+	dirty       bool
+	err         error
+	// While a rewrite is in progress, the records appended since it started,
+	// which are also appended to the new file.
+	rewriting  bool
+	rewriteBuf [][]byte
+	rewrites   sync.WaitGroup
+	closed     bool
+	closeOnce  sync.Once
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+type aofOp uint8
+
+const (
+	aofSet aofOp = iota + 1
+	aofDelete
+	aofFlush
+	aofSnapshot
+)
+
+type aofRecord struct {
+	Op         aofOp
+	Key        string
+	Object     interface{}
+	Expiration int64
+	Version    uint64
+	Items      map[string]Item
+}
+
+// OpenAOF replays the append-only file at path into c, creating it if it does
+// not exist, and then appends every change made to c to it until the AOF is
+// closed. A record torn by a crash at the end of the file is discarded.
+// Logging makes the cache serialize writes to keys that share a lock.
+func OpenAOF(c *Cache, path string, opts AOFOptions) (*AOF, error) {
+	version, size, err := replayAOF(path, c.cacheMap)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		v := c.version.Load()
+		if v >= version || c.version.CompareAndSwap(v, version) {
+			break
+		}
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	a := &AOF{
+		c:           c.cache,
+		path:        path,
+		opts:        opts,
+		f:           f,
+		size:        size,
+		rewriteSize: size,
+		stop:        make(chan struct{}),
+		done:        make(chan struct{}),
+	}
+	go a.run()
+	c.events.subscribe(a)
+	return a, nil
+}
+
+// ReplayAOF applies the changes recorded in the append-only file at path to
+// m, which may be any CacheMap, as OpenAOF does. Items keep the versions they
+// had when they were recorded.
+func ReplayAOF(path string, m CacheMap) error {
+	_, _, err := replayAOF(path, m)
+	return err
+}
+
+// replayAOF replays the file at path into m, truncating a torn record at its
+// end, and returns the highest version of the items it set and the size of
+// the file. A missing file is empty.
+func replayAOF(path string, m CacheMap) (uint64, int64, error) {
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if os.IsNotExist(err) {
+		return 0, 0, nil
+	}
+	if err != nil {
+		return 0, 0, err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return 0, 0, err
+	}
+	var version uint64
+	var offset int64
+	r := bufio.NewReader(f)
+	for {
+		rec, n, err := readAOFRecord(r, fi.Size()-offset)
+		if err == io.EOF {
+			return version, offset, nil
+		}
+		if err == io.ErrUnexpectedEOF {
+			// The last write was cut short by a crash.
+			if err := f.Truncate(offset); err != nil {
+				return 0, 0, err
+			}
+			return version, offset, nil
+		}
+		if err != nil {
+			return 0, 0, fmt.Errorf("cache: replaying record at offset %d of %s: %w", offset, path, err)
+		}
+		offset += n
+		if v := applyAOFRecord(m, rec); v > version {
+			version = v
+		}
+	}
+}
+
+// readAOFRecord reads a record from the remaining bytes of a file and returns
+// it with its size in the file. It returns io.ErrUnexpectedEOF if the record
+// was cut short by a crash, which can only be true of the last one, and
+// ErrAOFCorrupt if it is damaged.
+func readAOFRecord(r io.Reader, remaining int64) (*aofRecord, int64, error) {
+	var header [8]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, 0, err
+	}
+	remaining -= int64(len(header))
+	n := int64(binary.BigEndian.Uint32(header[:4]))
+	if n == 0 || n > remaining {
+		// No record is empty, so the header is damaged or was never
+		// written, as in a file extended with zeros by a crash.
+		return nil, 0, damagedAOFRecord(r, nil, remaining)
+	}
+	payload := make([]byte, n)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, 0, err
+	}
+	if crc32.ChecksumIEEE(payload) != binary.BigEndian.Uint32(header[4:]) {
+		return nil, 0, damagedAOFRecord(r, payload, remaining-n)
+	}
+	rec := &aofRecord{}
+	if err := gob.NewDecoder(bytes.NewReader(payload)).Decode(rec); err != nil {
+		return nil, 0, fmt.Errorf("%w: %v", ErrAOFCorrupt, err)
+	}
+	return rec, int64(len(header) + len(payload)), nil
+}
+
+// damagedAOFRecord returns the error for a record whose header or payload is
+// invalid, given the bytes read after its header and the number left in the
+// file. A torn write is followed by nothing but what the crash left, so the
+// record is only taken as damaged, rather than torn, if a whole record
+// follows it.
+func damagedAOFRecord(r io.Reader, read []byte, remaining int64) error {
+	rest, err := io.ReadAll(io.LimitReader(r, remaining))
+	if err != nil {
+		return err
+	}
+	if containsAOFRecord(append(read, rest...)) {
+		return ErrAOFCorrupt
+	}
+	return io.ErrUnexpectedEOF
+}
+
+// containsAOFRecord reports whether a record with a valid checksum starts
+// anywhere in b.
+func containsAOFRecord(b []byte) bool {
+	for i := 0; i+8 <= len(b); i++ {
+		n := int(binary.BigEndian.Uint32(b[i : i+4]))
+		if n == 0 || n > len(b)-i-8 {
+			continue
+		}
+		if crc32.ChecksumIEEE(b[i+8:i+8+n]) == binary.BigEndian.Uint32(b[i+4:i+8]) {
+			return true
+		}
+	}
+	return false
+}
+
+// applyAOFRecord applies rec to m and returns the highest version it set.
+func applyAOFRecord(m CacheMap, rec *aofRecord) uint64 {
+	switch rec.Op {
+	case aofSet:
+		item := Item{Object: rec.Object, Expiration: rec.Expiration, Version: rec.Version}
+		if item.Expired() {
+			m.Delete(rec.Key)
+		} else {
+			m.Set(rec.Key, item)
+		}
+		return rec.Version
+	case aofDelete:
+		m.Delete(rec.Key)
+	case aofFlush:
+		m.Flush()
+	case aofSnapshot:
+		var version uint64
+		for k, item := range rec.Items {
+			if item.Expired() {
+				continue
+			}
+			m.Set(k, item)
+			if item.Version > version {
+				version = item.Version
+			}
+		}
+		return version
+	}
+	return 0
+}
+
+// encodeAOFRecord returns rec in the form it is stored in the file.
+func encodeAOFRecord(rec *aofRecord) ([]byte, error) {
+	if err := registerRecordTypes(rec); err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	buf.Write(make([]byte, 8))
+	if err := gob.NewEncoder(&buf).Encode(rec); err != nil {
+		return nil, err
+	}
+	b := buf.Bytes()
+	binary.BigEndian.PutUint32(b[:4], uint32(len(b)-8))
+	binary.BigEndian.PutUint32(b[4:8], crc32.ChecksumIEEE(b[8:]))
+	return b, nil
+}
+
+// registerRecordTypes registers the types of the values in rec with Gob,
+// each only the first time it is seen.
+func registerRecordTypes(rec *aofRecord) error {
+	if rec.Object != nil {
+		if err := registerGobType(rec.Object); err != nil {
+			return err
+		}
+	}
+	for _, item := range rec.Items {
+		if item.Object != nil {
+			if err := registerGobType(item.Object); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// recordOf returns the record of a change to a cache.
+func recordOf(ev Event) *aofRecord {
+	rec := &aofRecord{Key: ev.Key}
+	switch ev.Type {
+	case EventSet:
+		rec.Op = aofSet
+		rec.Object = ev.NewValue
+		rec.Expiration = ev.Expiration
+		rec.Version = ev.Version
+	case EventDelete, EventExpire, EventEvict:
+		rec.Op = aofDelete
+	case EventFlush:
+		rec.Op = aofFlush
+	}
+	return rec
+}
+
+func (a *AOF) notify(ev Event) {
+	b, err := encodeAOFRecord(recordOf(ev))
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if err == nil {
+		err = a.write(b)
+	}
+	if err != nil {
+		if a.err == nil {
+			a.err = err
+		}
+		return
+	}
+	if a.opts.RewriteMinSize > 0 && !a.rewriting &&
+		a.size >= a.opts.RewriteMinSize && a.size >= 2*a.rewriteSize {
+		a.rewriting = true
+		a.rewrites.Add(1)
+		go func() {
+			defer a.rewrites.Done()
+			a.rewrite()
+		}()
+	}
+}
+
+// write appends an encoded record to the file. a.mu must be held.
+func (a *AOF) write(b []byte) error {
+	if _, err := a.f.Write(b); err != nil {
+		return err
+	}
+	a.size += int64(len(b))
+	if a.rewriting {
+		a.rewriteBuf = append(a.rewriteBuf, b)
+	}
+	if a.opts.Fsync == FsyncAlways {
+		return a.f.Sync()
+	}
+	a.dirty = true
+	return nil
+}
+
+// run flushes the file once a second for FsyncEverySecond.
+func (a *AOF) run() {
+	defer close(a.done)
+	if a.opts.Fsync != FsyncEverySecond {
+		<-a.stop
+		return
+	}
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			a.mu.Lock()
+			if a.dirty {
+				a.dirty = false
+				if err := a.f.Sync(); err != nil && a.err == nil {
+					a.err = err
+				}
+			}
+			a.mu.Unlock()
+		case <-a.stop:
+			return
+		}
+	}
+}
+
+// Rewrite compacts the file by replacing it with a snapshot of the cache. The
+// cache can be written to while the snapshot is taken; those changes are
+// appended to the new file. It does nothing if a rewrite is already in
+// progress.
+func (a *AOF) Rewrite() error {
+	a.mu.Lock()
+	if a.rewriting || a.closed {
+		a.mu.Unlock()
+		return nil
+	}
+	a.rewriting = true
+	a.rewrites.Add(1)
+	a.mu.Unlock()
+	defer a.rewrites.Done()
+	return a.rewrite()
+}
+
+// rewrite does the work of Rewrite once a.rewriting has been set.
+func (a *AOF) rewrite() error {
+	err := a.writeSnapshot()
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.rewriting = false
+	a.rewriteBuf = nil
+	if err != nil && a.err == nil {
+		a.err = err
+	}
+	return err
+}
+
+// writeSnapshot writes a snapshot of the cache and the records appended
+// since the rewrite started to a new file, and replaces the log with it.
+func (a *AOF) writeSnapshot() error {
+	// Records appended before the snapshot is taken are in it, so only
+	// those appended from now on need to follow it.
+	a.mu.Lock()
+	a.rewriteBuf = nil
+	a.mu.Unlock()
+	b, err := encodeAOFRecord(&aofRecord{Op: aofSnapshot, Items: a.c.Items()})
+	if err != nil {
+		return err
+	}
+	tmp := a.path + ".rewrite"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp)
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+		return err
+	}
+	size := int64(len(b))
+
+	// Close waits for the rewrite before closing the file, so it is
+	// finished even if the AOF is being closed.
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for _, b := range a.rewriteBuf {
+		if _, err := f.Write(b); err != nil {
+			f.Close()
+			return err
+		}
+		size += int64(len(b))
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, a.path); err != nil {
+		return err
+	}
+	nf, err := os.OpenFile(a.path, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		return err
+	}
+	a.f.Close()
+	a.f = nf
+	a.size = size
+	a.rewriteSize = size
+	a.dirty = false
+	return nil
+}
+
+// Size returns the size of the file in bytes.
+func (a *AOF) Size() int64 {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.size
+}
+
+// Err returns the first error that occurred while appending to or flushing
+// the file. Changes made after an error may not have been recorded.
+func (a *AOF) Err() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.err
+}
+
+// Close stops logging changes, flushes the file and closes it. It returns the
+// first error that occurred while logging, if any. Closing an AOF twice
+// does nothing more.
+func (a *AOF) Close() error {
+	a.closeOnce.Do(a.close)
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.err
+}
+
+func (a *AOF) close() {
+	a.c.events.unsubscribe(a)
+	a.mu.Lock()
+	a.closed = true
+	a.mu.Unlock()
+	a.rewrites.Wait()
+	close(a.stop)
+	<-a.done
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if err := a.f.Sync(); err != nil && a.err == nil {
+		a.err = err
+	}
+	if err := a.f.Close(); err != nil && a.err == nil {
+		a.err = err
+	}
+}
@@ -0,0 +1,253 @@
+package cache
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestAOF(t *testing.T) {
+	testAOF(t, NewRwmMap())
+	testAOF(t, NewSyncMap())
+	testAOF(t, NewConcurrentMap())
+}
+
+func testAOF(t *testing.T, m CacheMap) {
+	path := filepath.Join(t.TempDir(), "cache.aof")
+	tc := New(DefaultExpiration, 0, NewRwmMap())
+	a, err := OpenAOF(tc, path, AOFOptions{})
+	if err != nil {
+		t.Fatal("Couldn't open the AOF:", err)
+	}
+	tc.Set("gone", 0, DefaultExpiration)
+	tc.Flush()
+	tc.Set("a", "a", DefaultExpiration)
+	tc.Set("b", &TestStruct{Num: 1}, time.Hour)
+	tc.Set("c", 1, DefaultExpiration)
+	tc.Delete("c")
+	tc.Set("expired", 1, time.Nanosecond)
+	_, version, _ := tc.GetWithVersion("b")
+	if err := a.Close(); err != nil {
+		t.Fatal("Couldn't close the AOF:", err)
+	}
+	if err := a.Close(); err != nil {
+		t.Error("a second Close failed:", err)
+	}
+	tc.Set("after", 1, DefaultExpiration)
+	<-time.After(time.Millisecond)
+
+	oc := New(DefaultExpiration, 0, m)
+	a, err = OpenAOF(oc, path, AOFOptions{})
+	if err != nil {
+		t.Fatal("Couldn't replay the AOF:", err)
+	}
+	defer a.Close()
+	if n := len(oc.Items()); n != 2 {
+		t.Error("replayed cache has", n, "items, want 2:", oc.Items())
+	}
+	if x, _ := oc.Get("a"); x != "a" {
+		t.Error("a was not replayed:", x)
+	}
+	x, v, found := oc.GetWithVersion("b")
+	if !found || x.(*TestStruct).Num != 1 || v != version {
+		t.Error("b was not replayed with its value and version:", x, v)
+	}
+	if _, _, found := oc.GetWithExpiration("after"); found {
+		t.Error("a change made after closing the AOF was replayed")
+	}
+	oc.Set("d", "d", DefaultExpiration)
+	if _, v, _ := oc.GetWithVersion("d"); v <= version {
+		t.Error("a new item has version", v, "not above the replayed version", version)
+	}
+}
+
+func TestAOFFsyncPolicies(t *testing.T) {
+	for _, p := range []FsyncPolicy{FsyncAlways, FsyncEverySecond, FsyncNever} {
+		path := filepath.Join(t.TempDir(), "cache.aof")
+		tc := New(DefaultExpiration, 0, NewConcurrentMap())
+		a, err := OpenAOF(tc, path, AOFOptions{Fsync: p})
+		if err != nil {
+			t.Fatal("Couldn't open the AOF:", err)
+		}
+		tc.Set("foo", "bar", DefaultExpiration)
+		if err := a.Close(); err != nil {
+			t.Errorf("policy %d: Close failed: %v", p, err)
+		}
+		m := NewRwmMap()
+		if err := ReplayAOF(path, m); err != nil {
+			t.Errorf("policy %d: replay failed: %v", p, err)
+		}
+		if x, found := m.Get("foo"); !found || x.(Item).Object != "bar" {
+			t.Errorf("policy %d: foo was not recorded: %v", p, x)
+		}
+	}
+}
+
+func TestAOFTornTail(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.aof")
+	tc := New(DefaultExpiration, 0, NewRwmMap())
+	a, _ := OpenAOF(tc, path, AOFOptions{})
+	tc.Set("foo", "bar", DefaultExpiration)
+	a.Close()
+	fi, _ := os.Stat(path)
+	size := fi.Size()
+
+	b, _ := os.ReadFile(path)
+	for _, tail := range [][]byte{b[:3], b[:len(b)-1]} {
+		f, _ := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+		f.Write(tail)
+		f.Close()
+		oc := New(DefaultExpiration, 0, NewSyncMap())
+		a, err := OpenAOF(oc, path, AOFOptions{})
+		if err != nil {
+			t.Fatal("torn record at the end was not discarded:", err)
+		}
+		if x, _ := oc.Get("foo"); x != "bar" {
+			t.Error("foo was not replayed before the torn record:", x)
+		}
+		if a.Size() != size {
+			t.Errorf("AOF has size %d after discarding the torn record, want %d", a.Size(), size)
+		}
+		a.Close()
+	}
+}
+
+func TestAOFZeroedTail(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.aof")
+	tc := New(DefaultExpiration, 0, NewRwmMap())
+	a, _ := OpenAOF(tc, path, AOFOptions{})
+	tc.Set("a", 1, DefaultExpiration)
+	a.Close()
+	f, _ := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+	f.Write(make([]byte, 16))
+	f.Close()
+
+	tc = New(DefaultExpiration, 0, NewRwmMap())
+	a, err := OpenAOF(tc, path, AOFOptions{})
+	if err != nil {
+		t.Fatal("a tail of zeros was not discarded:", err)
+	}
+	tc.Set("b", 2, DefaultExpiration)
+	a.Close()
+	tc = New(DefaultExpiration, 0, NewRwmMap())
+	a, err = OpenAOF(tc, path, AOFOptions{})
+	if err != nil {
+		t.Fatal("OpenAOF failed:", err)
+	}
+	defer a.Close()
+	for k, want := range map[string]int{"a": 1, "b": 2} {
+		if x, _ := tc.Get(k); x != want {
+			t.Errorf("%s replayed as %v, want %d", k, x, want)
+		}
+	}
+
+	// Zeros followed by whole records are damage, not a torn write.
+	b, _ := os.ReadFile(path)
+	damaged := append(append(append([]byte(nil), b...), make([]byte, 16)...), b...)
+	os.WriteFile(path, damaged, 0o644)
+	if _, err := OpenAOF(New(DefaultExpiration, 0, NewRwmMap()), path, AOFOptions{}); !errors.Is(err, ErrAOFCorrupt) {
+		t.Error("replaying zeros before whole records did not return ErrAOFCorrupt:", err)
+	}
+	if fi, _ := os.Stat(path); fi.Size() != int64(len(damaged)) {
+		t.Errorf("AOF has size %d after replaying a damaged record, want %d", fi.Size(), len(damaged))
+	}
+}
+
+func TestAOFCorrupt(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.aof")
+	tc := New(DefaultExpiration, 0, NewRwmMap())
+	a, _ := OpenAOF(tc, path, AOFOptions{})
+	tc.Set("foo", "bar", DefaultExpiration)
+	tc.Set("baz", "qux", DefaultExpiration)
+	a.Close()
+	b, _ := os.ReadFile(path)
+	b[10] ^= 0xff
+	os.WriteFile(path, b, 0o644)
+	_, err := OpenAOF(New(DefaultExpiration, 0, NewRwmMap()), path, AOFOptions{})
+	if !errors.Is(err, ErrAOFCorrupt) {
+		t.Error("replaying a damaged record did not return ErrAOFCorrupt:", err)
+	}
+
+	// A damaged length that runs past the end of the file is not a torn
+	// record when whole records follow it.
+	b, _ = os.ReadFile(path)
+	b[10] ^= 0xff
+	b[0] = 0xff
+	os.WriteFile(path, b, 0o644)
+	_, err = OpenAOF(New(DefaultExpiration, 0, NewRwmMap()), path, AOFOptions{})
+	if !errors.Is(err, ErrAOFCorrupt) {
+		t.Error("replaying a record with a damaged length did not return ErrAOFCorrupt:", err)
+	}
+	if fi, _ := os.Stat(path); fi.Size() != int64(len(b)) {
+		t.Errorf("AOF has size %d after replaying a damaged record, want %d", fi.Size(), len(b))
+	}
+}
+
+func TestAOFRewrite(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.aof")
+	tc := New(DefaultExpiration, 0, NewConcurrentMap())
+	a, _ := OpenAOF(tc, path, AOFOptions{})
+	for i := 0; i < 1000; i++ {
+		tc.Set("foo", i, DefaultExpiration)
+	}
+	tc.Set("bar", 1, DefaultExpiration)
+	tc.Delete("bar")
+	before := a.Size()
+	if err := a.Rewrite(); err != nil {
+		t.Fatal("Rewrite failed:", err)
+	}
+	if a.Size() >= before {
+		t.Errorf("Rewrite did not shrink the AOF: %d bytes before, %d after", before, a.Size())
+	}
+	tc.Set("baz", "after", DefaultExpiration)
+	a.Close()
+
+	fi, _ := os.Stat(path)
+	if fi.Size() != a.Size() {
+		t.Errorf("AOF file has %d bytes, Size reports %d", fi.Size(), a.Size())
+	}
+	oc := New(DefaultExpiration, 0, NewRwmMap())
+	a, err := OpenAOF(oc, path, AOFOptions{})
+	if err != nil {
+		t.Fatal("Couldn't replay the rewritten AOF:", err)
+	}
+	defer a.Close()
+	if x, _ := oc.Get("foo"); x != 999 {
+		t.Error("foo was not replayed from the snapshot:", x)
+	}
+	if x, _ := oc.Get("baz"); x != "after" {
+		t.Error("baz was not replayed after the snapshot:", x)
+	}
+	if _, found := oc.Get("bar"); found {
+		t.Error("a deleted item was replayed from the snapshot")
+	}
+}
+
+func TestAOFAutoRewrite(t *testing.T) {
+	dir := t.TempDir()
+	tc := New(DefaultExpiration, 0, NewRwmMap())
+	a, _ := OpenAOF(tc, filepath.Join(dir, "compacted.aof"), AOFOptions{Fsync: FsyncNever, RewriteMinSize: 4096})
+	full, _ := OpenAOF(tc, filepath.Join(dir, "full.aof"), AOFOptions{Fsync: FsyncNever})
+	for i := 0; i < 5000; i++ {
+		tc.Set("key"+strconv.Itoa(i%10), i, DefaultExpiration)
+	}
+	full.Close()
+	if err := a.Close(); err != nil {
+		t.Fatal("Close failed:", err)
+	}
+	if a.Size() > full.Size()/2 {
+		t.Errorf("AOF was not compacted automatically: %d bytes, %d without compaction", a.Size(), full.Size())
+	}
+	m := NewSyncMap()
+	if err := ReplayAOF(filepath.Join(dir, "compacted.aof"), m); err != nil {
+		t.Fatal("Couldn't replay the AOF:", err)
+	}
+	for i := 0; i < 10; i++ {
+		if x, _ := m.Get("key" + strconv.Itoa(i)); x.(Item).Object != 4990+i {
+			t.Errorf("key%d replayed as %v, want %d", i, x, 4990+i)
+		}
+	}
+}
@@ -0,0 +1,205 @@
+package cache
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/gob"
+	"encoding/hex"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// maxDatagram is the size of the largest invalidation that can be sent in a
+// datagram.
+const maxDatagram = 64 << 10
+
+// unixPeerRefresh is how long a Unix broadcaster keeps sending to the
+// sockets it last found in its directory, unless the directory changes.
+const unixPeerRefresh = time.Second
+
+// A packetBroadcaster sends invalidations as Gob-encoded datagrams.
+type packetBroadcaster struct {
+	conn net.PacketConn
+	// send writes a datagram to the other instances.
+	send   func(b []byte) error
+	close  func() error
+	buf    []byte
+	closed atomic.Bool
+	once   sync.Once
+	err    error
+}
+
+func (p *packetBroadcaster) Publish(inv Invalidation) error {
+	if p.closed.Load() {
+		return ErrBroadcasterClosed
+	}
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(&inv); err != nil {
+		return err
+	}
+	if buf.Len() > maxDatagram {
+		return errors.New("cache: invalidation too large for a datagram")
+	}
+	err := p.send(buf.Bytes())
+	if errors.Is(err, net.ErrClosed) {
+		return ErrBroadcasterClosed
+	}
+	return err
+}
+
+// Receive is called by one goroutine at a time, which may reuse buf.
+func (p *packetBroadcaster) Receive() (Invalidation, error) {
+	if p.buf == nil {
+		p.buf = make([]byte, maxDatagram)
+	}
+	n, _, err := p.conn.ReadFrom(p.buf)
+	if err != nil {
+		if errors.Is(err, net.ErrClosed) {
+			return Invalidation{}, ErrBroadcasterClosed
+		}
+		return Invalidation{}, err
+	}
+	var inv Invalidation
+	err = gob.NewDecoder(bytes.NewReader(p.buf[:n])).Decode(&inv)
+	return inv, err
+}
+
+func (p *packetBroadcaster) Close() error {
+	p.once.Do(func() {
+		p.closed.Store(true)
+		p.err = p.close()
+	})
+	return p.err
+}
+
+// NewUDPBroadcaster returns a Broadcaster that multicasts invalidations to the
+// UDP group at addr, such as "239.1.2.3:7946", on the network interface ifi,
+// or one chosen by the system if ifi is nil. UDP loses, duplicates and
+// reorders datagrams; an Invalidator flushes its cache when it notices
+// losses.
+func NewUDPBroadcaster(addr string, ifi *net.Interface) (Broadcaster, error) {
+	gaddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	recv, err := net.ListenMulticastUDP("udp", ifi, gaddr)
+	if err != nil {
+		return nil, err
+	}
+	send, err := net.DialUDP("udp", nil, gaddr)
+	if err != nil {
+		recv.Close()
+		return nil, err
+	}
+	return &packetBroadcaster{
+		conn: recv,
+		send: func(b []byte) error {
+			_, err := send.Write(b)
+			return err
+		},
+		close: func() error {
+			return errors.Join(send.Close(), recv.Close())
+		},
+	}, nil
+}
+
+// NewUnixBroadcaster returns a Broadcaster for instances on one host that
+// share the directory dir. Each binds a Unix datagram socket in dir, and
+// publishes an invalidation by sending it to every other socket there, which
+// it lists again when the directory changes or at least once a second. Unix
+// datagrams aren't lost, but a publish waits while a receiver's buffer is
+// full. Closing the broadcaster removes its socket; the sockets of instances
+// that exit without closing theirs are removed by the first publish that
+// finds nothing listening on them.
+func NewUnixBroadcaster(dir string) (Broadcaster, error) {
+	id := make([]byte, 8)
+	rand.Read(id)
+	self := filepath.Join(dir, hex.EncodeToString(id)+".sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: self, Net: "unixgram"})
+	if err != nil {
+		return nil, err
+	}
+	conn.SetWriteBuffer(maxDatagram * 4)
+	peers := &unixPeers{dir: dir, self: self}
+	return &packetBroadcaster{
+		conn: conn,
+		send: func(b []byte) error {
+			paths, err := peers.list()
+			if err != nil {
+				return err
+			}
+			var errs []error
+			for _, path := range paths {
+				_, err := conn.WriteToUnix(b, &net.UnixAddr{Name: path, Net: "unixgram"})
+				if errors.Is(err, os.ErrNotExist) || errors.Is(err, syscall.ECONNREFUSED) {
+					peers.remove(path)
+					continue
+				}
+				if err != nil {
+					errs = append(errs, err)
+				}
+			}
+			return errors.Join(errs...)
+		},
+		close: func() error {
+			err := conn.Close()
+			os.Remove(self)
+			return err
+		},
+	}, nil
+}
+
+// unixPeers lists the sockets of the other instances that share a directory.
+type unixPeers struct {
+	dir, self string
+	mu        sync.Mutex
+	// The sockets found by the last listing, nil if the directory must be
+	// listed again, and the directory's modification time at the time.
+	paths   []string
+	modTime time.Time
+	listed  time.Time
+}
+
+// list returns the sockets of the other instances. It only lists the
+// directory again if it has changed since the last listing, or if that is
+// older than unixPeerRefresh, in case a change fell within the resolution
+// of the modification time.
+func (u *unixPeers) list() ([]string, error) {
+	fi, err := os.Stat(u.dir)
+	if err != nil {
+		return nil, err
+	}
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if u.paths != nil && fi.ModTime().Equal(u.modTime) && time.Since(u.listed) < unixPeerRefresh {
+		return u.paths, nil
+	}
+	entries, err := os.ReadDir(u.dir)
+	if err != nil {
+		return nil, err
+	}
+	paths := make([]string, 0, len(entries))
+	for _, e := range entries {
+		path := filepath.Join(u.dir, e.Name())
+		if strings.HasSuffix(e.Name(), ".sock") && path != u.self {
+			paths = append(paths, path)
+		}
+	}
+	u.paths, u.modTime, u.listed = paths, fi.ModTime(), time.Now()
+	return paths, nil
+}
+
+// remove removes the socket at path, which nothing listens on.
+func (u *unixPeers) remove(path string) {
+	os.Remove(path)
+	u.mu.Lock()
+	u.paths = nil
+	u.mu.Unlock()
+}
@@ -0,0 +1,115 @@
+package cache
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testBroadcasters checks that invalidations published on one of bs are
+// received by the other.
+func testBroadcasters(t *testing.T, bs [2]Broadcaster) {
+	a := New(NoExpiration, 0, NewConcurrentMap())
+	b := New(NoExpiration, 0, NewConcurrentMap())
+	b.Set("foo", 1, NoExpiration)
+	ia := NewInvalidator(a, bs[0], InvalidatorOptions{})
+	ib := NewInvalidator(b, bs[1], InvalidatorOptions{})
+	defer ib.Close()
+
+	a.Set("foo", 2, NoExpiration)
+	waitFor(t, "the invalidation", func() bool {
+		_, found := b.Get("foo")
+		return !found
+	})
+	b.Set("bar", 1, NoExpiration)
+	a.Flush()
+	waitFor(t, "the flush", func() bool { return b.ItemCount() == 0 })
+	if err := ia.Close(); err != nil {
+		t.Error("Close failed:", err)
+	}
+	if err := bs[0].Publish(Invalidation{}); err != ErrBroadcasterClosed {
+		t.Error("Publish after Close returned", err)
+	}
+}
+
+func TestUnixBroadcaster(t *testing.T) {
+	dir := t.TempDir()
+	var bs [2]Broadcaster
+	for i := range bs {
+		b, err := NewUnixBroadcaster(dir)
+		if err != nil {
+			t.Fatal("NewUnixBroadcaster failed:", err)
+		}
+		bs[i] = b
+	}
+	// A socket left by an instance that exited without closing it.
+	stale, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: filepath.Join(dir, "stale.sock"), Net: "unixgram"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale.Close()
+
+	testBroadcasters(t, bs)
+	if _, err := os.Stat(filepath.Join(dir, "stale.sock")); !os.IsNotExist(err) {
+		t.Error("a stale socket was not removed:", err)
+	}
+	bs[1].Close()
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Error("Close left sockets behind:", entries)
+	}
+}
+
+func TestUnixBroadcasterJoin(t *testing.T) {
+	dir := t.TempDir()
+	b, err := NewUnixBroadcaster(dir)
+	if err != nil {
+		t.Fatal("NewUnixBroadcaster failed:", err)
+	}
+	defer b.Close()
+	if err := b.Publish(Invalidation{Key: "foo"}); err != nil {
+		t.Fatal("Publish failed:", err)
+	}
+	// An instance that joins after a publish is sent the next one.
+	joined, err := NewUnixBroadcaster(dir)
+	if err != nil {
+		t.Fatal("NewUnixBroadcaster failed:", err)
+	}
+	defer joined.Close()
+	if err := b.Publish(Invalidation{Key: "bar"}); err != nil {
+		t.Fatal("Publish failed:", err)
+	}
+	if inv, err := joined.Receive(); err != nil || inv.Key != "bar" {
+		t.Errorf("an instance that joined received %+v, %v", inv, err)
+	}
+}
+
+func TestUDPBroadcaster(t *testing.T) {
+	var bs [2]Broadcaster
+	for i := range bs {
+		b, err := NewUDPBroadcaster("239.7.7.7:17946", nil)
+		if err != nil {
+			t.Skip("can't join a multicast group:", err)
+		}
+		defer b.Close()
+		bs[i] = b
+	}
+	// Multicast may be unroutable in a sandbox; check that a datagram
+	// arrives before relying on it.
+	received := make(chan struct{})
+	go func() {
+		if _, err := bs[1].Receive(); err == nil {
+			close(received)
+		}
+	}()
+	if err := bs[0].Publish(Invalidation{Origin: "probe"}); err != nil {
+		t.Skip("can't send to a multicast group:", err)
+	}
+	select {
+	case <-received:
+	case <-time.After(time.Second):
+		t.Skip("multicast datagrams are not delivered on this host")
+	}
+	testBroadcasters(t, bs)
+}
@@ -0,0 +1,273 @@
+package cache
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/maphash"
+	"sync"
+	"time"
+)
+
+// ErrEntryTooLarge is returned when an entry doesn't fit in a shard of a
+// ByteCache, or its key is longer than 65535 bytes.
+var ErrEntryTooLarge = errors.New("cache: entry too large")
+
+// ByteCacheOptions configure a ByteCache.
+type ByteCacheOptions struct {
+	// The number of shards, rounded up to a power of two. Each has its own
+	// lock and ring buffer. The default is 64.
+	Shards int
+	// The total size of the ring buffers in bytes, divided evenly between
+	// the shards. An entry takes 22 bytes besides its key and value, and
+	// must fit in a shard. A shard's buffer is allocated when it is first
+	// written to. The default is 64 MiB.
+	Capacity int
+	// The expiration time of entries set with DefaultExpiration. The default
+	// of 0 means they never expire.
+	DefaultExpiration time.Duration
+}
+
+// ByteCache is a cache of byte slices that stays cheap for the garbage
+// collector however many entries it holds. Entries are written one after
+// another into a ring buffer of bytes in each shard, and found through an
+// index from the hash of their keys to their offsets, so the cache holds no
+// pointers for the collector to scan. When a shard's buffer is full, its
+// oldest entries are evicted to make room, whether or not they have
+// expired. Deleted, replaced and expired entries keep their room until then.
+//
+// Keys whose hashes collide replace each other, as if one had been evicted.
+type ByteCache struct {
+	seed              maphash.Seed
+	shards            []byteShard
+	defaultExpiration time.Duration
+}
+
+// An entry in a shard's buffer is a header, then the key, then the value.
+// The header holds the expiration time in Unix nanoseconds, or 0 if the
+// entry never expires, the hash of the key, and the lengths of the key and
+// value, in little-endian order.
+const (
+	entryHeaderSize = 8 + 8 + 2 + 4
+	maxKeyLen       = 1<<16 - 1
+)
+
+type byteShard struct {
+	mu    sync.RWMutex
+	index map[uint64]uint32
+	ring  []byte
+	size  uint32
+	// Entries are written at head, and evicted from tail. Between them,
+	// wrapping around at end, are used bytes of entries.
+	head, tail, end, used uint32
+}
+
+func NewByteCache(opts ByteCacheOptions) *ByteCache {
+	if opts.Shards <= 0 {
+		opts.Shards = 64
+	}
+	if opts.Capacity <= 0 {
+		opts.Capacity = 64 << 20
+	}
+	n := 1
+	for n < opts.Shards {
+		n *= 2
+	}
+	c := &ByteCache{
+		seed:              maphash.MakeSeed(),
+		shards:            make([]byteShard, n),
+		defaultExpiration: opts.DefaultExpiration,
+	}
+	size := uint32(min(opts.Capacity/n, 1<<32-1))
+	for i := range c.shards {
+		c.shards[i] = byteShard{index: map[uint64]uint32{}, size: size, end: size}
+	}
+	return c
+}
+
+// shard returns the hash of key and the shard that holds it.
+func (c *ByteCache) shard(key string) (uint64, *byteShard) {
+	h := maphash.String(c.seed, key)
+	return h, &c.shards[h&uint64(len(c.shards)-1)]
+}
+
+// Get returns a copy of the value of key, and whether it was found and has
+// not expired.
+func (c *ByteCache) Get(key string) ([]byte, bool) {
+	v, _, found := c.GetWithExpiration(key)
+	return v, found
+}
+
+// GetWithExpiration returns a copy of the value of key, its expiration time,
+// or the zero time if it never expires, and whether it was found and has not
+// expired.
+func (c *ByteCache) GetWithExpiration(key string) ([]byte, time.Time, bool) {
+	h, s := c.shard(key)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	_, e, v, found := s.lookup(h, key)
+	if !found || (e > 0 && time.Now().UnixNano() > e) {
+		return nil, time.Time{}, false
+	}
+	var t time.Time
+	if e > 0 {
+		t = time.Unix(0, e)
+	}
+	return append([]byte(nil), v...), t, true
+}
+
+// Set stores a copy of value as the value of key, replacing any existing
+// entry. A duration of DefaultExpiration uses the cache's default, and
+// NoExpiration keeps the entry until it is evicted or deleted. It returns
+// ErrEntryTooLarge, and deletes key, if the entry doesn't fit in a shard.
+func (c *ByteCache) Set(key string, value []byte, d time.Duration) error {
+	if d == DefaultExpiration {
+		d = c.defaultExpiration
+	}
+	var e int64
+	if d > 0 {
+		e = time.Now().Add(d).UnixNano()
+	}
+	h, s := c.shard(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.put(h, key, e, value) {
+		s.remove(h, key)
+		return ErrEntryTooLarge
+	}
+	return nil
+}
+
+// Delete deletes key, and reports whether it was in the cache.
+func (c *ByteCache) Delete(key string) bool {
+	h, s := c.shard(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.remove(h, key)
+}
+
+// Len returns the number of entries in the cache, including expired entries
+// that have not yet been evicted.
+func (c *ByteCache) Len() int {
+	n := 0
+	for i := range c.shards {
+		s := &c.shards[i]
+		s.mu.RLock()
+		n += len(s.index)
+		s.mu.RUnlock()
+	}
+	return n
+}
+
+// Flush deletes all the entries. The shards keep their buffers.
+func (c *ByteCache) Flush() {
+	for i := range c.shards {
+		s := &c.shards[i]
+		s.mu.Lock()
+		s.reset()
+		s.mu.Unlock()
+	}
+}
+
+// lookup returns the offset, expiration time and value of key, whose hash is
+// h. The value is part of the buffer, so it may only be used while s.mu is
+// held.
+func (s *byteShard) lookup(h uint64, key string) (off uint32, e int64, value []byte, found bool) {
+	off, found = s.index[h]
+	if !found {
+		return 0, 0, nil, false
+	}
+	e, k, value := s.entry(off)
+	if string(k) != key {
+		return 0, 0, nil, false
+	}
+	return off, e, value, true
+}
+
+// entry returns the expiration time, key and value of the entry at off,
+// which are part of the buffer.
+func (s *byteShard) entry(off uint32) (e int64, key, value []byte) {
+	b := s.ring[off:]
+	e = int64(binary.LittleEndian.Uint64(b))
+	k := entryHeaderSize + int(binary.LittleEndian.Uint16(b[16:]))
+	v := k + int(binary.LittleEndian.Uint32(b[18:]))
+	return e, b[entryHeaderSize:k], b[k:v]
+}
+
+// put writes an entry for key, whose hash is h, and makes the index point to
+// it, evicting the oldest entries as needed. It reports false, writing
+// nothing, if the entry is too large. s.mu must be held.
+func (s *byteShard) put(h uint64, key string, e int64, value []byte) bool {
+	n := entryHeaderSize + len(key) + len(value)
+	if len(key) > maxKeyLen || n > int(s.size) {
+		return false
+	}
+	if s.ring == nil {
+		s.ring = make([]byte, s.size)
+	}
+	off := s.reserve(uint32(n))
+	b := s.ring[off : off+uint32(n)]
+	binary.LittleEndian.PutUint64(b, uint64(e))
+	binary.LittleEndian.PutUint64(b[8:], h)
+	binary.LittleEndian.PutUint16(b[16:], uint16(len(key)))
+	binary.LittleEndian.PutUint32(b[18:], uint32(len(value)))
+	copy(b[entryHeaderSize:], key)
+	copy(b[entryHeaderSize+len(key):], value)
+	s.index[h] = off
+	s.head = off + uint32(n)
+	s.used += uint32(n)
+	return true
+}
+
+// reserve evicts entries until there are n free bytes at the head, or at the
+// start of the buffer if they don't fit before its end, and returns their
+// offset.
+func (s *byteShard) reserve(n uint32) uint32 {
+	if s.used == 0 {
+		s.head, s.tail, s.end = 0, 0, s.size
+	}
+	if s.head+n > s.size {
+		// The entries between the head and the end are the oldest.
+		for s.used > 0 && s.tail >= s.head {
+			s.evict()
+		}
+		s.end, s.head = s.head, 0
+		if s.used == 0 {
+			s.tail, s.end = 0, s.size
+		}
+	}
+	for s.used > 0 && s.tail >= s.head && s.tail-s.head < n {
+		s.evict()
+	}
+	return s.head
+}
+
+// evict frees the oldest entry, removing it from the index unless it has
+// been replaced or deleted.
+func (s *byteShard) evict() {
+	b := s.ring[s.tail:]
+	h := binary.LittleEndian.Uint64(b[8:])
+	n := entryHeaderSize + uint32(binary.LittleEndian.Uint16(b[16:])) + binary.LittleEndian.Uint32(b[18:])
+	if off, found := s.index[h]; found && off == s.tail {
+		delete(s.index, h)
+	}
+	s.tail += n
+	s.used -= n
+	if s.tail == s.end {
+		s.tail, s.end = 0, s.size
+	}
+}
+
+// remove deletes key from the index, leaving its entry to be evicted, and
+// reports whether it was there.
+func (s *byteShard) remove(h uint64, key string) bool {
+	if _, _, _, found := s.lookup(h, key); !found {
+		return false
+	}
+	delete(s.index, h)
+	return true
+}
+
+func (s *byteShard) reset() {
+	s.index = map[uint64]uint32{}
+	s.head, s.tail, s.end, s.used = 0, 0, s.size, 0
+}
@@ -0,0 +1,268 @@
+package cache
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestByteCache(t *testing.T) {
+	c := NewByteCache(ByteCacheOptions{Shards: 4, Capacity: 4 << 10})
+	if v, found := c.Get("a"); found {
+		t.Error("Get found a key that was never set:", v)
+	}
+	value := []byte("1")
+	c.Set("a", value, NoExpiration)
+	value[0] = '2'
+	v, e, found := c.GetWithExpiration("a")
+	if !found || string(v) != "1" || !e.IsZero() {
+		t.Errorf("GetWithExpiration returned %q, %v, %t", v, e, found)
+	}
+	v[0] = '3'
+	if v, _ := c.Get("a"); string(v) != "1" {
+		t.Errorf("Get returned %q after the caller changed a value it got", v)
+	}
+
+	c.Set("a", []byte("22"), time.Hour)
+	if v, e, found := c.GetWithExpiration("a"); !found || string(v) != "22" || e.Before(time.Now().Add(59*time.Minute)) {
+		t.Errorf("GetWithExpiration returned %q, %v, %t for a replaced key", v, e, found)
+	}
+	c.Set("b", nil, time.Nanosecond)
+	<-time.After(time.Millisecond)
+	if v, found := c.Get("b"); found {
+		t.Errorf("Get returned %q for an expired key", v)
+	}
+	if n := c.Len(); n != 2 {
+		t.Error("Len is", n, "want 2 with an expired key")
+	}
+	if !c.Delete("a") || c.Delete("a") || c.Delete("missing") {
+		t.Error("Delete did not report which keys it deleted")
+	}
+
+	if err := c.Set("c", make([]byte, 1<<10), NoExpiration); err != ErrEntryTooLarge {
+		t.Error("setting a value larger than a shard returned", err)
+	}
+	c.Set("k", []byte("v"), NoExpiration)
+	if err := c.Set("k", nil, NoExpiration); err != nil {
+		t.Error("Set returned", err)
+	}
+	if err := c.Set("k", make([]byte, 1<<10), NoExpiration); err != ErrEntryTooLarge {
+		t.Error("replacing a value with one larger than a shard returned", err)
+	}
+	if v, found := c.Get("k"); found {
+		t.Errorf("Get returned %q for a key whose new value was too large", v)
+	}
+	c.Flush()
+	if n := c.Len(); n != 0 {
+		t.Error("Len is", n, "after Flush")
+	}
+}
+
+func TestByteCacheDefaultExpiration(t *testing.T) {
+	c := NewByteCache(ByteCacheOptions{Capacity: 1 << 20, DefaultExpiration: time.Hour})
+	c.Set("a", []byte("1"), DefaultExpiration)
+	c.Set("b", []byte("2"), NoExpiration)
+	if _, e, _ := c.GetWithExpiration("a"); e.IsZero() {
+		t.Error("an entry set with DefaultExpiration never expires")
+	}
+	if _, e, _ := c.GetWithExpiration("b"); !e.IsZero() {
+		t.Error("an entry set with NoExpiration expires at", e)
+	}
+}
+
+// TestByteCacheEviction writes entries of random sizes into a small shard,
+// so that its buffer wraps around many times, and checks that it never
+// returns a stale value and keeps the most recent entries.
+func TestByteCacheEviction(t *testing.T) {
+	c := NewByteCache(ByteCacheOptions{Shards: 1, Capacity: 1000})
+	s := &c.shards[0]
+	rnd := rand.New(rand.NewSource(1))
+	want := map[string]string{}
+	var recent []string
+	for i := 0; i < 20000; i++ {
+		k := strconv.Itoa(rnd.Intn(200))
+		switch r := rnd.Intn(100); {
+		case r < 10:
+			c.Delete(k)
+			delete(want, k)
+			continue
+		case r == 10:
+			c.Flush()
+			clear(want)
+			recent = recent[:0]
+			continue
+		}
+		v := fmt.Sprint(i, ":", strings.Repeat("x", rnd.Intn(100)))
+		if err := c.Set(k, []byte(v), NoExpiration); err != nil {
+			t.Fatal("Set returned", err)
+		}
+		want[k] = v
+		recent = append(recent, k)
+		if s.used > s.size || s.head > s.size || s.end > s.size {
+			t.Fatalf("the shard's buffer is inconsistent: head %d, tail %d, end %d, used %d", s.head, s.tail, s.end, s.used)
+		}
+		for k := range want {
+			if v, found := c.Get(k); found && string(v) != want[k] {
+				t.Fatalf("Get returned %q for %s, want %q", v, k, want[k])
+			}
+		}
+		// The entries are at most 130 bytes long, so the last 6 always fit, even
+		// with the room left unused before the buffer wraps around.
+		for _, k := range recent[max(0, len(recent)-6):] {
+			if v, found := c.Get(k); want[k] != "" && !found {
+				t.Fatalf("Get did not find %s, one of the last keys set, after %d writes", k, i)
+			} else if found && string(v) != want[k] {
+				t.Fatalf("Get returned %q for %s, want %q", v, k, want[k])
+			}
+		}
+	}
+}
+
+func TestCacheByteMap(t *testing.T) {
+	tc := New(NoExpiration, 0, NewByteMap(ByteMapOptions{}))
+	tc.Set("a", []int{1, 2}, NoExpiration)
+	tc.Set("b", "x", time.Hour)
+	tc.Set("c", 1, time.Nanosecond)
+	x, found := tc.Get("a")
+	if !found || len(x.([]int)) != 2 {
+		t.Fatal("Get returned", x, found)
+	}
+	x.([]int)[0] = 5
+	if x, _ := tc.Get("a"); x.([]int)[0] != 1 {
+		t.Error("Get returned", x, "after the caller changed a value it got")
+	}
+	if x, e, found := tc.GetWithExpiration("b"); !found || x != "x" || e.IsZero() {
+		t.Error("GetWithExpiration returned", x, e, found)
+	}
+	_, version, _ := tc.GetWithVersion("b")
+	if !tc.CompareAndSwap("b", version, "y", time.Hour) || tc.CompareAndSwap("b", version, "z", time.Hour) {
+		t.Error("CompareAndSwap did not compare the versions of items")
+	}
+	tc.DeleteExpired()
+	if n := tc.ItemCount(); n != 2 {
+		t.Error("ItemCount is", n, "after DeleteExpired, want 2")
+	}
+
+	var failed []string
+	small := New(NoExpiration, 0, NewByteMap(ByteMapOptions{
+		ByteCacheOptions: ByteCacheOptions{Shards: 1, Capacity: 1 << 10},
+		OnError:          func(key string, err error) { failed = append(failed, key+": "+err.Error()) },
+	}))
+	small.Set("f", func() {}, NoExpiration)
+	small.Set("big", make([]byte, 1<<10), NoExpiration)
+	if want := "big: " + ErrEntryTooLarge.Error(); len(failed) != 2 || failed[1] != want {
+		t.Errorf("OnError was called with %q, want an error encoding f and %q", failed, want)
+	}
+	if n := small.ItemCount(); n != 0 {
+		t.Error("ItemCount is", n, "after writes that failed")
+	}
+}
+
+// countingCodec is a GobCodec that counts the values it decodes.
+type countingCodec struct {
+	GobCodec
+	decodes *int
+}
+
+func (c countingCodec) Decode(b []byte) (interface{}, error) {
+	*c.decodes++
+	return c.GobCodec.Decode(b)
+}
+
+func TestByteMapDeleteExpired(t *testing.T) {
+	var decodes int
+	tc := New(NoExpiration, 0, NewByteMap(ByteMapOptions{Codec: countingCodec{decodes: &decodes}}))
+	for i := 0; i < 100; i++ {
+		tc.Set(strconv.Itoa(i), i, NoExpiration)
+	}
+	tc.Set("expired", 1, time.Nanosecond)
+	<-time.After(time.Millisecond)
+	tc.DeleteExpired()
+	if n := tc.ItemCount(); n != 100 {
+		t.Error("ItemCount is", n, "after DeleteExpired, want 100")
+	}
+	// Only the expired item is decoded, to check it again before deleting it.
+	if decodes != 1 {
+		t.Error("DeleteExpired decoded", decodes, "values, want 1")
+	}
+}
+
+// BenchmarkGC measures the pauses of collections with a million entries in
+// the heap, held by each backend, while readers and writers use it.
+func BenchmarkGC(b *testing.B) {
+	const entries = 1 << 20
+	value := bytes.Repeat([]byte("x"), 32)
+	b.Run("RwmMap", func(b *testing.B) {
+		m := NewRwmMap()
+		for i := 0; i < entries; i++ {
+			m.Set(strconv.Itoa(i), Item{Object: append([]byte(nil), value...)})
+		}
+		benchmarkGC(b, func(rnd *rand.Rand) {
+			k := strconv.Itoa(rnd.Intn(entries))
+			if rnd.Intn(4) == 0 {
+				m.Set(k, Item{Object: append([]byte(nil), value...)})
+			} else {
+				m.Get(k)
+			}
+		})
+		runtime.KeepAlive(m)
+	})
+	b.Run("ByteCache", func(b *testing.B) {
+		c := NewByteCache(ByteCacheOptions{Capacity: 128 << 20})
+		for i := 0; i < entries; i++ {
+			c.Set(strconv.Itoa(i), value, NoExpiration)
+		}
+		if c.Len() != entries {
+			b.Fatal("the cache evicted entries")
+		}
+		benchmarkGC(b, func(rnd *rand.Rand) {
+			k := strconv.Itoa(rnd.Intn(entries))
+			if rnd.Intn(4) == 0 {
+				c.Set(k, value, NoExpiration)
+			} else {
+				c.Get(k)
+			}
+		})
+		runtime.KeepAlive(c)
+	})
+}
+
+// benchmarkGC runs collections while a goroutine per processor, and at least
+// two, calls op in a loop, and reports their average pause.
+func benchmarkGC(b *testing.B, op func(rnd *rand.Rand)) {
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	for g := 0; g < max(2, runtime.GOMAXPROCS(0)); g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			rnd := rand.New(rand.NewSource(int64(g)))
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+					op(rnd)
+				}
+			}
+		}()
+	}
+	runtime.GC()
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		runtime.GC()
+	}
+	b.StopTimer()
+	runtime.ReadMemStats(&after)
+	close(stop)
+	wg.Wait()
+	b.ReportMetric(float64(after.PauseTotalNs-before.PauseTotalNs)/float64(after.NumGC-before.NumGC), "pause-ns/gc")
+}
@@ -0,0 +1,273 @@
+package cache
+
+import (
+	"encoding/binary"
+	"iter"
+)
+
+// ByteMapOptions configure a ByteMap.
+type ByteMapOptions struct {
+	ByteCacheOptions
+	// Codec encodes the values stored. The default is GobCodec, which
+	// handles any registered type but writes a description of the value's
+	// type into every entry, about 40 bytes even for an int. A Codec for the
+	// types actually stored, such as JSONCodec with New set, is cheaper.
+	Codec Codec
+	// OnError, if set, is called with the key and error when a value can't
+	// be encoded, decoded or stored, such as ErrEntryTooLarge.
+	OnError func(key string, err error)
+}
+
+// ByteMap is a CacheMap that stores its values encoded in a ByteCache, so
+// that a Cache can hold many items without slowing the garbage collector.
+// The expiration times of Items are kept in the entries' headers.
+//
+// Values are decoded on every read, so each Get returns a new copy. A write
+// of a value that can't be encoded or doesn't fit deletes the key instead,
+// and CompareAndSwap and Compute report the write as not done. Like a
+// ByteCache, a ByteMap evicts its oldest entries when a shard is full,
+// without events, and Range and All skip values that can't be decoded. A
+// Cache's expired items are found from the headers, without decoding them.
+type ByteMap struct {
+	c       *ByteCache
+	codec   Codec
+	onError func(key string, err error)
+}
+
+func NewByteMap(opts ByteMapOptions) CacheMap {
+	if opts.Codec == nil {
+		opts.Codec = GobCodec{}
+	}
+	return &ByteMap{c: NewByteCache(opts.ByteCacheOptions), codec: opts.Codec, onError: opts.OnError}
+}
+
+// The value of an entry of a ByteMap starts with a byte telling whether it
+// is an Item, followed by the Item's version if it is.
+const (
+	bytePlain byte = iota
+	byteItem
+)
+
+func (m *ByteMap) fail(k string, err error) {
+	if m.onError != nil {
+		m.onError(k, err)
+	}
+}
+
+// encode returns the entry value and expiration time of x, or a nil value if
+// x can't be encoded.
+func (m *ByteMap) encode(k string, x interface{}) ([]byte, int64) {
+	head := []byte{bytePlain}
+	var e int64
+	if item, ok := x.(Item); ok {
+		head = binary.LittleEndian.AppendUint64([]byte{byteItem}, item.Version)
+		x, e = item.Object, item.Expiration
+	}
+	b, err := m.codec.Encode(x)
+	if err != nil {
+		m.fail(k, err)
+		return nil, 0
+	}
+	return append(head, b...), e
+}
+
+func (m *ByteMap) decode(k string, e int64, b []byte) (interface{}, bool) {
+	kind, b := b[0], b[1:]
+	var version uint64
+	if kind == byteItem {
+		version, b = binary.LittleEndian.Uint64(b), b[8:]
+	}
+	x, err := m.codec.Decode(b)
+	if err != nil {
+		m.fail(k, err)
+		return nil, false
+	}
+	if kind == byteItem {
+		return Item{Object: x, Expiration: e, Version: version}, true
+	}
+	return x, true
+}
+
+// get returns the decoded value of k. s.mu must be held.
+func (m *ByteMap) get(s *byteShard, h uint64, k string) (interface{}, bool) {
+	_, e, b, found := s.lookup(h, k)
+	if !found {
+		return nil, false
+	}
+	return m.decode(k, e, b)
+}
+
+// put stores b, the encoded value of k, or deletes k if b is nil or doesn't
+// fit, and reports whether it stored it. s.mu must be held.
+func (m *ByteMap) put(s *byteShard, h uint64, k string, b []byte, e int64) bool {
+	if b != nil && !s.put(h, k, e, b) {
+		m.fail(k, ErrEntryTooLarge)
+		b = nil
+	}
+	if b == nil {
+		s.remove(h, k)
+		return false
+	}
+	return true
+}
+
+// Get decodes the value with the shard's read lock held.
+func (m *ByteMap) Get(k string) (interface{}, bool) {
+	h, s := m.c.shard(k)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return m.get(s, h, k)
+}
+
+// Set encodes the value before taking the shard's lock.
+func (m *ByteMap) Set(k string, x interface{}) {
+	b, e := m.encode(k, x)
+	h, s := m.c.shard(k)
+	s.mu.Lock()
+	m.put(s, h, k, b, e)
+	s.mu.Unlock()
+}
+
+func (m *ByteMap) Delete(k string) {
+	m.c.Delete(k)
+}
+
+// CompareAndSwap encodes x before taking the lock of k's shard, and calls cmp
+// with it held.
+func (m *ByteMap) CompareAndSwap(k string, x interface{}, cmp func(old interface{}, found bool) bool) bool {
+	b, e := m.encode(k, x)
+	h, s := m.c.shard(k)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	old, found := m.get(s, h, k)
+	if !cmp(old, found) {
+		return false
+	}
+	return m.put(s, h, k, b, e)
+}
+
+// CompareAndDelete calls cmp with the lock of k's shard held.
+func (m *ByteMap) CompareAndDelete(k string, cmp func(old interface{}) bool) bool {
+	h, s := m.c.shard(k)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	old, found := m.get(s, h, k)
+	if !found || !cmp(old) {
+		return false
+	}
+	return s.remove(h, k)
+}
+
+// Compute calls f with the lock of k's shard held.
+func (m *ByteMap) Compute(k string, f func(old interface{}, found bool) (interface{}, Op)) (interface{}, bool) {
+	h, s := m.c.shard(k)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	old, found := m.get(s, h, k)
+	x, op := f(old, found)
+	switch op {
+	case OpSet:
+		if b, e := m.encode(k, x); !m.put(s, h, k, b, e) {
+			return nil, false
+		}
+		return x, true
+	case OpDelete:
+		s.remove(h, k)
+		return nil, false
+	}
+	return old, found
+}
+
+func (m *ByteMap) GetMulti(keys []string, f func(k string, v any)) {
+	for _, k := range keys {
+		if x, found := m.Get(k); found {
+			f(k, x)
+		}
+	}
+}
+
+func (m *ByteMap) SetMulti(keys []string, values []interface{}) {
+	for i, k := range keys {
+		m.Set(k, values[i])
+	}
+}
+
+func (m *ByteMap) DeleteMulti(keys []string) {
+	for _, k := range keys {
+		m.c.Delete(k)
+	}
+}
+
+func (m *ByteMap) Range(f func(k string, v any)) {
+	for k, v := range m.All() {
+		f(k, v)
+	}
+}
+
+// All iterates over a snapshot that is consistent within each shard, but not
+// across shards. The entries of a shard are copied under its read lock, and
+// decoded after it is released.
+func (m *ByteMap) All() iter.Seq2[string, any] {
+	return func(yield func(string, any) bool) {
+		for i := range m.c.shards {
+			s := &m.c.shards[i]
+			type entry struct {
+				k string
+				e int64
+				b []byte
+			}
+			s.mu.RLock()
+			entries := make([]entry, 0, len(s.index))
+			for _, off := range s.index {
+				e, k, v := s.entry(off)
+				entries = append(entries, entry{string(k), e, append([]byte(nil), v...)})
+			}
+			s.mu.RUnlock()
+			for _, en := range entries {
+				x, ok := m.decode(en.k, en.e, en.b)
+				if !ok {
+					continue
+				}
+				if !yield(en.k, x) {
+					return
+				}
+			}
+		}
+	}
+}
+
+// RangeExpired calls f with the key of each Item that had expired by now,
+// reading only the entries' headers. The keys of a shard are collected under
+// its read lock, and f is called after it is released, so f may write to the
+// map.
+func (m *ByteMap) RangeExpired(now int64, f func(k string)) {
+	for i := range m.c.shards {
+		s := &m.c.shards[i]
+		var keys []string
+		s.mu.RLock()
+		for _, off := range s.index {
+			e, k, _ := s.entry(off)
+			if e > 0 && now > e {
+				keys = append(keys, string(k))
+			}
+		}
+		s.mu.RUnlock()
+		for _, k := range keys {
+			f(k)
+		}
+	}
+}
+
+func (m *ByteMap) Count() int {
+	return m.c.Len()
+}
+
+// EntryOverhead returns the bytes used for each entry besides its key and
+// value: an index slot, the entry's header and the Item's version.
+func (m *ByteMap) EntryOverhead() int64 {
+	return 16 + entryHeaderSize + 9
+}
+
+func (m *ByteMap) Flush() {
+	m.c.Flush()
+}
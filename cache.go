@@ -1,7 +1,10 @@
 package cache
 
 import (
+	"iter"
 	"runtime"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -12,11 +15,33 @@ const (
 	// passing in the same expiration duration as was given to New() or
 	// NewFrom() when the cache was created (e.g. 5 minutes.)
 	DefaultExpiration time.Duration = 0
+	// For use with Compute. Keeps the expiration time of the item being
+	// replaced, or sets an item that doesn't expire if there was none.
+	// Functions that don't read the item they replace, such as Set, treat it
+	// like NoExpiration.
+	KeepExpiration time.Duration = -2
+)
+
+// Op is returned by the function passed to Compute to say what to do with the
+// item.
+type Op int
+
+const (
+	// Leave the item as it was.
+	OpKeep Op = iota
+	// Replace the item with the returned value, or add it if it was not found.
+	OpSet
+	// Delete the item.
+	OpDelete
 )
 
 type Item struct {
 	Object     interface{}
 	Expiration int64
+	// Version identifies the write that stored the item. Every write to the
+	// cache takes a new version from a counter shared by all keys, so an
+	// item's version changes whenever it is replaced and is never reused.
+	Version uint64
 }
 
 // Returns true if the item has expired.
@@ -36,6 +61,12 @@ type cache struct {
 	defaultExpiration time.Duration
 	cacheMap          CacheMap
 	janitor           *janitor
+	version           atomic.Uint64
+	txLocks           txLocks
+	events            eventHub
+	stats             stats
+	observing         observing
+	hot               atomic.Pointer[hotKeys]
 }
 
 // Get an item from the cache. Returns the item or nil, and a bool indicating
@@ -43,29 +74,301 @@ type cache struct {
 func (c *cache) Get(k string) (interface{}, bool) {
 	value, found := c.cacheMap.Get(k)
 	if !found {
+		c.lookup(k, GetMiss)
 		return nil, false
 	}
 	item := value.(Item)
-	return item.Object, !item.Expired()
+	if item.Expired() {
+		c.lookup(k, GetExpired)
+		return item.Object, false
+	}
+	c.lookup(k, GetHit)
+	return item.Object, true
+}
+
+// lookup counts a lookup of k and tells the Observer of it.
+func (c *cache) lookup(k string, r GetResult) {
+	c.stats.lookup(k, r == GetHit)
+	c.observing.get(k, r)
+	c.hot.Load().record(k)
 }
 
 // Add an item to the cache, replacing any existing item. If the duration is 0
 // (DefaultExpiration), the cache's default expiration time is used. If it is -1
-// (NoExpiration), the item never expires.
+// (NoExpiration), or any other negative duration, the item never expires.
 func (c *cache) Set(k string, x interface{}, d time.Duration) {
-	var e int64
+	c.hot.Load().record(k)
+	item := c.newItem(x, d)
+	i, observed := c.lockKey(k)
+	defer c.unlockKey(i, observed)
+	if !observed {
+		c.cacheMap.Set(k, item)
+		return
+	}
+	old, found := c.cacheMap.Get(k)
+	c.cacheMap.Set(k, item)
+	c.publishSet(k, old, found, item)
+}
+
+// publishSet publishes the event of setting k to item, replacing old if found.
+func (c *cache) publishSet(k string, old interface{}, found bool, item Item) {
+	oldValue, _ := objectOf(old, found)
+	c.events.publish(Event{
+		Type:       EventSet,
+		Key:        k,
+		OldValue:   oldValue,
+		NewValue:   item.Object,
+		Expiration: item.Expiration,
+		Version:    item.Version,
+	})
+}
+
+// publishDelete publishes the event of deleting k, which held old.
+func (c *cache) publishDelete(k string, old interface{}) {
+	oldValue, _ := objectOf(old, true)
+	c.events.publish(Event{Type: EventDelete, Key: k, OldValue: oldValue})
+}
+
+// newItem returns an item holding x that expires after d, interpreted as in
+// Set, with a new version.
+func (c *cache) newItem(x interface{}, d time.Duration) Item {
+	return Item{Object: x, Expiration: c.expiration(d), Version: c.version.Add(1)}
+}
+
+// expiration returns the expiration time of an item set now with duration d,
+// interpreted as in Set, or 0 if it never expires.
+func (c *cache) expiration(d time.Duration) int64 {
 	if d == DefaultExpiration {
 		d = c.defaultExpiration
 	}
 	if d > 0 {
-		e = time.Now().Add(d).UnixNano()
+		return time.Now().Add(d).UnixNano()
+	}
+	return 0
+}
+
+// GetWithVersion returns an item and its version from the cache. It returns
+// the item or nil, the version or 0, and a bool indicating whether the key
+// was found and has not expired. The version can be passed to
+// CompareAndSwap or CompareAndDelete.
+func (c *cache) GetWithVersion(k string) (interface{}, uint64, bool) {
+	value, found := c.cacheMap.Get(k)
+	if !found {
+		c.lookup(k, GetMiss)
+		return nil, 0, false
+	}
+	item := value.(Item)
+	if item.Expired() {
+		c.lookup(k, GetExpired)
+		return nil, 0, false
 	}
-	c.cacheMap.Set(k, Item{Object: x, Expiration: e})
+	c.lookup(k, GetHit)
+	return item.Object, item.Version, true
+}
+
+// CompareAndSwap replaces the item for k with x if the item's version is
+// still version, and reports whether it did. A version of 0 matches a key
+// that is not in the cache or has expired, so CompareAndSwap can also add an
+// item only if no other goroutine has added it first. The duration is
+// interpreted as in Set.
+func (c *cache) CompareAndSwap(k string, version uint64, x interface{}, d time.Duration) bool {
+	item := c.newItem(x, d)
+	i, observed := c.lockKey(k)
+	defer c.unlockKey(i, observed)
+	var old interface{}
+	var found bool
+	swapped := c.cacheMap.CompareAndSwap(k, item, func(v interface{}, ok bool) bool {
+		old, found = v, ok
+		return versionOf(v, ok) == version
+	})
+	if swapped && observed {
+		c.publishSet(k, old, found, item)
+	}
+	return swapped
+}
+
+// CompareAndDelete deletes the item for k if its version is still version,
+// and reports whether it did.
+func (c *cache) CompareAndDelete(k string, version uint64) bool {
+	if version == 0 {
+		return false
+	}
+	i, observed := c.lockKey(k)
+	defer c.unlockKey(i, observed)
+	var old interface{}
+	deleted := c.cacheMap.CompareAndDelete(k, func(v interface{}) bool {
+		old = v
+		return versionOf(v, true) == version
+	})
+	if deleted && observed {
+		c.publishDelete(k, old)
+	}
+	return deleted
+}
+
+// Compute atomically updates the item for k. It calls f with the item's
+// current value, or nil and false if the key is not in the cache or has
+// expired, and then keeps, sets or deletes the item as f's returned Op says.
+// A set item gets the returned value and duration, which is interpreted as in
+// Set. Compute returns the value of the item afterwards and whether it is in
+// the cache.
+//
+// No other write to k can happen between reading the item and applying f's
+// result. Depending on the CacheMap backend, f is either called with a lock
+// held, so it must not access the cache, or may be called more than once if
+// it races with other writes, so it should be free of side effects.
+func (c *cache) Compute(k string, f func(old interface{}, found bool) (interface{}, time.Duration, Op)) (interface{}, bool) {
+	i, observed := c.lockKey(k)
+	defer c.unlockKey(i, observed)
+	var old, set interface{}
+	var present bool
+	var op Op
+	v, found := c.cacheMap.Compute(k, func(v interface{}, ok bool) (interface{}, Op) {
+		old, present = v, ok
+		x, found := objectOf(v, ok)
+		x, d, o := f(x, found)
+		op = o
+		if op == OpSet {
+			item := c.newItem(x, d)
+			if d == KeepExpiration && found {
+				item.Expiration = v.(Item).Expiration
+			}
+			set = item
+			return set, op
+		}
+		return v, op
+	})
+	if observed {
+		switch {
+		case op == OpSet:
+			c.publishSet(k, old, present, set.(Item))
+		case op == OpDelete && present:
+			c.publishDelete(k, old)
+		}
+	}
+	return objectOf(v, found)
+}
+
+// objectOf returns the object of an item held by a CacheMap, and whether it
+// was found and has not expired.
+func objectOf(v interface{}, found bool) (interface{}, bool) {
+	if !found {
+		return nil, false
+	}
+	item := v.(Item)
+	if item.Expired() {
+		return nil, false
+	}
+	return item.Object, true
+}
+
+// versionOf returns the version of an item held by a CacheMap, or 0 if it was
+// not found or has expired.
+func versionOf(v interface{}, found bool) uint64 {
+	if !found {
+		return 0
+	}
+	item := v.(Item)
+	if item.Expired() {
+		return 0
+	}
+	return item.Version
 }
 
 // Delete an item from the cache. Does nothing if the key is not in the cache.
 func (c *cache) Delete(k string) {
-	c.cacheMap.Delete(k)
+	c.delete(k, nil)
+}
+
+// delete deletes k, publishing the event to every subscriber but skip.
+func (c *cache) delete(k string, skip subscriber) {
+	i, observed := c.lockKey(k)
+	defer c.unlockKey(i, observed)
+	if !observed {
+		c.cacheMap.Delete(k)
+		return
+	}
+	if old, found := c.cacheMap.Get(k); found {
+		c.cacheMap.Delete(k)
+		oldValue, _ := objectOf(old, true)
+		c.events.publishExcept(Event{Type: EventDelete, Key: k, OldValue: oldValue}, skip)
+	}
+}
+
+// GetMulti gets several items from the cache at once. It returns a map of the
+// keys that were found and have not expired to their values. Backends that
+// guard their items with locks take each lock once for the whole batch.
+func (c *cache) GetMulti(keys []string) map[string]interface{} {
+	values := make(map[string]interface{}, len(keys))
+	now := time.Now().UnixNano()
+	c.cacheMap.GetMulti(keys, func(k string, v any) {
+		item := v.(Item)
+		// "Inlining" of Expired
+		if item.Expiration <= 0 || now <= item.Expiration {
+			values[k] = item.Object
+		}
+	})
+	if len(keys) > 0 {
+		c.stats.lookups(keys[0], len(values), len(keys)-len(values))
+	}
+	c.observing.getMulti(keys, values)
+	if h := c.hot.Load(); h != nil {
+		for _, k := range keys {
+			h.record(k)
+		}
+	}
+	return values
+}
+
+// SetMulti adds several items to the cache at once, replacing any existing
+// items, all with the same duration, which is interpreted as in Set.
+func (c *cache) SetMulti(items map[string]interface{}, d time.Duration) {
+	e := c.expiration(d)
+	keys := make([]string, 0, len(items))
+	values := make([]interface{}, 0, len(items))
+	h := c.hot.Load()
+	for k, x := range items {
+		keys = append(keys, k)
+		values = append(values, Item{Object: x, Expiration: e, Version: c.version.Add(1)})
+		h.record(k)
+	}
+	idx, observed := c.lockKeys(keys)
+	defer c.unlockKeys(idx, observed)
+	if !observed {
+		c.cacheMap.SetMulti(keys, values)
+		return
+	}
+	old := c.getMulti(keys)
+	c.cacheMap.SetMulti(keys, values)
+	for i, k := range keys {
+		v, found := old[k]
+		c.publishSet(k, v, found, values[i].(Item))
+	}
+}
+
+// getMulti returns the items of keys that are in the cache map.
+func (c *cache) getMulti(keys []string) map[string]interface{} {
+	items := make(map[string]interface{}, len(keys))
+	c.cacheMap.GetMulti(keys, func(k string, v any) {
+		items[k] = v
+	})
+	return items
+}
+
+// DeleteMulti deletes several items from the cache at once. Keys that are not
+// in the cache are ignored.
+func (c *cache) DeleteMulti(keys []string) {
+	idx, observed := c.lockKeys(keys)
+	defer c.unlockKeys(idx, observed)
+	if !observed {
+		c.cacheMap.DeleteMulti(keys)
+		return
+	}
+	old := c.getMulti(keys)
+	c.cacheMap.DeleteMulti(keys)
+	for k, v := range old {
+		c.publishDelete(k, v)
+	}
 }
 
 // Add an item to the cache, replacing any existing item, using the default
@@ -81,9 +384,15 @@ func (c *cache) SetDefault(k string, x interface{}) {
 func (c *cache) GetWithExpiration(k string) (interface{}, time.Time, bool) {
 	value, found := c.cacheMap.Get(k)
 	if !found {
+		c.lookup(k, GetMiss)
 		return nil, time.Time{}, false
 	}
 	item := value.(Item)
+	if item.Expired() {
+		c.lookup(k, GetExpired)
+	} else {
+		c.lookup(k, GetHit)
+	}
 	if item.Expiration <= 0 {
 		return item.Object, time.Time{}, true
 	}
@@ -92,14 +401,56 @@ func (c *cache) GetWithExpiration(k string) (interface{}, time.Time, bool) {
 
 // Delete all expired items from the cache.
 func (c *cache) DeleteExpired() {
+	c.deleteAllExpired()
+}
+
+// expiringMap is implemented by CacheMaps that can find expired items without
+// reading their values, such as ByteMap, which would otherwise decode every
+// value to sweep them.
+type expiringMap interface {
+	RangeExpired(now int64, f func(k string))
+}
+
+// deleteAllExpired deletes all expired items and returns how many it deleted.
+func (c *cache) deleteAllExpired() int {
+	n := 0
 	now := time.Now().UnixNano()
+	if m, ok := c.cacheMap.(expiringMap); ok {
+		m.RangeExpired(now, func(k string) {
+			if c.deleteExpired(k, now) {
+				n++
+			}
+		})
+		return n
+	}
 	c.cacheMap.Range(func(k string, v any) {
 		item := v.(Item)
 		// "Inlining" of expired
-		if item.Expiration > 0 && now > item.Expiration {
-			c.Delete(k)
+		if item.Expiration > 0 && now > item.Expiration && c.deleteExpired(k, now) {
+			n++
 		}
 	})
+	return n
+}
+
+// deleteExpired deletes the item for k if it had expired by now, unless it has
+// been replaced since DeleteExpired found it, and reports whether it did.
+func (c *cache) deleteExpired(k string, now int64) bool {
+	i, observed := c.lockKey(k)
+	defer c.unlockKey(i, observed)
+	var old Item
+	deleted := c.cacheMap.CompareAndDelete(k, func(v interface{}) bool {
+		old = v.(Item)
+		return old.Expiration > 0 && now > old.Expiration
+	})
+	if !deleted {
+		return false
+	}
+	c.stats.expirations.Add(1)
+	if observed {
+		c.events.publish(Event{Type: EventExpire, Key: k, OldValue: old.Object})
+	}
+	return true
 }
 
 // Copies all unexpired items in the cache into a new map and returns it.
@@ -116,6 +467,48 @@ func (c *cache) Items() map[string]Item {
 	return m
 }
 
+// All returns an iterator over the keys and values of all unexpired items in
+// the cache. Unlike Items, it does not copy the cache, and ranging over it may
+// stop early. Whether items set or deleted during iteration are seen depends
+// on the CacheMap backend; see its All method.
+func (c *cache) All() iter.Seq2[string, interface{}] {
+	return func(yield func(string, interface{}) bool) {
+		for k, v := range c.cacheMap.All() {
+			item := v.(Item)
+			if item.Expired() {
+				continue
+			}
+			if !yield(k, item.Object) {
+				return
+			}
+		}
+	}
+}
+
+// Keys returns an iterator over the keys of all unexpired items in the cache.
+// It has the same guarantees as All.
+func (c *cache) Keys() iter.Seq[string] {
+	return func(yield func(string) bool) {
+		for k := range c.All() {
+			if !yield(k) {
+				return
+			}
+		}
+	}
+}
+
+// Values returns an iterator over the values of all unexpired items in the
+// cache. It has the same guarantees as All.
+func (c *cache) Values() iter.Seq[interface{}] {
+	return func(yield func(interface{}) bool) {
+		for _, v := range c.All() {
+			if !yield(v) {
+				return
+			}
+		}
+	}
+}
+
 // Returns the number of items in the cache. This may include items that have
 // expired, but have not yet been cleaned up.
 func (c *cache) ItemCount() int {
@@ -124,12 +517,70 @@ func (c *cache) ItemCount() int {
 
 // Delete all items from the cache.
 func (c *cache) Flush() {
+	c.flush(nil)
+}
+
+// flush deletes all items, publishing the event to every subscriber but
+// skip.
+func (c *cache) flush(skip subscriber) {
+	observed := c.events.active()
+	for i := range c.txLocks {
+		defer c.unlockKey(i, observed)
+		if observed {
+			c.txLocks[i].Lock()
+		} else {
+			c.txLocks[i].RLock()
+		}
+	}
 	c.cacheMap.Flush()
+	if observed {
+		c.events.publishExcept(Event{Type: EventFlush}, skip)
+	}
+}
+
+// TrackHotKeys starts tracking the keys looked up and set most often, for
+// HotKeys to report, replacing any tracking already started. Tracking adds
+// to the cost of each lookup and set, and is off by default.
+func (c *cache) TrackHotKeys(opts HotKeyOptions) {
+	c.hot.Store(newHotKeys(opts))
+}
+
+// StopTrackingHotKeys stops tracking hot keys.
+func (c *cache) StopTrackingHotKeys() {
+	c.hot.Store(nil)
+}
+
+// HotKeys returns up to n of the keys looked up and set most often, hottest
+// first, or nil if hot keys aren't being tracked.
+func (c *cache) HotKeys(n int) []HotKey {
+	return c.hot.Load().hottest(n)
+}
+
+// SetObserver sets the Observer told of the cache's operations, replacing any
+// other, or removes it if o is nil. While the cache has an Observer, writes to
+// keys that share a lock are serialized, as they are while it is watched.
+func (c *cache) SetObserver(o Observer) {
+	c.observing.set(&c.events, o)
+}
+
+// Watch returns a channel that receives an Event for every change to the
+// item for key, or to every item whose key starts with key if opts.Prefix is
+// set, and a function that stops the watch and closes the channel. Events
+// for the same key are received in the order the changes were made. Reading
+// an expired item does not remove it, so its expire event is sent when the
+// janitor or DeleteExpired removes it.
+//
+// While a cache has watchers, writes to keys that share a lock are
+// serialized, so a watcher should keep up with its events or use a policy
+// that does not block writers.
+func (c *cache) Watch(key string, opts WatchOptions) (<-chan Event, func()) {
+	return c.events.watch(key, opts)
 }
 
 type janitor struct {
 	Interval time.Duration
 	stop     chan bool
+	once     sync.Once
 }
 
 func (j *janitor) Run(c *cache) {
@@ -137,7 +588,10 @@ func (j *janitor) Run(c *cache) {
 	for {
 		select {
 		case <-ticker.C:
-			c.DeleteExpired()
+			start := time.Now()
+			n := c.deleteAllExpired()
+			c.stats.sweep(start)
+			c.observing.sweep(start, n)
 		case <-j.stop:
 			ticker.Stop()
 			return
@@ -146,7 +600,16 @@ func (j *janitor) Run(c *cache) {
 }
 
 func stopJanitor(c *Cache) {
-	c.janitor.stop <- true
+	c.janitor.once.Do(func() { close(c.janitor.stop) })
+}
+
+// Close stops the janitor, if the cache has one. The cache can still be used,
+// but expired items are only deleted by calling DeleteExpired.
+func (c *Cache) Close() error {
+	if c.janitor != nil {
+		stopJanitor(c)
+	}
+	return nil
 }
 
 func runJanitor(c *cache, ci time.Duration) {
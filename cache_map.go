@@ -1,17 +1,39 @@
 package cache
 
 import (
+	"iter"
 	"sync"
 	"sync/atomic"
-
-	cmap "github.com/orcaman/concurrent-map"
 )
 
 type CacheMap interface {
 	Get(k string) (interface{}, bool)
 	Set(k string, x interface{})
 	Delete(k string)
+	// Range calls f for each key-value pair in the map. f is not called
+	// with a lock held, so it may modify the map.
 	Range(f func(k string, v any))
+	// All returns an iterator over the key-value pairs in the map. Ranging
+	// over it may stop early, and the loop body may modify the map.
+	All() iter.Seq2[string, any]
+	// GetMulti calls f for each of the keys that is in the map. f may be
+	// called while a lock is held, so it must not access the map.
+	GetMulti(keys []string, f func(k string, v any))
+	// SetMulti sets keys[i] to values[i] for each i.
+	SetMulti(keys []string, values []interface{})
+	DeleteMulti(keys []string)
+	// CompareAndSwap sets k to x if cmp reports true for the current value of
+	// k, atomically with respect to other writes to k, and reports whether it
+	// did. found is false if k is not in the map.
+	CompareAndSwap(k string, x interface{}, cmp func(old interface{}, found bool) bool) bool
+	// CompareAndDelete deletes k if it is in the map and cmp reports true for
+	// its value, atomically with respect to other writes to k, and reports
+	// whether it did.
+	CompareAndDelete(k string, cmp func(old interface{}) bool) bool
+	// Compute calls f with the current value of k and applies the returned
+	// Op, atomically with respect to other writes to k. It returns the value
+	// of k afterwards and whether k is in the map.
+	Compute(k string, f func(old interface{}, found bool) (interface{}, Op)) (interface{}, bool)
 	Count() int
 	Flush()
 }
@@ -47,22 +69,138 @@ func (m *RwmMap) Delete(k string) {
 	m.mu.Unlock()
 }
 
+// CompareAndSwap calls cmp with the lock held.
+func (m *RwmMap) CompareAndSwap(k string, x interface{}, cmp func(old interface{}, found bool) bool) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	old, found := m.items[k]
+	if !cmp(old, found) {
+		return false
+	}
+	m.items[k] = x
+	return true
+}
+
+// CompareAndDelete calls cmp with the lock held.
+func (m *RwmMap) CompareAndDelete(k string, cmp func(old interface{}) bool) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	old, found := m.items[k]
+	if !found || !cmp(old) {
+		return false
+	}
+	delete(m.items, k)
+	return true
+}
+
+// Compute calls f with the lock held.
+func (m *RwmMap) Compute(k string, f func(old interface{}, found bool) (interface{}, Op)) (interface{}, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	old, found := m.items[k]
+	x, op := f(old, found)
+	switch op {
+	case OpSet:
+		m.items[k] = x
+		return x, true
+	case OpDelete:
+		delete(m.items, k)
+		return nil, false
+	}
+	return old, found
+}
+
+// GetMulti takes the read lock once for all the keys.
+func (m *RwmMap) GetMulti(keys []string, f func(k string, v any)) {
+	m.mu.RLock()
+	for _, k := range keys {
+		if item, found := m.items[k]; found {
+			f(k, item)
+		}
+	}
+	m.mu.RUnlock()
+}
+
+// SetMulti takes the lock once for all the items.
+func (m *RwmMap) SetMulti(keys []string, values []interface{}) {
+	m.mu.Lock()
+	for i, k := range keys {
+		m.items[k] = values[i]
+	}
+	m.mu.Unlock()
+}
+
+// DeleteMulti takes the lock once for all the keys.
+func (m *RwmMap) DeleteMulti(keys []string) {
+	m.mu.Lock()
+	for _, k := range keys {
+		delete(m.items, k)
+	}
+	m.mu.Unlock()
+}
+
+// Range calls f for each pair in a snapshot of the map taken under the read
+// lock.
 func (m *RwmMap) Range(f func(k string, v any)) {
+	m.mu.RLock()
+	keys := make([]string, 0, len(m.items))
+	values := make([]interface{}, 0, len(m.items))
 	for k, v := range m.items {
-		f(k, v)
+		keys = append(keys, k)
+		values = append(values, v)
+	}
+	m.mu.RUnlock()
+	for i, k := range keys {
+		f(k, values[i])
+	}
+}
+
+// All iterates over a snapshot of the keys taken under the read lock. Keys
+// deleted after the snapshot are skipped and each value is read when its key
+// is visited, so keys added during iteration are not seen.
+func (m *RwmMap) All() iter.Seq2[string, any] {
+	return func(yield func(string, any) bool) {
+		m.mu.RLock()
+		keys := make([]string, 0, len(m.items))
+		for k := range m.items {
+			keys = append(keys, k)
+		}
+		m.mu.RUnlock()
+		for _, k := range keys {
+			v, found := m.Get(k)
+			if !found {
+				continue
+			}
+			if !yield(k, v) {
+				return
+			}
+		}
 	}
 }
 
 func (m *RwmMap) Count() int {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
 	return len(m.items)
 }
 
+// EntryOverhead returns the bytes used for each entry besides its key and
+// value, which Cache.MemoryUsage assumes for items stored in a RwmMap.
+func (m *RwmMap) EntryOverhead() int64 {
+	// A map slot for the key's header and the value, with the map's
+	// spare room, and the boxed Item.
+	return 48 + 32
+}
+
 func (m *RwmMap) Flush() {
 	m.mu.Lock()
 	m.items = map[string]interface{}{}
 	m.mu.Unlock()
 }
 
+// SyncMap stores a pointer to each value rather than the value itself, so
+// that CompareAndSwap and CompareAndDelete can use the pointer to detect
+// concurrent writes even when the value is not comparable.
 type SyncMap struct {
 	items sync.Map
 	count atomic.Int32
@@ -73,49 +211,184 @@ func NewSyncMap() CacheMap {
 }
 
 func (m *SyncMap) Get(k string) (interface{}, bool) {
-	item, found := m.items.Load(k)
+	p, found := m.items.Load(k)
 	if !found {
 		return nil, false
 	}
-	return item, true
+	return *p.(*interface{}), true
 }
 
 func (m *SyncMap) Set(k string, x interface{}) {
-	m.items.Store(k, x)
-	m.count.Add(1)
+	if _, loaded := m.items.Swap(k, &x); !loaded {
+		m.count.Add(1)
+	}
 }
 
 func (m *SyncMap) Delete(k string) {
-	m.items.Delete(k)
-	m.count.Add(-1)
+	if _, loaded := m.items.LoadAndDelete(k); loaded {
+		m.count.Add(-1)
+	}
+}
+
+// CompareAndSwap retries whenever another write to k lands between loading
+// its value and storing x, so cmp may be called more than once.
+func (m *SyncMap) CompareAndSwap(k string, x interface{}, cmp func(old interface{}, found bool) bool) bool {
+	for {
+		p, found := m.items.Load(k)
+		if !found {
+			if !cmp(nil, false) {
+				return false
+			}
+			if _, loaded := m.items.LoadOrStore(k, &x); !loaded {
+				m.count.Add(1)
+				return true
+			}
+			continue
+		}
+		if !cmp(*p.(*interface{}), true) {
+			return false
+		}
+		if m.items.CompareAndSwap(k, p, &x) {
+			return true
+		}
+	}
+}
+
+// CompareAndDelete retries whenever another write to k lands between loading
+// its value and deleting it, so cmp may be called more than once.
+func (m *SyncMap) CompareAndDelete(k string, cmp func(old interface{}) bool) bool {
+	for {
+		p, found := m.items.Load(k)
+		if !found || !cmp(*p.(*interface{})) {
+			return false
+		}
+		if m.items.CompareAndDelete(k, p) {
+			m.count.Add(-1)
+			return true
+		}
+	}
+}
+
+// Compute retries whenever another write to k lands between loading its value
+// and applying the result of f, so f may be called more than once.
+func (m *SyncMap) Compute(k string, f func(old interface{}, found bool) (interface{}, Op)) (interface{}, bool) {
+	for {
+		p, found := m.items.Load(k)
+		var old interface{}
+		if found {
+			old = *p.(*interface{})
+		}
+		x, op := f(old, found)
+		switch {
+		case op == OpSet && !found:
+			if _, loaded := m.items.LoadOrStore(k, &x); !loaded {
+				m.count.Add(1)
+				return x, true
+			}
+		case op == OpSet:
+			if m.items.CompareAndSwap(k, p, &x) {
+				return x, true
+			}
+		case op == OpDelete && found:
+			if m.items.CompareAndDelete(k, p) {
+				m.count.Add(-1)
+				return nil, false
+			}
+		case op == OpDelete:
+			return nil, false
+		default:
+			return old, found
+		}
+	}
+}
+
+func (m *SyncMap) GetMulti(keys []string, f func(k string, v any)) {
+	for _, k := range keys {
+		if p, found := m.items.Load(k); found {
+			f(k, *p.(*interface{}))
+		}
+	}
+}
+
+func (m *SyncMap) SetMulti(keys []string, values []interface{}) {
+	for i, k := range keys {
+		m.Set(k, values[i])
+	}
+}
+
+func (m *SyncMap) DeleteMulti(keys []string) {
+	for _, k := range keys {
+		m.Delete(k)
+	}
 }
 
 func (m *SyncMap) Range(f func(k string, v any)) {
 	m.items.Range(func(key, value any) bool {
-		f(key.(string), value)
+		f(key.(string), *value.(*interface{}))
 		return true
 	})
 }
 
+// All has the guarantees of sync.Map.Range: no key is visited more than once,
+// but the iteration does not correspond to a consistent snapshot of the map.
+func (m *SyncMap) All() iter.Seq2[string, any] {
+	return func(yield func(string, any) bool) {
+		m.items.Range(func(key, value any) bool {
+			return yield(key.(string), *value.(*interface{}))
+		})
+	}
+}
+
 func (m *SyncMap) Count() int {
 	return int(m.count.Load())
 }
 
+// EntryOverhead returns the bytes used for each entry besides its key and
+// value. A sync.Map may hold each entry in two maps, and SyncMap boxes each
+// value behind a pointer.
+func (m *SyncMap) EntryOverhead() int64 {
+	return 2*32 + 8 + 16 + 32
+}
+
+// Flush deletes the keys one at a time, so writes made while it runs may be
+// kept, and concurrent readers may see some keys deleted and others not.
 func (m *SyncMap) Flush() {
-	m.items = sync.Map{}
-	m.count = atomic.Int32{}
+	m.items.Range(func(key, _ any) bool {
+		if _, loaded := m.items.LoadAndDelete(key); loaded {
+			m.count.Add(-1)
+		}
+		return true
+	})
 }
 
+const shardCount = 32
+
 type ConcurrentMap struct {
-	items cmap.ConcurrentMap
+	shards [shardCount]*mapShard
+}
+
+type mapShard struct {
+	items map[string]interface{}
+	mu    sync.RWMutex
 }
 
 func NewConcurrentMap() CacheMap {
-	return &ConcurrentMap{items: cmap.New()}
+	m := &ConcurrentMap{}
+	for i := range m.shards {
+		m.shards[i] = &mapShard{items: map[string]interface{}{}}
+	}
+	return m
+}
+
+func (m *ConcurrentMap) shard(k string) *mapShard {
+	return m.shards[fnv32(k)%shardCount]
 }
 
 func (m *ConcurrentMap) Get(k string) (interface{}, bool) {
-	item, found := m.items.Get(k)
+	s := m.shard(k)
+	s.mu.RLock()
+	item, found := s.items[k]
+	s.mu.RUnlock()
 	if !found {
 		return nil, false
 	}
@@ -123,23 +396,192 @@ func (m *ConcurrentMap) Get(k string) (interface{}, bool) {
 }
 
 func (m *ConcurrentMap) Set(k string, x interface{}) {
-	m.items.Set(k, x)
+	s := m.shard(k)
+	s.mu.Lock()
+	s.items[k] = x
+	s.mu.Unlock()
 }
 
 func (m *ConcurrentMap) Delete(k string) {
-	m.items.Remove(k)
+	s := m.shard(k)
+	s.mu.Lock()
+	delete(s.items, k)
+	s.mu.Unlock()
+}
+
+// CompareAndSwap calls cmp with the lock of k's shard held.
+func (m *ConcurrentMap) CompareAndSwap(k string, x interface{}, cmp func(old interface{}, found bool) bool) bool {
+	s := m.shard(k)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	old, found := s.items[k]
+	if !cmp(old, found) {
+		return false
+	}
+	s.items[k] = x
+	return true
+}
+
+// CompareAndDelete calls cmp with the lock of k's shard held.
+func (m *ConcurrentMap) CompareAndDelete(k string, cmp func(old interface{}) bool) bool {
+	s := m.shard(k)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	old, found := s.items[k]
+	if !found || !cmp(old) {
+		return false
+	}
+	delete(s.items, k)
+	return true
+}
+
+// Compute calls f with the lock of k's shard held.
+func (m *ConcurrentMap) Compute(k string, f func(old interface{}, found bool) (interface{}, Op)) (interface{}, bool) {
+	s := m.shard(k)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	old, found := s.items[k]
+	x, op := f(old, found)
+	switch op {
+	case OpSet:
+		s.items[k] = x
+		return x, true
+	case OpDelete:
+		delete(s.items, k)
+		return nil, false
+	}
+	return old, found
+}
+
+// GetMulti takes each shard's read lock once for all the keys it holds.
+func (m *ConcurrentMap) GetMulti(keys []string, f func(k string, v any)) {
+	order, bounds := m.group(keys)
+	for i, s := range m.shards {
+		group := order[bounds[i]:bounds[i+1]]
+		if len(group) == 0 {
+			continue
+		}
+		s.mu.RLock()
+		for _, j := range group {
+			if item, found := s.items[keys[j]]; found {
+				f(keys[j], item)
+			}
+		}
+		s.mu.RUnlock()
+	}
+}
+
+// SetMulti takes each shard's lock once for all the items it receives.
+func (m *ConcurrentMap) SetMulti(keys []string, values []interface{}) {
+	order, bounds := m.group(keys)
+	for i, s := range m.shards {
+		group := order[bounds[i]:bounds[i+1]]
+		if len(group) == 0 {
+			continue
+		}
+		s.mu.Lock()
+		for _, j := range group {
+			s.items[keys[j]] = values[j]
+		}
+		s.mu.Unlock()
+	}
+}
+
+// DeleteMulti takes each shard's lock once for all the keys it holds.
+func (m *ConcurrentMap) DeleteMulti(keys []string) {
+	order, bounds := m.group(keys)
+	for i, s := range m.shards {
+		group := order[bounds[i]:bounds[i+1]]
+		if len(group) == 0 {
+			continue
+		}
+		s.mu.Lock()
+		for _, j := range group {
+			delete(s.items, keys[j])
+		}
+		s.mu.Unlock()
+	}
+}
+
+// group sorts the indexes of keys by the shard that holds them. The indexes
+// of the keys in shard i are order[bounds[i]:bounds[i+1]].
+func (m *ConcurrentMap) group(keys []string) (order []int, bounds [shardCount + 1]int) {
+	shards := make([]uint8, len(keys))
+	for j, k := range keys {
+		shards[j] = uint8(fnv32(k) % shardCount)
+		bounds[shards[j]+1]++
+	}
+	for i := 1; i <= shardCount; i++ {
+		bounds[i] += bounds[i-1]
+	}
+	next := bounds
+	order = make([]int, len(keys))
+	for j, i := range shards {
+		order[next[i]] = j
+		next[i]++
+	}
+	return order, bounds
 }
 
 func (m *ConcurrentMap) Range(f func(k string, v any)) {
-	for tuple := range m.items.IterBuffered() {
-		f(tuple.Key, tuple.Val)
+	for k, v := range m.All() {
+		f(k, v)
+	}
+}
+
+// All iterates over a snapshot that is consistent within each shard, but not
+// across shards.
+func (m *ConcurrentMap) All() iter.Seq2[string, any] {
+	return func(yield func(string, any) bool) {
+		for _, s := range m.shards {
+			s.mu.RLock()
+			keys := make([]string, 0, len(s.items))
+			values := make([]interface{}, 0, len(s.items))
+			for k, v := range s.items {
+				keys = append(keys, k)
+				values = append(values, v)
+			}
+			s.mu.RUnlock()
+			for i, k := range keys {
+				if !yield(k, values[i]) {
+					return
+				}
+			}
+		}
 	}
 }
 
 func (m *ConcurrentMap) Count() int {
-	return m.items.Count()
+	count := 0
+	for _, s := range m.shards {
+		s.mu.RLock()
+		count += len(s.items)
+		s.mu.RUnlock()
+	}
+	return count
+}
+
+// EntryOverhead returns the bytes used for each entry besides its key and
+// value, which are the same as for a RwmMap.
+func (m *ConcurrentMap) EntryOverhead() int64 {
+	return 48 + 32
 }
 
 func (m *ConcurrentMap) Flush() {
-	m.items.Clear()
+	for _, s := range m.shards {
+		s.mu.Lock()
+		s.items = map[string]interface{}{}
+		s.mu.Unlock()
+	}
+}
+
+// fnv32 is the 32-bit FNV-1 hash of key.
+func fnv32(key string) uint32 {
+	hash := uint32(2166136261)
+	const prime32 = uint32(16777619)
+	for i := 0; i < len(key); i++ {
+		hash *= prime32
+		hash ^= uint32(key[i])
+	}
+	return hash
 }
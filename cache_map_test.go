@@ -0,0 +1,38 @@
+package cache_test
+
+import (
+	"testing"
+
+	"github.com/wyyadd/go-cache"
+	"github.com/wyyadd/go-cache/cachetest"
+)
+
+func TestRwmMap(t *testing.T) {
+	cachetest.TestCacheMap(t, cache.NewRwmMap)
+}
+
+func TestSyncMap(t *testing.T) {
+	cachetest.TestCacheMap(t, cache.NewSyncMap)
+}
+
+func TestConcurrentMap(t *testing.T) {
+	cachetest.TestCacheMap(t, cache.NewConcurrentMap)
+}
+
+func TestByteMap(t *testing.T) {
+	cachetest.TestCacheMap(t, func() cache.CacheMap {
+		return cache.NewByteMap(cache.ByteMapOptions{ByteCacheOptions: cache.ByteCacheOptions{Shards: 4, Capacity: 1 << 20}})
+	})
+}
+
+func TestCodecMap(t *testing.T) {
+	cachetest.TestCacheMap(t, func() cache.CacheMap {
+		return cache.NewCodecMap(cache.NewConcurrentMap(), cache.GobCodec{}, nil)
+	})
+}
+
+func TestCloneMap(t *testing.T) {
+	cachetest.TestCacheMap(t, func() cache.CacheMap {
+		return cache.NewCloneMap(cache.NewRwmMap())
+	})
+}
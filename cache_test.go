@@ -2,6 +2,7 @@ package cache
 
 import (
 	"runtime"
+	"slices"
 	"strconv"
 	"sync"
 	"testing"
@@ -303,6 +304,282 @@ func testGetWithExpiration(t *testing.T, m CacheMap) {
 	}
 }
 
+func TestAll(t *testing.T) {
+	testAll(t, NewRwmMap())
+	testAll(t, NewSyncMap())
+	testAll(t, NewConcurrentMap())
+}
+
+func testAll(t *testing.T, m CacheMap) {
+	tc := New(DefaultExpiration, 0, m)
+	tc.Set("a", 1, DefaultExpiration)
+	tc.Set("b", 2, DefaultExpiration)
+	tc.Set("c", 3, DefaultExpiration)
+	tc.Set("expired", 4, time.Nanosecond)
+	<-time.After(time.Millisecond)
+
+	seen := map[string]interface{}{}
+	for k, v := range tc.All() {
+		seen[k] = v
+	}
+	if len(seen) != 3 || seen["a"] != 1 || seen["b"] != 2 || seen["c"] != 3 {
+		t.Error("All did not yield exactly the unexpired items:", seen)
+	}
+
+	n := 0
+	for range tc.All() {
+		n++
+		break
+	}
+	if n != 1 {
+		t.Error("All did not stop after break; iterations:", n)
+	}
+
+	keys := 0
+	for k := range tc.Keys() {
+		if k == "expired" {
+			t.Error("Keys yielded an expired key")
+		}
+		keys++
+	}
+	if keys != 3 {
+		t.Errorf("Keys yielded %d keys, want 3", keys)
+	}
+
+	sum := 0
+	for v := range tc.Values() {
+		sum += v.(int)
+	}
+	if sum != 6 {
+		t.Errorf("Values summed to %d, want 6", sum)
+	}
+
+	for k := range tc.Keys() {
+		tc.Delete(k)
+	}
+	if _, found := tc.Get("a"); found {
+		t.Error("a was found after deleting every key during iteration")
+	}
+}
+
+func TestMulti(t *testing.T) {
+	testMulti(t, NewRwmMap())
+	testMulti(t, NewSyncMap())
+	testMulti(t, NewConcurrentMap())
+}
+
+func testMulti(t *testing.T, m CacheMap) {
+	tc := New(DefaultExpiration, 0, m)
+	items := map[string]interface{}{}
+	keys := make([]string, 100)
+	for i := range keys {
+		keys[i] = "foo" + strconv.Itoa(i)
+		items[keys[i]] = i
+	}
+	tc.SetMulti(items, DefaultExpiration)
+	tc.Set("expired", 1, time.Nanosecond)
+	<-time.After(time.Millisecond)
+
+	got := tc.GetMulti(append(keys, "expired", "missing"))
+	if len(got) != len(keys) {
+		t.Errorf("GetMulti returned %d items, want %d", len(got), len(keys))
+	}
+	for i, k := range keys {
+		if got[k] != i {
+			t.Errorf("GetMulti returned %v for %s, want %d", got[k], k, i)
+		}
+	}
+
+	tc.DeleteMulti(append(slices.Clone(keys[:50]), "missing"))
+	if _, found := tc.Get("foo0"); found {
+		t.Error("foo0 was found, but it should have been deleted")
+	}
+	for _, k := range []string{"foo50", "foo99"} {
+		if _, found := tc.Get(k); !found {
+			t.Errorf("%s was not found, but it should not have been deleted", k)
+		}
+	}
+}
+
+func TestCompareAndSwap(t *testing.T) {
+	testCompareAndSwap(t, NewRwmMap())
+	testCompareAndSwap(t, NewSyncMap())
+	testCompareAndSwap(t, NewConcurrentMap())
+}
+
+func testCompareAndSwap(t *testing.T, m CacheMap) {
+	tc := New(DefaultExpiration, 0, m)
+
+	if _, version, found := tc.GetWithVersion("foo"); found || version != 0 {
+		t.Error("Getting foo found a version that shouldn't exist:", version)
+	}
+	if !tc.CompareAndSwap("foo", 0, "bar", DefaultExpiration) {
+		t.Error("CompareAndSwap with version 0 did not add foo")
+	}
+	if tc.CompareAndSwap("foo", 0, "baz", DefaultExpiration) {
+		t.Error("CompareAndSwap with version 0 replaced an existing foo")
+	}
+
+	x, v1, found := tc.GetWithVersion("foo")
+	if !found || x != "bar" || v1 == 0 {
+		t.Error("GetWithVersion did not return bar with a version:", x, v1)
+	}
+	if !tc.CompareAndSwap("foo", v1, "baz", DefaultExpiration) {
+		t.Error("CompareAndSwap with the current version failed")
+	}
+	if tc.CompareAndSwap("foo", v1, "qux", DefaultExpiration) {
+		t.Error("CompareAndSwap with a stale version succeeded")
+	}
+	x, v2, _ := tc.GetWithVersion("foo")
+	if x != "baz" || v2 <= v1 {
+		t.Error("foo was not baz with a newer version:", x, v1, v2)
+	}
+
+	tc.Set("foo", "baz", DefaultExpiration)
+	if tc.CompareAndDelete("foo", v2) {
+		t.Error("CompareAndDelete succeeded after foo was set again")
+	}
+	_, v3, _ := tc.GetWithVersion("foo")
+	if !tc.CompareAndDelete("foo", v3) {
+		t.Error("CompareAndDelete with the current version failed")
+	}
+	if _, found := tc.Get("foo"); found {
+		t.Error("foo was found, but it should have been deleted")
+	}
+
+	tc.Set("expired", 1, time.Nanosecond)
+	<-time.After(time.Millisecond)
+	if !tc.CompareAndSwap("expired", 0, 2, DefaultExpiration) {
+		t.Error("CompareAndSwap with version 0 did not replace an expired item")
+	}
+
+	// Incrementing a counter from many goroutines loses no updates.
+	tc.Set("counter", 0, DefaultExpiration)
+	wg := new(sync.WaitGroup)
+	workers, each := 8, 200
+	wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+			for j := 0; j < each; j++ {
+				for {
+					x, version, _ := tc.GetWithVersion("counter")
+					if tc.CompareAndSwap("counter", version, x.(int)+1, DefaultExpiration) {
+						break
+					}
+				}
+			}
+			wg.Done()
+		}()
+	}
+	wg.Wait()
+	if x, _ := tc.Get("counter"); x != workers*each {
+		t.Errorf("counter is %v, want %d", x, workers*each)
+	}
+}
+
+func TestCompareAndSwapUncomparable(t *testing.T) {
+	tc := New(DefaultExpiration, 0, NewSyncMap())
+	tc.Set("foo", []int{1}, DefaultExpiration)
+	_, version, _ := tc.GetWithVersion("foo")
+	if !tc.CompareAndSwap("foo", version, []int{2}, DefaultExpiration) {
+		t.Error("CompareAndSwap of a slice value failed")
+	}
+}
+
+func TestCompute(t *testing.T) {
+	testCompute(t, NewRwmMap())
+	testCompute(t, NewSyncMap())
+	testCompute(t, NewConcurrentMap())
+}
+
+func testCompute(t *testing.T, m CacheMap) {
+	tc := New(DefaultExpiration, 0, m)
+
+	x, found := tc.Compute("foo", func(old interface{}, found bool) (interface{}, time.Duration, Op) {
+		if found || old != nil {
+			t.Error("Compute found foo that shouldn't exist:", old)
+		}
+		return 1, DefaultExpiration, OpSet
+	})
+	if !found || x != 1 {
+		t.Error("Compute did not add foo:", x, found)
+	}
+
+	x, found = tc.Compute("foo", func(old interface{}, found bool) (interface{}, time.Duration, Op) {
+		return old.(int) + 1, NoExpiration, OpSet
+	})
+	if !found || x != 2 {
+		t.Error("Compute did not replace foo:", x, found)
+	}
+
+	x, found = tc.Compute("foo", func(old interface{}, found bool) (interface{}, time.Duration, Op) {
+		return 100, DefaultExpiration, OpKeep
+	})
+	if !found || x != 2 {
+		t.Error("Compute with OpKeep changed foo:", x, found)
+	}
+	x, found = tc.Compute("missing", func(old interface{}, found bool) (interface{}, time.Duration, Op) {
+		return 100, DefaultExpiration, OpKeep
+	})
+	if found || x != nil {
+		t.Error("Compute with OpKeep added missing:", x, found)
+	}
+
+	x, found = tc.Compute("foo", func(old interface{}, found bool) (interface{}, time.Duration, Op) {
+		return nil, DefaultExpiration, OpDelete
+	})
+	if found || x != nil {
+		t.Error("Compute with OpDelete returned a value:", x, found)
+	}
+	if _, found := tc.Get("foo"); found {
+		t.Error("foo was found, but it should have been deleted")
+	}
+
+	tc.Set("ttl", 1, time.Hour)
+	_, want, _ := tc.GetWithExpiration("ttl")
+	tc.Compute("ttl", func(old interface{}, found bool) (interface{}, time.Duration, Op) {
+		return old.(int) + 1, KeepExpiration, OpSet
+	})
+	if x, e, _ := tc.GetWithExpiration("ttl"); x != 2 || !e.Equal(want) {
+		t.Error("Compute with KeepExpiration changed the expiration:", x, e, want)
+	}
+	tc.Compute("new", func(old interface{}, found bool) (interface{}, time.Duration, Op) {
+		return 1, KeepExpiration, OpSet
+	})
+	if _, e, found := tc.GetWithExpiration("new"); !found || !e.IsZero() {
+		t.Error("Compute with KeepExpiration of a missing item set an expiration:", e)
+	}
+
+	tc.Set("expired", 1, time.Nanosecond)
+	<-time.After(time.Millisecond)
+	tc.Compute("expired", func(old interface{}, found bool) (interface{}, time.Duration, Op) {
+		if found || old != nil {
+			t.Error("Compute found an expired item:", old)
+		}
+		return nil, DefaultExpiration, OpKeep
+	})
+
+	// Appending to a slice from many goroutines loses no updates.
+	wg := new(sync.WaitGroup)
+	workers, each := 8, 200
+	wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+			for j := 0; j < each; j++ {
+				tc.Compute("list", func(old interface{}, found bool) (interface{}, time.Duration, Op) {
+					list, _ := old.([]int)
+					return append(list[:len(list):len(list)], j), DefaultExpiration, OpSet
+				})
+			}
+			wg.Done()
+		}()
+	}
+	wg.Wait()
+	if x, _ := tc.Get("list"); len(x.([]int)) != workers*each {
+		t.Errorf("list has %d elements, want %d", len(x.([]int)), workers*each)
+	}
+}
+
 func BenchmarkGetExpiring_RwmMap(b *testing.B) {
 	benchmarkGet(b, 5*time.Minute, NewRwmMap())
 }
@@ -573,3 +850,239 @@ func benchmarkDeleteExpiredLoop(b *testing.B, m CacheMap) {
 		tc.DeleteExpired()
 	}
 }
+
+func BenchmarkGetMulti_RwmMap(b *testing.B) {
+	benchmarkGetMulti(b, NewRwmMap())
+}
+
+func BenchmarkGetMulti_SyncMap(b *testing.B) {
+	benchmarkGetMulti(b, NewSyncMap())
+}
+
+func BenchmarkGetMulti_ConcurrentMap(b *testing.B) {
+	benchmarkGetMulti(b, NewConcurrentMap())
+}
+
+func benchmarkGetMulti(b *testing.B, m CacheMap) {
+	b.StopTimer()
+	tc, keys := newBatchCache(m)
+	b.StartTimer()
+	for i := 0; i < b.N; i++ {
+		tc.GetMulti(keys)
+	}
+}
+
+func BenchmarkGetLoop_RwmMap(b *testing.B) {
+	benchmarkGetLoop(b, NewRwmMap())
+}
+
+func BenchmarkGetLoop_SyncMap(b *testing.B) {
+	benchmarkGetLoop(b, NewSyncMap())
+}
+
+func BenchmarkGetLoop_ConcurrentMap(b *testing.B) {
+	benchmarkGetLoop(b, NewConcurrentMap())
+}
+
+func benchmarkGetLoop(b *testing.B, m CacheMap) {
+	b.StopTimer()
+	tc, keys := newBatchCache(m)
+	b.StartTimer()
+	for i := 0; i < b.N; i++ {
+		values := make(map[string]interface{}, len(keys))
+		for _, k := range keys {
+			if v, found := tc.Get(k); found {
+				values[k] = v
+			}
+		}
+	}
+}
+
+func BenchmarkGetMultiConcurrent_RwmMap(b *testing.B) {
+	benchmarkGetMultiConcurrent(b, NewRwmMap())
+}
+
+func BenchmarkGetMultiConcurrent_ConcurrentMap(b *testing.B) {
+	benchmarkGetMultiConcurrent(b, NewConcurrentMap())
+}
+
+func benchmarkGetMultiConcurrent(b *testing.B, m CacheMap) {
+	b.StopTimer()
+	tc, keys := newBatchCache(m)
+	wg := new(sync.WaitGroup)
+	workers := runtime.NumCPU()
+	each := b.N / workers
+	wg.Add(workers)
+	b.StartTimer()
+	for i := 0; i < workers; i++ {
+		go func() {
+			for j := 0; j < each; j++ {
+				tc.Set(keys[j%len(keys)], "bar", DefaultExpiration)
+				tc.GetMulti(keys)
+			}
+			wg.Done()
+		}()
+	}
+	wg.Wait()
+}
+
+func BenchmarkGetLoopConcurrent_RwmMap(b *testing.B) {
+	benchmarkGetLoopConcurrent(b, NewRwmMap())
+}
+
+func BenchmarkGetLoopConcurrent_ConcurrentMap(b *testing.B) {
+	benchmarkGetLoopConcurrent(b, NewConcurrentMap())
+}
+
+func benchmarkGetLoopConcurrent(b *testing.B, m CacheMap) {
+	b.StopTimer()
+	tc, keys := newBatchCache(m)
+	wg := new(sync.WaitGroup)
+	workers := runtime.NumCPU()
+	each := b.N / workers
+	wg.Add(workers)
+	b.StartTimer()
+	for i := 0; i < workers; i++ {
+		go func() {
+			for j := 0; j < each; j++ {
+				tc.Set(keys[j%len(keys)], "bar", DefaultExpiration)
+				values := make(map[string]interface{}, len(keys))
+				for _, k := range keys {
+					if v, found := tc.Get(k); found {
+						values[k] = v
+					}
+				}
+			}
+			wg.Done()
+		}()
+	}
+	wg.Wait()
+}
+
+func BenchmarkSetMulti_RwmMap(b *testing.B) {
+	benchmarkSetMulti(b, NewRwmMap())
+}
+
+func BenchmarkSetMulti_SyncMap(b *testing.B) {
+	benchmarkSetMulti(b, NewSyncMap())
+}
+
+func BenchmarkSetMulti_ConcurrentMap(b *testing.B) {
+	benchmarkSetMulti(b, NewConcurrentMap())
+}
+
+func benchmarkSetMulti(b *testing.B, m CacheMap) {
+	b.StopTimer()
+	tc := New(DefaultExpiration, 0, m)
+	items := make(map[string]interface{}, 100)
+	for i := 0; i < 100; i++ {
+		items["foo"+strconv.Itoa(i)] = "bar"
+	}
+	b.StartTimer()
+	for i := 0; i < b.N; i++ {
+		tc.SetMulti(items, DefaultExpiration)
+	}
+}
+
+func BenchmarkSetLoop_RwmMap(b *testing.B) {
+	benchmarkSetLoop(b, NewRwmMap())
+}
+
+func BenchmarkSetLoop_SyncMap(b *testing.B) {
+	benchmarkSetLoop(b, NewSyncMap())
+}
+
+func BenchmarkSetLoop_ConcurrentMap(b *testing.B) {
+	benchmarkSetLoop(b, NewConcurrentMap())
+}
+
+func benchmarkSetLoop(b *testing.B, m CacheMap) {
+	b.StopTimer()
+	tc := New(DefaultExpiration, 0, m)
+	items := make(map[string]interface{}, 100)
+	for i := 0; i < 100; i++ {
+		items["foo"+strconv.Itoa(i)] = "bar"
+	}
+	b.StartTimer()
+	for i := 0; i < b.N; i++ {
+		for k, x := range items {
+			tc.Set(k, x, DefaultExpiration)
+		}
+	}
+}
+
+func BenchmarkSetMultiConcurrent_RwmMap(b *testing.B) {
+	benchmarkSetMultiConcurrent(b, NewRwmMap())
+}
+
+func BenchmarkSetMultiConcurrent_ConcurrentMap(b *testing.B) {
+	benchmarkSetMultiConcurrent(b, NewConcurrentMap())
+}
+
+func benchmarkSetMultiConcurrent(b *testing.B, m CacheMap) {
+	b.StopTimer()
+	tc, keys := newBatchCache(m)
+	items := make(map[string]interface{}, len(keys))
+	for _, k := range keys {
+		items[k] = "bar"
+	}
+	wg := new(sync.WaitGroup)
+	workers := runtime.NumCPU()
+	each := b.N / workers
+	wg.Add(workers)
+	b.StartTimer()
+	for i := 0; i < workers; i++ {
+		go func() {
+			for j := 0; j < each; j++ {
+				tc.SetMulti(items, DefaultExpiration)
+			}
+			wg.Done()
+		}()
+	}
+	wg.Wait()
+}
+
+func BenchmarkSetLoopConcurrent_RwmMap(b *testing.B) {
+	benchmarkSetLoopConcurrent(b, NewRwmMap())
+}
+
+func BenchmarkSetLoopConcurrent_ConcurrentMap(b *testing.B) {
+	benchmarkSetLoopConcurrent(b, NewConcurrentMap())
+}
+
+func benchmarkSetLoopConcurrent(b *testing.B, m CacheMap) {
+	b.StopTimer()
+	tc, keys := newBatchCache(m)
+	items := make(map[string]interface{}, len(keys))
+	for _, k := range keys {
+		items[k] = "bar"
+	}
+	wg := new(sync.WaitGroup)
+	workers := runtime.NumCPU()
+	each := b.N / workers
+	wg.Add(workers)
+	b.StartTimer()
+	for i := 0; i < workers; i++ {
+		go func() {
+			for j := 0; j < each; j++ {
+				for k, x := range items {
+					tc.Set(k, x, DefaultExpiration)
+				}
+			}
+			wg.Done()
+		}()
+	}
+	wg.Wait()
+}
+
+// newBatchCache returns a cache holding 100 items and their keys, the batch
+// size the multi-key benchmarks use.
+func newBatchCache(m CacheMap) (*Cache, []string) {
+	tc := New(DefaultExpiration, 0, m)
+	keys := make([]string, 100)
+	for i := range keys {
+		keys[i] = "foo" + strconv.Itoa(i)
+		tc.Set(keys[i], "bar", DefaultExpiration)
+	}
+	return tc, keys
+}
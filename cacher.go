@@ -0,0 +1,41 @@
+package cache
+
+import "time"
+
+// A Cacher is a cache of items that expire, so code can be written against
+// any of Cache, LRUCache or other implementations. Implementations must be
+// safe for concurrent use, and can be checked with the tests in the cachetest
+// package.
+type Cacher interface {
+	// Get returns the value of k and whether it was found and has not
+	// expired.
+	Get(k string) (interface{}, bool)
+	// GetWithExpiration returns the value of k, its expiration time, or the
+	// zero time if it never expires, and whether it was found. Whether an
+	// item that has expired but not yet been removed is found depends on
+	// the implementation.
+	GetWithExpiration(k string) (interface{}, time.Time, bool)
+	// Set adds an item, replacing any existing item. A duration of
+	// DefaultExpiration uses the cache's default expiration time, and
+	// NoExpiration keeps the item until it is deleted, or evicted by caches
+	// that evict items.
+	Set(k string, x interface{}, d time.Duration)
+	// SetDefault adds an item using the default expiration time.
+	SetDefault(k string, x interface{})
+	// Delete deletes k. It does nothing if k is not in the cache.
+	Delete(k string)
+	// ItemCount returns the number of items, which may include expired
+	// items that have not yet been removed.
+	ItemCount() int
+	// Flush deletes all items.
+	Flush()
+	// Close releases the resources used by the cache, such as goroutines
+	// removing expired items. Closing a cache twice does nothing.
+	Close() error
+}
+
+var (
+	_ Cacher = (*Cache)(nil)
+	_ Cacher = (*LRUCache)(nil)
+	_ Cacher = (*Tiered)(nil)
+)
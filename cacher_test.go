@@ -0,0 +1,48 @@
+package cache_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/wyyadd/go-cache"
+	"github.com/wyyadd/go-cache/cachetest"
+)
+
+func TestCacher(t *testing.T) {
+	maps := map[string]func() cache.CacheMap{
+		"RwmMap":        cache.NewRwmMap,
+		"SyncMap":       cache.NewSyncMap,
+		"ConcurrentMap": cache.NewConcurrentMap,
+	}
+	for name, newMap := range maps {
+		t.Run(name, func(t *testing.T) {
+			cachetest.TestCacher(t, func() cache.Cacher {
+				return cache.New(time.Minute, time.Minute, newMap())
+			})
+		})
+	}
+	t.Run("LRUCache", func(t *testing.T) {
+		cachetest.TestCacher(t, func() cache.Cacher {
+			return cache.NewLRUCache(100, time.Minute, time.Minute)
+		})
+	})
+	t.Run("Tiered", func(t *testing.T) {
+		cachetest.TestCacher(t, func() cache.Cacher {
+			l1 := cache.NewLRUCache(100, time.Minute, time.Minute)
+			l2 := cache.New(time.Minute, time.Minute, cache.NewConcurrentMap())
+			return tieredCacher{cache.NewTiered(l1, l2, cache.TieredOptions{}), l1, l2}
+		})
+	})
+}
+
+// tieredCacher is a Tiered cache that closes its tiers when it is closed.
+type tieredCacher struct {
+	*cache.Tiered
+	l1, l2 cache.Tier
+}
+
+func (c tieredCacher) Close() error {
+	c.Tiered.Close()
+	c.l1.Close()
+	return c.l2.Close()
+}
@@ -0,0 +1,348 @@
+package cachetest
+
+import (
+	"fmt"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/wyyadd/go-cache"
+)
+
+// TestCacheMap runs the conformance tests for implementations of
+// cache.CacheMap as subtests of t, each on an empty map returned by newMap.
+// Several of the tests use the map from many goroutines, and are best run
+// with the race detector enabled.
+func TestCacheMap(t *testing.T, newMap func() cache.CacheMap) {
+	tests := []struct {
+		name string
+		test func(*testing.T, cache.CacheMap)
+	}{
+		{"GetSetDelete", testMapGetSetDelete},
+		{"Count", testMapCount},
+		{"Multi", testMapMulti},
+		{"CompareAndSwap", testMapCompareAndSwap},
+		{"Compute", testMapCompute},
+		{"Range", testMapRange},
+		{"ConcurrentRange", testMapConcurrentRange},
+		{"ConcurrentCompute", testMapConcurrentCompute},
+		{"FlushUnderLoad", testMapFlushUnderLoad},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.test(t, newMap())
+		})
+	}
+}
+
+// contents returns the pairs in m, as seen by Range.
+func contents(m cache.CacheMap) map[string]interface{} {
+	items := map[string]interface{}{}
+	m.Range(func(k string, v any) { items[k] = v })
+	return items
+}
+
+func testMapGetSetDelete(t *testing.T, m cache.CacheMap) {
+	if x, found := m.Get("a"); found {
+		t.Error("Get found a key that was never set:", x)
+	}
+	m.Set("a", 1)
+	m.Set("b", nil)
+	m.Set("a", 2)
+	if x, found := m.Get("a"); !found || x != 2 {
+		t.Error("Get returned", x, found, "for an overwritten key")
+	}
+	if x, found := m.Get("b"); !found || x != nil {
+		t.Error("Get returned", x, found, "for a key set to nil")
+	}
+	m.Delete("a")
+	m.Delete("missing")
+	if x, found := m.Get("a"); found {
+		t.Error("Get found a deleted key:", x)
+	}
+}
+
+func testMapCount(t *testing.T, m cache.CacheMap) {
+	check := func(what string, want int) {
+		t.Helper()
+		if n := m.Count(); n != want {
+			t.Errorf("Count is %d after %s, want %d", n, what, want)
+		}
+	}
+	check("nothing", 0)
+	m.Set("a", 1)
+	m.Set("b", 2)
+	check("setting 2 keys", 2)
+	m.Set("a", 3)
+	m.SetMulti([]string{"b", "c"}, []interface{}{4, 5})
+	check("overwriting keys", 3)
+	m.Delete("missing")
+	m.DeleteMulti([]string{"missing", "c"})
+	check("deleting missing keys", 2)
+	m.CompareAndSwap("d", 6, func(interface{}, bool) bool { return true })
+	m.CompareAndSwap("d", 7, func(interface{}, bool) bool { return true })
+	m.CompareAndSwap("e", 8, func(interface{}, bool) bool { return false })
+	check("CompareAndSwap", 3)
+	m.CompareAndDelete("d", func(interface{}) bool { return false })
+	m.CompareAndDelete("missing", func(interface{}) bool { return true })
+	m.CompareAndDelete("a", func(interface{}) bool { return true })
+	check("CompareAndDelete", 2)
+	set := func(interface{}, bool) (interface{}, cache.Op) { return 9, cache.OpSet }
+	del := func(interface{}, bool) (interface{}, cache.Op) { return nil, cache.OpDelete }
+	m.Compute("f", set)
+	m.Compute("f", set)
+	m.Compute("missing", del)
+	m.Compute("b", del)
+	check("Compute", 2)
+	m.Flush()
+	check("Flush", 0)
+	m.Set("a", 1)
+	check("setting a key after Flush", 1)
+}
+
+func testMapMulti(t *testing.T, m cache.CacheMap) {
+	m.SetMulti([]string{"a", "b", "c", "d"}, []interface{}{1, 2, 3, 4})
+	got := map[string]interface{}{}
+	m.GetMulti([]string{"a", "c", "missing"}, func(k string, v any) { got[k] = v })
+	if len(got) != 2 || got["a"] != 1 || got["c"] != 3 {
+		t.Error("GetMulti returned", got)
+	}
+	m.DeleteMulti([]string{"a", "b", "missing"})
+	if items := contents(m); len(items) != 2 || items["c"] != 3 || items["d"] != 4 {
+		t.Error("the map holds", items, "after DeleteMulti")
+	}
+	m.SetMulti(nil, nil)
+	m.GetMulti(nil, func(k string, v any) { t.Error("GetMulti of no keys called f with", k) })
+	m.DeleteMulti(nil)
+}
+
+func testMapCompareAndSwap(t *testing.T, m cache.CacheMap) {
+	if !m.CompareAndSwap("a", 1, func(old interface{}, found bool) bool { return !found }) {
+		t.Error("CompareAndSwap did not set a missing key")
+	}
+	if m.CompareAndSwap("a", 2, func(old interface{}, found bool) bool { return !found }) {
+		t.Error("CompareAndSwap set a key that cmp rejected")
+	}
+	if !m.CompareAndSwap("a", 3, func(old interface{}, found bool) bool { return found && old == 1 }) {
+		t.Error("CompareAndSwap did not replace a value that cmp accepted")
+	}
+	if x, _ := m.Get("a"); x != 3 {
+		t.Error("Get returned", x, "after CompareAndSwap")
+	}
+	if m.CompareAndDelete("a", func(old interface{}) bool { return old == 1 }) {
+		t.Error("CompareAndDelete deleted a key that cmp rejected")
+	}
+	if !m.CompareAndDelete("a", func(old interface{}) bool { return old == 3 }) {
+		t.Error("CompareAndDelete did not delete a key that cmp accepted")
+	}
+	if _, found := m.Get("a"); found {
+		t.Error("Get found a key deleted by CompareAndDelete")
+	}
+	// Values that can't be compared with == must work too.
+	m.Set("slice", []int{1})
+	if !m.CompareAndSwap("slice", []int{2}, func(interface{}, bool) bool { return true }) {
+		t.Error("CompareAndSwap did not replace a slice")
+	}
+}
+
+func testMapCompute(t *testing.T, m cache.CacheMap) {
+	incr := func(old interface{}, found bool) (interface{}, cache.Op) {
+		if !found {
+			return 1, cache.OpSet
+		}
+		return old.(int) + 1, cache.OpSet
+	}
+	m.Compute("n", incr)
+	if x, found := m.Compute("n", incr); !found || x != 2 {
+		t.Error("Compute returned", x, found)
+	}
+	if x, found := m.Compute("n", func(old interface{}, found bool) (interface{}, cache.Op) {
+		return 5, cache.OpKeep
+	}); !found || x != 2 {
+		t.Error("Compute with OpKeep returned", x, found)
+	}
+	if x, found := m.Compute("missing", func(interface{}, bool) (interface{}, cache.Op) {
+		return 5, cache.OpKeep
+	}); found {
+		t.Error("Compute with OpKeep of a missing key returned", x, found)
+	}
+	if x, found := m.Compute("n", func(interface{}, bool) (interface{}, cache.Op) {
+		return nil, cache.OpDelete
+	}); found {
+		t.Error("Compute with OpDelete returned", x, found)
+	}
+	if _, found := m.Get("n"); found {
+		t.Error("Compute with OpDelete left the key")
+	}
+}
+
+func testMapRange(t *testing.T, m cache.CacheMap) {
+	for i := 0; i < 100; i++ {
+		m.Set(fmt.Sprint(i), i)
+	}
+	// The callback may modify the map.
+	m.Range(func(k string, v any) {
+		if v.(int)%2 == 0 {
+			m.Delete(k)
+		}
+	})
+	items := contents(m)
+	if len(items) != 50 || m.Count() != 50 {
+		t.Errorf("the map holds %d pairs and counts %d after deleting half of 100, want 50", len(items), m.Count())
+	}
+	for k, v := range items {
+		if k != fmt.Sprint(v) || v.(int)%2 == 0 {
+			t.Errorf("Range returned %q: %v", k, v)
+		}
+	}
+	var keys []string
+	for k := range m.All() {
+		keys = append(keys, k)
+		if len(keys) == 3 {
+			break
+		}
+	}
+	if len(keys) != 3 {
+		t.Error("All yielded", keys, "before stopping")
+	}
+	keys = keys[:0]
+	for k := range m.All() {
+		m.Delete(k)
+		keys = append(keys, k)
+	}
+	if len(keys) != 50 || m.Count() != 0 {
+		t.Errorf("ranging over All and deleting each key visited %d keys and left %d, want 50 and 0", len(keys), m.Count())
+	}
+}
+
+// load runs op(g, i) for i from 0 to 999 in each of 4 goroutines g, and
+// returns a channel that is closed when they have finished.
+func load(op func(g, i int)) <-chan struct{} {
+	var wg sync.WaitGroup
+	for g := 0; g < 4; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 1000; i++ {
+				op(g, i)
+			}
+		}()
+	}
+	finished := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(finished)
+	}()
+	return finished
+}
+
+// finished reports whether ch is closed, yielding the processor first so
+// that the goroutines loading the map make progress.
+func finished(ch <-chan struct{}) bool {
+	runtime.Gosched()
+	select {
+	case <-ch:
+		return true
+	default:
+		return false
+	}
+}
+
+func testMapConcurrentRange(t *testing.T, m cache.CacheMap) {
+	for i := 0; i < 100; i++ {
+		m.Set(fmt.Sprint("stable", i), i)
+	}
+	done := load(func(g, i int) {
+		k := fmt.Sprint(g, ":", i%50)
+		m.Set(k, i)
+		if i%3 == 0 {
+			m.Delete(k)
+		}
+		m.Count()
+	})
+	for failed := false; ; {
+		stable := 0
+		m.Range(func(k string, v any) {
+			if strings.HasPrefix(k, "stable") {
+				stable++
+			}
+		})
+		for range m.All() {
+		}
+		if stable != 100 && !failed {
+			t.Errorf("Range during Sets visited %d of 100 keys that were not changed", stable)
+			failed = true
+		}
+		if finished(done) {
+			break
+		}
+	}
+	if n, items := m.Count(), contents(m); n != len(items) {
+		t.Errorf("Count is %d, but the map holds %d pairs", n, len(items))
+	}
+}
+
+func testMapConcurrentCompute(t *testing.T, m cache.CacheMap) {
+	const goroutines, increments = 8, 200
+	var wg sync.WaitGroup
+	for g := 0; g < goroutines; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < increments; i++ {
+				m.Compute("n", func(old interface{}, found bool) (interface{}, cache.Op) {
+					if !found {
+						return 1, cache.OpSet
+					}
+					return old.(int) + 1, cache.OpSet
+				})
+				for {
+					old, _ := m.Get("cas")
+					if m.CompareAndSwap("cas", i, func(v interface{}, found bool) bool {
+						return v == old
+					}) {
+						break
+					}
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if x, _ := m.Get("n"); x != goroutines*increments {
+		t.Errorf("concurrent Computes counted to %v, want %d", x, goroutines*increments)
+	}
+	if n := m.Count(); n != 2 {
+		t.Error("Count is", n, "after concurrent Computes and CompareAndSwaps of 2 keys, want 2")
+	}
+}
+
+func testMapFlushUnderLoad(t *testing.T, m cache.CacheMap) {
+	done := load(func(g, i int) {
+		k := fmt.Sprint(g, ":", i%100)
+		switch i % 4 {
+		case 0:
+			m.SetMulti([]string{k, k + "+"}, []interface{}{i, i})
+		case 1:
+			m.Delete(k)
+		case 2:
+			m.Compute(k, func(interface{}, bool) (interface{}, cache.Op) { return i, cache.OpSet })
+		default:
+			m.Set(k, i)
+		}
+	})
+	for {
+		m.Flush()
+		m.Range(func(string, any) {})
+		if finished(done) {
+			break
+		}
+	}
+	if n, items := m.Count(), contents(m); n != len(items) {
+		t.Errorf("Count is %d, but the map holds %d pairs", n, len(items))
+	}
+	m.Flush()
+	if n, items := m.Count(), contents(m); n != 0 || len(items) != 0 {
+		t.Errorf("Count is %d and the map holds %d pairs after Flush, want 0", n, len(items))
+	}
+}
@@ -0,0 +1,195 @@
+// Package cachetest checks that implementations of cache.Cacher and
+// cache.CacheMap behave as the cache package's own do. An implementation's
+// tests run the checks with a function that creates empty caches or maps:
+//
+//	func TestCacher(t *testing.T) {
+//		cachetest.TestCacher(t, func() cache.Cacher {
+//			return mycache.New(1000)
+//		})
+//	}
+package cachetest
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/wyyadd/go-cache"
+)
+
+// TestCacher runs the conformance tests as subtests of t, each on a cache
+// returned by newCache. The caches must be empty, hold at least 100 items,
+// and have a default expiration time of a minute or more, or none.
+func TestCacher(t *testing.T, newCache func() cache.Cacher) {
+	tests := []struct {
+		name string
+		test func(*testing.T, cache.Cacher)
+	}{
+		{"GetSet", testGetSet},
+		{"Delete", testDelete},
+		{"Expiration", testExpiration},
+		{"GetWithExpiration", testGetWithExpiration},
+		{"ItemCount", testItemCount},
+		{"Flush", testFlush},
+		{"Close", testClose},
+		{"Concurrent", testConcurrent},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newCache()
+			defer c.Close()
+			tt.test(t, c)
+		})
+	}
+}
+
+func testGetSet(t *testing.T, c cache.Cacher) {
+	if x, found := c.Get("a"); found {
+		t.Error("Get found a key that was never set:", x)
+	}
+	c.Set("a", 1, cache.DefaultExpiration)
+	c.Set("b", "b", cache.NoExpiration)
+	c.SetDefault("c", 3.5)
+	c.Set("d", nil, time.Hour)
+	for k, want := range map[string]interface{}{"a": 1, "b": "b", "c": 3.5, "d": nil} {
+		if x, found := c.Get(k); !found || x != want {
+			t.Errorf("Get(%q) returned %v, %v, want %v, true", k, x, found, want)
+		}
+	}
+	c.Set("a", 2, cache.DefaultExpiration)
+	if x, found := c.Get("a"); !found || x != 2 {
+		t.Error("Set did not replace an item; Get returned", x, found)
+	}
+}
+
+func testDelete(t *testing.T, c cache.Cacher) {
+	c.Set("a", 1, cache.NoExpiration)
+	c.Set("b", 2, cache.NoExpiration)
+	c.Delete("a")
+	c.Delete("missing")
+	if x, found := c.Get("a"); found {
+		t.Error("Get found a deleted key:", x)
+	}
+	if x, found := c.Get("b"); !found || x != 2 {
+		t.Error("Delete removed another key; Get returned", x, found)
+	}
+	c.Set("a", 3, cache.NoExpiration)
+	if x, found := c.Get("a"); !found || x != 3 {
+		t.Error("Get returned", x, found, "for a key set again after being deleted")
+	}
+}
+
+func testExpiration(t *testing.T, c cache.Cacher) {
+	c.Set("short", 1, 20*time.Millisecond)
+	c.Set("long", 2, time.Hour)
+	c.Set("never", 3, cache.NoExpiration)
+	c.SetDefault("default", 4)
+	if _, found := c.Get("short"); !found {
+		t.Error("an item expired early")
+	}
+	<-time.After(40 * time.Millisecond)
+	if x, found := c.Get("short"); found {
+		t.Error("Get found an expired item:", x)
+	}
+	for _, k := range []string{"long", "never", "default"} {
+		if _, found := c.Get(k); !found {
+			t.Errorf("%q expired early", k)
+		}
+	}
+	c.Set("short", 5, cache.NoExpiration)
+	if x, found := c.Get("short"); !found || x != 5 {
+		t.Error("Get returned", x, found, "for an expired key set again")
+	}
+}
+
+func testGetWithExpiration(t *testing.T, c cache.Cacher) {
+	if x, _, found := c.GetWithExpiration("missing"); found {
+		t.Error("GetWithExpiration found a key that was never set:", x)
+	}
+	c.Set("never", 1, cache.NoExpiration)
+	if x, e, found := c.GetWithExpiration("never"); !found || x != 1 || !e.IsZero() {
+		t.Error("GetWithExpiration returned", x, e, found, "for an item that never expires")
+	}
+	start := time.Now()
+	c.Set("hour", 2, time.Hour)
+	end := time.Now()
+	x, e, found := c.GetWithExpiration("hour")
+	if !found || x != 2 || e.Before(start.Add(time.Hour)) || e.After(end.Add(time.Hour)) {
+		t.Error("GetWithExpiration returned", x, e, found, "for an item set to expire in an hour")
+	}
+	c.SetDefault("default", 3)
+	if _, e, found := c.GetWithExpiration("default"); !found || (!e.IsZero() && time.Until(e) < 59*time.Second) {
+		t.Error("GetWithExpiration returned", e, found, "for an item set with the default expiration")
+	}
+}
+
+func testItemCount(t *testing.T, c cache.Cacher) {
+	if n := c.ItemCount(); n != 0 {
+		t.Fatal("a new cache has", n, "items")
+	}
+	for i := 0; i < 10; i++ {
+		c.Set(fmt.Sprint(i), i, cache.NoExpiration)
+	}
+	c.Set("0", 0, cache.NoExpiration)
+	if n := c.ItemCount(); n != 10 {
+		t.Error("ItemCount is", n, "after setting 10 keys, want 10")
+	}
+	c.Delete("0")
+	c.Delete("missing")
+	if n := c.ItemCount(); n != 9 {
+		t.Error("ItemCount is", n, "after deleting a key, want 9")
+	}
+}
+
+func testFlush(t *testing.T, c cache.Cacher) {
+	c.Set("a", 1, cache.NoExpiration)
+	c.Set("b", 2, time.Hour)
+	c.Flush()
+	if n := c.ItemCount(); n != 0 {
+		t.Error("ItemCount is", n, "after Flush")
+	}
+	if x, found := c.Get("a"); found {
+		t.Error("Get found a flushed item:", x)
+	}
+	c.Set("a", 3, cache.NoExpiration)
+	if x, found := c.Get("a"); !found || x != 3 {
+		t.Error("Get returned", x, found, "for a key set after Flush")
+	}
+}
+
+func testClose(t *testing.T, c cache.Cacher) {
+	c.Set("a", 1, cache.NoExpiration)
+	if err := c.Close(); err != nil {
+		t.Error("Close failed:", err)
+	}
+	if err := c.Close(); err != nil {
+		t.Error("a second Close failed:", err)
+	}
+}
+
+func testConcurrent(t *testing.T, c cache.Cacher) {
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 200; i++ {
+				k := fmt.Sprint((g + i) % 20)
+				switch i % 4 {
+				case 0, 1:
+					c.Set(k, i, cache.DefaultExpiration)
+				case 2:
+					c.Get(k)
+				case 3:
+					c.Delete(k)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	c.Set("done", true, cache.NoExpiration)
+	if x, found := c.Get("done"); !found || x != true {
+		t.Error("Get returned", x, found, "after concurrent use")
+	}
+}
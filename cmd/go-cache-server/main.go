@@ -0,0 +1,162 @@
+// Command go-cache-server serves a cache over the Redis and memcached
+// protocols, and optionally over HTTP.
+//
+// Usage:
+//
+//	go-cache-server [flags]
+//
+// The flags are:
+//
+//	-addr address
+//		Listen for Redis clients on address (default ":6379"). An address
+//		starting with "/" or "@" is a Unix socket.
+//	-memcache-addr address
+//		Also listen for memcached clients on address, such as ":11211".
+//	-http-addr address
+//		Also serve the HTTP admin and data API on address, such as ":8080",
+//		and the cache's metrics for Prometheus at /metrics.
+//	-backend name
+//		The CacheMap to store items in: rwm, sync or concurrent (default
+//		concurrent).
+//	-cleanup-interval duration
+//		How often expired items are deleted (default 1m).
+//	-aof path
+//		Append every change to the file at path, and restore the cache from
+//		it on startup.
+//	-fsync policy
+//		How often the append-only file is flushed: always, everysec or no
+//		(default everysec).
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	cache "github.com/wyyadd/go-cache"
+	"github.com/wyyadd/go-cache/server"
+)
+
+func main() {
+	addr := flag.String("addr", ":6379", "listen for Redis clients on `address`")
+	backend := flag.String("backend", "concurrent", "the CacheMap to store items in: rwm, sync or concurrent")
+	cleanup := flag.Duration("cleanup-interval", time.Minute, "how often expired items are deleted")
+	aofPath := flag.String("aof", "", "append every change to the file at `path` and restore from it on startup")
+	memcacheAddr := flag.String("memcache-addr", "", "also listen for memcached clients on `address`")
+	httpAddr := flag.String("http-addr", "", "also serve the HTTP admin API and metrics on `address`")
+	fsync := flag.String("fsync", "everysec", "how often the append-only file is flushed: always, everysec or no")
+	flag.Parse()
+	log.SetPrefix("go-cache-server: ")
+	log.SetFlags(0)
+
+	m, err := newCacheMap(*backend)
+	if err != nil {
+		log.Fatal(err)
+	}
+	c := cache.New(cache.NoExpiration, *cleanup, m)
+
+	var aof *cache.AOF
+	if *aofPath != "" {
+		policy, err := parseFsync(*fsync)
+		if err != nil {
+			log.Fatal(err)
+		}
+		aof, err = cache.OpenAOF(c, *aofPath, cache.AOFOptions{Fsync: policy, RewriteMinSize: 64 << 20})
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("restored %d items from %s", c.ItemCount(), *aofPath)
+	}
+
+	services := []service{{"the Redis protocol", *addr, server.NewRESPServer(c)}}
+	if *memcacheAddr != "" {
+		services = append(services, service{"the memcached protocol", *memcacheAddr, server.NewMemcacheServer(c)})
+	}
+	if *httpAddr != "" {
+		metrics := server.NewMetricsHandler(server.MetricsOptions{})
+		metrics.Register("default", c)
+		mux := http.NewServeMux()
+		mux.Handle("GET /metrics", metrics)
+		mux.Handle("/", server.NewHTTPHandler(c, server.HTTPOptions{}))
+		services = append(services, service{"the HTTP API", *httpAddr, &http.Server{Handler: mux}})
+	}
+	errc := make(chan error, len(services))
+	for _, s := range services {
+		l, err := listen(s.addr)
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("serving %s on %s", s.name, l.Addr())
+		go func() { errc <- s.srv.Serve(l) }()
+	}
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	err = nil
+	select {
+	case <-sig:
+	case err = <-errc:
+	}
+	for _, s := range services {
+		s.srv.Close()
+	}
+	if aof != nil {
+		if cerr := aof.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// A service is a server listening on an address.
+type service struct {
+	name string
+	addr string
+	srv  interface {
+		Serve(l net.Listener) error
+		Close() error
+	}
+}
+
+// newCacheMap returns a new CacheMap of the named backend.
+func newCacheMap(backend string) (cache.CacheMap, error) {
+	switch backend {
+	case "rwm":
+		return cache.NewRwmMap(), nil
+	case "sync":
+		return cache.NewSyncMap(), nil
+	case "concurrent":
+		return cache.NewConcurrentMap(), nil
+	}
+	return nil, fmt.Errorf("unknown backend %q", backend)
+}
+
+func parseFsync(s string) (cache.FsyncPolicy, error) {
+	switch s {
+	case "always":
+		return cache.FsyncAlways, nil
+	case "everysec":
+		return cache.FsyncEverySecond, nil
+	case "no":
+		return cache.FsyncNever, nil
+	}
+	return 0, fmt.Errorf("unknown fsync policy %q", s)
+}
+
+// listen listens on a TCP address, or on a Unix socket if addr is a path or
+// an abstract socket name.
+func listen(addr string) (net.Listener, error) {
+	if strings.HasPrefix(addr, "/") || strings.HasPrefix(addr, "@") {
+		return net.Listen("unix", addr)
+	}
+	return net.Listen("tcp", addr)
+}
@@ -0,0 +1,358 @@
+// Command go-cache inspects and manipulates the snapshot files written by a
+// cache's Save and SaveFile methods.
+//
+// Usage:
+//
+//	go-cache <command> [flags] [arguments]
+//
+// The commands are:
+//
+//	inspect [-prefix p] file
+//		List the items in a snapshot with their types, expiration times and
+//		versions.
+//	get [-expired] file key
+//		Print the value of an item as JSON.
+//	keys [-prefix p] [-expired] file
+//		Print the keys of the items that have not expired.
+//	stats file
+//		Summarize a snapshot: its numbers of items, expired items and
+//		values of each type.
+//	expire-report file
+//		Show how many items expire within a minute, an hour, a day and a
+//		week.
+//	convert in out
+//		Convert a snapshot between Gob and JSON.
+//	merge -o out file...
+//		Merge snapshots, dropping expired items. Items in later files
+//		replace those in earlier ones.
+//
+// Snapshots whose names end in .json are JSON, in the format written by
+// convert; others are Gob, as written by Save. Gob snapshots can only be read
+// if their values are of basic types, slices of them, or memcached values.
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	cache "github.com/wyyadd/go-cache"
+)
+
+// errUsage is returned by commands given bad arguments, after they have
+// printed how to use them.
+var errUsage = errors.New("usage")
+
+type command struct {
+	args string
+	run  func(fs *flag.FlagSet, args []string, w io.Writer) error
+}
+
+var commands map[string]command
+
+func init() {
+	commands = map[string]command{
+		"inspect":       {"[-prefix p] file", inspect},
+		"get":           {"[-expired] file key", get},
+		"keys":          {"[-prefix p] [-expired] file", keys},
+		"stats":         {"file", stats},
+		"expire-report": {"file", expireReport},
+		"convert":       {"in out", convert},
+		"merge":         {"-o out file...", merge},
+	}
+}
+
+func main() {
+	err := run(os.Args[1:], os.Stdout, os.Stderr)
+	if errors.Is(err, errUsage) {
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "go-cache:", err)
+		os.Exit(1)
+	}
+}
+
+// run runs the command named by args[0], writing its output to w and usage
+// messages to errw.
+func run(args []string, w, errw io.Writer) error {
+	if len(args) == 0 {
+		usage(errw)
+		return errUsage
+	}
+	cmd, ok := commands[args[0]]
+	if !ok {
+		fmt.Fprintf(errw, "go-cache: unknown command %q\n", args[0])
+		usage(errw)
+		return errUsage
+	}
+	fs := flag.NewFlagSet(args[0], flag.ContinueOnError)
+	fs.SetOutput(errw)
+	fs.Usage = func() {
+		fmt.Fprintf(errw, "usage: go-cache %s %s\n", args[0], cmd.args)
+		fs.PrintDefaults()
+	}
+	err := cmd.run(fs, args[1:], w)
+	if errors.Is(err, flag.ErrHelp) {
+		return errUsage
+	}
+	if errors.Is(err, errUsage) {
+		fs.Usage()
+	}
+	return err
+}
+
+func usage(w io.Writer) {
+	fmt.Fprintln(w, "usage: go-cache <command> [flags] [arguments]\n\ncommands:")
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	for _, name := range names {
+		fmt.Fprintf(w, "\t%s %s\n", name, commands[name].args)
+	}
+}
+
+// parse parses the flags in args and checks that n arguments follow them,
+// or at least -n if n is negative.
+func parse(fs *flag.FlagSet, args []string, n int) error {
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if (n >= 0 && fs.NArg() != n) || (n < 0 && fs.NArg() < -n) {
+		return errUsage
+	}
+	return nil
+}
+
+// sortedKeys returns the keys of items that start with prefix, in order.
+func sortedKeys(items map[string]cache.Item, prefix string) []string {
+	var keys []string
+	for k := range items {
+		if strings.HasPrefix(k, prefix) {
+			keys = append(keys, k)
+		}
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+func formatExpiration(item cache.Item, now time.Time) string {
+	if item.Expiration == 0 {
+		return "never"
+	}
+	e := time.Unix(0, item.Expiration)
+	s := e.UTC().Format(time.RFC3339)
+	if item.Expiration <= now.UnixNano() {
+		return s + " (expired)"
+	}
+	return s + " (in " + now.Sub(e).Abs().Round(time.Second).String() + ")"
+}
+
+func inspect(fs *flag.FlagSet, args []string, w io.Writer) error {
+	prefix := fs.String("prefix", "", "only list keys that start with `p`")
+	if err := parse(fs, args, 1); err != nil {
+		return err
+	}
+	items, err := readSnapshot(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "KEY\tTYPE\tEXPIRES\tVERSION")
+	for _, k := range sortedKeys(items, *prefix) {
+		item := items[k]
+		fmt.Fprintf(tw, "%q\t%s\t%s\t%d\n", k, typeName(item.Object), formatExpiration(item, now), item.Version)
+	}
+	return tw.Flush()
+}
+
+func get(fs *flag.FlagSet, args []string, w io.Writer) error {
+	expired := fs.Bool("expired", false, "print the value even if the item has expired")
+	if err := parse(fs, args, 2); err != nil {
+		return err
+	}
+	items, err := readSnapshot(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	k := fs.Arg(1)
+	item, found := items[k]
+	if !found {
+		return fmt.Errorf("%q not found", k)
+	}
+	if item.Expired() && !*expired {
+		return fmt.Errorf("%q expired at %s", k, time.Unix(0, item.Expiration).UTC().Format(time.RFC3339))
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "\t")
+	return enc.Encode(item.Object)
+}
+
+func keys(fs *flag.FlagSet, args []string, w io.Writer) error {
+	prefix := fs.String("prefix", "", "only print keys that start with `p`")
+	expired := fs.Bool("expired", false, "also print the keys of expired items")
+	if err := parse(fs, args, 1); err != nil {
+		return err
+	}
+	items, err := readSnapshot(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	for _, k := range sortedKeys(items, *prefix) {
+		if item := items[k]; *expired || !item.Expired() {
+			fmt.Fprintln(w, k)
+		}
+	}
+	return nil
+}
+
+func stats(fs *flag.FlagSet, args []string, w io.Writer) error {
+	if err := parse(fs, args, 1); err != nil {
+		return err
+	}
+	path := fs.Arg(0)
+	fi, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	items, err := readSnapshot(path)
+	if err != nil {
+		return err
+	}
+	var expired, expiring int
+	var maxVersion uint64
+	types := map[string]int{}
+	for _, item := range items {
+		if item.Expired() {
+			expired++
+		} else if item.Expiration != 0 {
+			expiring++
+		}
+		types[typeName(item.Object)]++
+		maxVersion = max(maxVersion, item.Version)
+	}
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(tw, "file\t%s (%d bytes)\n", path, fi.Size())
+	fmt.Fprintf(tw, "items\t%d\n", len(items))
+	fmt.Fprintf(tw, "expired\t%d\n", expired)
+	fmt.Fprintf(tw, "expiring\t%d\n", expiring)
+	fmt.Fprintf(tw, "never expiring\t%d\n", len(items)-expired-expiring)
+	fmt.Fprintf(tw, "latest version\t%d\n", maxVersion)
+	names := make([]string, 0, len(types))
+	for name := range types {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	for _, name := range names {
+		fmt.Fprintf(tw, "type %s\t%d\n", name, types[name])
+	}
+	return tw.Flush()
+}
+
+type expireBucket struct {
+	name string
+	d    time.Duration
+}
+
+// expireBuckets are the times within which expire-report counts the items
+// that expire.
+var expireBuckets = []expireBucket{
+	{"1m", time.Minute},
+	{"1h", time.Hour},
+	{"1d", 24 * time.Hour},
+	{"1w", 7 * 24 * time.Hour},
+}
+
+func expireReport(fs *flag.FlagSet, args []string, w io.Writer) error {
+	if err := parse(fs, args, 1); err != nil {
+		return err
+	}
+	items, err := readSnapshot(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	now := time.Now().UnixNano()
+	var expired, never, later int
+	within := make([]int, len(expireBuckets))
+	for _, item := range items {
+		switch {
+		case item.Expiration == 0:
+			never++
+			continue
+		case item.Expiration <= now:
+			expired++
+			continue
+		}
+		i := slices.IndexFunc(expireBuckets, func(b expireBucket) bool {
+			return item.Expiration-now <= int64(b.d)
+		})
+		if i < 0 {
+			later++
+		} else {
+			within[i]++
+		}
+	}
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	row := func(name string, n int) {
+		pct := 0.0
+		if len(items) > 0 {
+			pct = 100 * float64(n) / float64(len(items))
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%.1f%%\t\n", name, n, pct)
+	}
+	row("expired", expired)
+	for i, b := range expireBuckets {
+		row("within "+b.name, within[i])
+	}
+	row("later", later)
+	row("never", never)
+	return tw.Flush()
+}
+
+func convert(fs *flag.FlagSet, args []string, w io.Writer) error {
+	if err := parse(fs, args, 2); err != nil {
+		return err
+	}
+	in, out := fs.Arg(0), fs.Arg(1)
+	if isJSON(in) == isJSON(out) {
+		return errors.New("convert: one file must be JSON (.json) and the other Gob")
+	}
+	items, err := readSnapshot(in)
+	if err != nil {
+		return err
+	}
+	return writeSnapshot(out, items)
+}
+
+func merge(fs *flag.FlagSet, args []string, w io.Writer) error {
+	out := fs.String("o", "", "write the merged snapshot to `out`")
+	if err := parse(fs, args, -1); err != nil {
+		return err
+	}
+	if *out == "" {
+		return errUsage
+	}
+	merged := map[string]cache.Item{}
+	for _, path := range fs.Args() {
+		items, err := readSnapshot(path)
+		if err != nil {
+			return err
+		}
+		for k, item := range items {
+			if !item.Expired() {
+				merged[k] = item
+			}
+		}
+	}
+	return writeSnapshot(*out, merged)
+}
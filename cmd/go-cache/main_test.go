@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	cache "github.com/wyyadd/go-cache"
+	"github.com/wyyadd/go-cache/server"
+)
+
+// saveSnapshot saves a cache with a few items to a Gob snapshot in a
+// temporary directory and returns its path.
+func saveSnapshot(t *testing.T, name string, items map[string]interface{}) string {
+	t.Helper()
+	tc := cache.New(cache.NoExpiration, 0, cache.NewRwmMap())
+	for k, x := range items {
+		tc.Set(k, x, cache.NoExpiration)
+	}
+	path := filepath.Join(t.TempDir(), name)
+	if err := tc.SaveFile(path); err != nil {
+		t.Fatal("Couldn't save the snapshot:", err)
+	}
+	return path
+}
+
+// fields returns s with runs of white space replaced by single spaces.
+func fields(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
+
+func runOK(t *testing.T, args ...string) string {
+	t.Helper()
+	var out bytes.Buffer
+	if err := run(args, &out, io.Discard); err != nil {
+		t.Fatalf("go-cache %s: %v", strings.Join(args, " "), err)
+	}
+	return out.String()
+}
+
+func TestConvert(t *testing.T) {
+	want := map[string]interface{}{
+		"string":   "s",
+		"int":      42,
+		"float":    1.5,
+		"bytes":    []byte("b"),
+		"strings":  []string{"a", "b"},
+		"memcache": server.MemcacheValue{Flags: 3, Value: "mc"},
+	}
+	path := saveSnapshot(t, "snap.gob", want)
+	dir := t.TempDir()
+	jsonPath, gobPath := filepath.Join(dir, "snap.json"), filepath.Join(dir, "snap.gob")
+	runOK(t, "convert", path, jsonPath)
+	runOK(t, "convert", jsonPath, gobPath)
+
+	items, err := readSnapshot(gobPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != len(want) {
+		t.Errorf("converted snapshot has %d items, want %d", len(items), len(want))
+	}
+	for k, x := range want {
+		if got := items[k].Object; !reflect.DeepEqual(got, x) {
+			t.Errorf("item %q converted to %#v, want %#v", k, got, x)
+		}
+	}
+
+	if err := run([]string{"convert", path, gobPath}, io.Discard, io.Discard); err == nil {
+		t.Error("convert between two Gob snapshots did not fail")
+	}
+}
+
+func TestGetAndKeys(t *testing.T) {
+	path := saveSnapshot(t, "snap", map[string]interface{}{"user:1": "a", "user:2": "b", "session": 1})
+	if got := runOK(t, "get", path, "user:2"); got != "\"b\"\n" {
+		t.Errorf("get printed %q", got)
+	}
+	if err := run([]string{"get", path, "missing"}, io.Discard, io.Discard); err == nil {
+		t.Error("get of a missing key did not fail")
+	}
+	if got := runOK(t, "keys", "-prefix", "user:", path); got != "user:1\nuser:2\n" {
+		t.Errorf("keys printed %q", got)
+	}
+	if got := runOK(t, "inspect", path); !strings.Contains(fields(got), `"session" int never`) {
+		t.Errorf("inspect printed\n%s", got)
+	}
+	if got := runOK(t, "stats", path); !strings.Contains(fields(got), "type string 2") {
+		t.Errorf("stats printed\n%s", got)
+	}
+	if err := run([]string{"keys"}, io.Discard, io.Discard); !errors.Is(err, errUsage) {
+		t.Error("keys without a file returned", err)
+	}
+	if err := run([]string{"bogus"}, io.Discard, io.Discard); !errors.Is(err, errUsage) {
+		t.Error("an unknown command returned", err)
+	}
+}
+
+func TestMergeAndExpireReport(t *testing.T) {
+	tc := cache.New(cache.NoExpiration, 0, cache.NewRwmMap())
+	tc.Set("old", 1, cache.NoExpiration)
+	tc.Set("both", "first", cache.NoExpiration)
+	tc.Set("soon", 1, 30*time.Second)
+	// Save omits expired items, so write the snapshot with one added.
+	first := filepath.Join(t.TempDir(), "first")
+	items := tc.Items()
+	items["expired"] = cache.Item{Object: 1, Expiration: time.Now().Add(-time.Hour).UnixNano()}
+	if err := writeSnapshot(first, items); err != nil {
+		t.Fatal(err)
+	}
+	second := saveSnapshot(t, "second", map[string]interface{}{"both": "second", "new": 2})
+
+	report := runOK(t, "expire-report", first)
+	for _, want := range []string{"expired 1 25.0%", "within 1m 1 25.0%", "never 2 50.0%"} {
+		if !strings.Contains(fields(report), want) {
+			t.Errorf("expire-report did not contain %q:\n%s", want, report)
+		}
+	}
+
+	out := filepath.Join(t.TempDir(), "merged.json")
+	runOK(t, "merge", "-o", out, first, second)
+	merged, err := readSnapshot(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := map[string]interface{}{}
+	for k, item := range merged {
+		got[k] = item.Object
+	}
+	want := map[string]interface{}{"old": 1, "both": "second", "soon": 1, "new": 2}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("merge wrote %v, want %v", got, want)
+	}
+	if merged["soon"].Expiration == 0 {
+		t.Error("merge did not keep an expiration time")
+	}
+}
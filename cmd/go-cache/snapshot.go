@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bufio"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"time"
+
+	cache "github.com/wyyadd/go-cache"
+	"github.com/wyyadd/go-cache/server"
+)
+
+// valueTypes are the types of values that can be read from snapshots, by the
+// names they are given in JSON snapshots. Gob registers the basic types
+// itself; others must be registered with it too.
+var valueTypes = map[string]reflect.Type{}
+
+func registerValueType(x interface{}) {
+	t := reflect.TypeOf(x)
+	valueTypes[t.String()] = t
+}
+
+func init() {
+	for _, x := range []interface{}{
+		false, "", []byte(nil),
+		int(0), int8(0), int16(0), int32(0), int64(0),
+		uint(0), uint8(0), uint16(0), uint32(0), uint64(0), uintptr(0),
+		float32(0), float64(0), complex64(0), complex128(0),
+		[]bool(nil), []string(nil), []int(nil), []int64(nil), []uint(nil), []uint64(nil), []float64(nil),
+	} {
+		registerValueType(x)
+	}
+	// The values stored by go-cache-server's memcached protocol.
+	gob.Register(server.MemcacheValue{})
+	registerValueType(server.MemcacheValue{})
+}
+
+// jsonItem is an item in a JSON snapshot. Its value's type is recorded so it
+// can be converted back to a Gob snapshot.
+type jsonItem struct {
+	Type       string          `json:"type,omitempty"`
+	Value      json.RawMessage `json:"value"`
+	Expiration *time.Time      `json:"expiration,omitempty"`
+	Version    uint64          `json:"version,omitempty"`
+}
+
+// isJSON reports whether the snapshot file at path is JSON rather than Gob,
+// which is decided by its extension.
+func isJSON(path string) bool {
+	return strings.EqualFold(filepath.Ext(path), ".json")
+}
+
+// readSnapshot returns the items in the snapshot file at path.
+func readSnapshot(path string) (map[string]cache.Item, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	r := bufio.NewReader(f)
+	if !isJSON(path) {
+		items, err := cache.ReadSnapshot(r)
+		if err != nil {
+			if strings.Contains(err.Error(), "name not registered") {
+				err = fmt.Errorf("%v (only snapshots of basic types and memcached values can be read)", err)
+			}
+			return nil, fmt.Errorf("reading %s: %w", path, err)
+		}
+		return items, nil
+	}
+	var jitems map[string]jsonItem
+	if err := json.NewDecoder(r).Decode(&jitems); err != nil {
+		return nil, fmt.Errorf("reading %s: %w", path, err)
+	}
+	items := make(map[string]cache.Item, len(jitems))
+	for k, ji := range jitems {
+		item := cache.Item{Version: ji.Version}
+		if ji.Expiration != nil {
+			item.Expiration = ji.Expiration.UnixNano()
+		}
+		if ji.Type != "" {
+			t, ok := valueTypes[ji.Type]
+			if !ok {
+				return nil, fmt.Errorf("reading %s: item %q has unknown type %s", path, k, ji.Type)
+			}
+			v := reflect.New(t)
+			if err := json.Unmarshal(ji.Value, v.Interface()); err != nil {
+				return nil, fmt.Errorf("reading %s: item %q: %w", path, k, err)
+			}
+			item.Object = v.Elem().Interface()
+		}
+		items[k] = item
+	}
+	return items, nil
+}
+
+// writeSnapshot writes items to the snapshot file at path, replacing it if it
+// exists.
+func writeSnapshot(path string, items map[string]cache.Item) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	if isJSON(path) {
+		err = writeJSON(w, items)
+	} else {
+		err = cache.WriteSnapshot(w, items)
+	}
+	if err == nil {
+		err = w.Flush()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return fmt.Errorf("writing %s: %w", path, err)
+	}
+	return nil
+}
+
+func writeJSON(w *bufio.Writer, items map[string]cache.Item) error {
+	jitems := make(map[string]jsonItem, len(items))
+	for k, item := range items {
+		ji := jsonItem{Version: item.Version}
+		if item.Expiration != 0 {
+			e := time.Unix(0, item.Expiration).UTC()
+			ji.Expiration = &e
+		}
+		if item.Object != nil {
+			ji.Type = typeName(item.Object)
+			v, err := json.Marshal(item.Object)
+			if err != nil {
+				return fmt.Errorf("item %q: %w", k, err)
+			}
+			ji.Value = v
+		} else {
+			ji.Value = json.RawMessage("null")
+		}
+		jitems[k] = ji
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "\t")
+	return enc.Encode(jitems)
+}
+
+func typeName(x interface{}) string {
+	if x == nil {
+		return "nil"
+	}
+	return reflect.TypeOf(x).String()
+}
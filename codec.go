@@ -0,0 +1,109 @@
+package cache
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sync"
+)
+
+// A Codec encodes values to bytes and decodes them back, for backends that
+// store bytes rather than values, such as ByteMap, and for NewCodecMap.
+type Codec interface {
+	Encode(x interface{}) ([]byte, error)
+	Decode(b []byte) (interface{}, error)
+}
+
+// GobCodec is a Codec that uses Gob. Encode registers the types of the
+// values it encodes with gob.Register, once for each type, so values of the
+// same types can be decoded in this process; other processes must register
+// them before decoding.
+//
+// Each value is encoded on its own, so its encoding starts with Gob's
+// description of its type, which takes about 40 bytes for an int and more
+// for structs, and decoding it parses the description again.
+type GobCodec struct{}
+
+// gobRegistered holds the types GobCodec has registered with Gob.
+var gobRegistered sync.Map
+
+// registerGobType registers the type of x with Gob, unless it has been
+// already.
+func registerGobType(x interface{}) (err error) {
+	t := reflect.TypeOf(x)
+	if _, ok := gobRegistered.Load(t); ok {
+		return nil
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("error registering type %T with Gob library: %v", x, r)
+		}
+	}()
+	gob.Register(x)
+	gobRegistered.Store(t, struct{}{})
+	return nil
+}
+
+// gobValue wraps the values GobCodec encodes, since Gob can't encode a nil
+// interface on its own.
+type gobValue struct {
+	V interface{}
+}
+
+func (GobCodec) Encode(x interface{}) ([]byte, error) {
+	if x != nil {
+		if err := registerGobType(x); err != nil {
+			return nil, err
+		}
+	}
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(gobValue{x}); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (GobCodec) Decode(b []byte) (interface{}, error) {
+	var v gobValue
+	if err := gob.NewDecoder(bytes.NewReader(b)).Decode(&v); err != nil {
+		return nil, err
+	}
+	return v.V, nil
+}
+
+// JSONCodec is a Codec that uses encoding/json. JSON doesn't record the
+// types of values, so Decode decodes into a new value from New, or, if New
+// is nil, into an interface{}, which gives maps, slices, strings, float64s,
+// bools and nils.
+type JSONCodec struct {
+	// New returns a pointer to a new value for Decode to decode into.
+	// Decode returns the value it points to.
+	New func() interface{}
+}
+
+func (JSONCodec) Encode(x interface{}) ([]byte, error) {
+	return json.Marshal(x)
+}
+
+func (c JSONCodec) Decode(b []byte) (interface{}, error) {
+	if c.New == nil {
+		var x interface{}
+		err := json.Unmarshal(b, &x)
+		return x, err
+	}
+	p := c.New()
+	if err := json.Unmarshal(b, p); err != nil {
+		return nil, err
+	}
+	return reflect.ValueOf(p).Elem().Interface(), nil
+}
+
+// A Cloner is a value that can copy itself, which NewCloneMap uses to copy
+// values as they are read.
+type Cloner interface {
+	// Clone returns a copy of the value that shares no memory with it that
+	// either may change.
+	Clone() interface{}
+}
@@ -0,0 +1,214 @@
+package cache
+
+import "iter"
+
+// NewCodecMap returns a CacheMap that stores values in m encoded with codec,
+// GobCodec if it is nil, and decodes them whenever they are read, so that
+// callers of a Cache built on it get their own copy of each value, which
+// they may change without changing the cache. The objects of Items are
+// encoded, leaving their expiration times and versions as they are.
+//
+// A write of a value that can't be encoded deletes the key instead, and
+// CompareAndSwap and Compute report the write as not done. A value that
+// can't be decoded is treated as missing by reads, and can't be deleted by
+// CompareAndDelete. onError, if not nil, is called with the errors.
+func NewCodecMap(m CacheMap, codec Codec, onError func(key string, err error)) CacheMap {
+	if codec == nil {
+		codec = GobCodec{}
+	}
+	return &convertingMap{
+		m:       m,
+		in:      func(x interface{}) (interface{}, error) { return codec.Encode(x) },
+		out:     func(x interface{}) (interface{}, error) { return codec.Decode(x.([]byte)) },
+		onError: onError,
+	}
+}
+
+// NewCloneMap returns a CacheMap that stores values in m as they are, and
+// copies those that implement Cloner whenever they are read, so that callers
+// of a Cache built on it may change the values they get without changing the
+// cache. It is cheaper than NewCodecMap, but values set in the cache must
+// not be changed afterwards.
+func NewCloneMap(m CacheMap) CacheMap {
+	return &convertingMap{
+		m: m,
+		out: func(x interface{}) (interface{}, error) {
+			if c, ok := x.(Cloner); ok {
+				return c.Clone(), nil
+			}
+			return x, nil
+		},
+	}
+}
+
+// convertingMap is a CacheMap that converts the values it stores in another,
+// or the objects of Items, with in before they are stored and with out
+// whenever they are read. Every value it returns or passes to a callback has
+// been converted by out, and in is skipped if it is nil.
+type convertingMap struct {
+	m       CacheMap
+	in, out func(x interface{}) (interface{}, error)
+	onError func(key string, err error)
+}
+
+// convert returns v converted by f, and false if the conversion failed.
+func (m *convertingMap) convert(k string, v interface{}, f func(interface{}) (interface{}, error)) (interface{}, bool) {
+	if f == nil {
+		return v, true
+	}
+	item, isItem := v.(Item)
+	if isItem {
+		v = item.Object
+	}
+	x, err := f(v)
+	if err != nil {
+		if m.onError != nil {
+			m.onError(k, err)
+		}
+		return nil, false
+	}
+	if isItem {
+		item.Object = x
+		return item, true
+	}
+	return x, true
+}
+
+func (m *convertingMap) Get(k string) (interface{}, bool) {
+	v, found := m.m.Get(k)
+	if !found {
+		return nil, false
+	}
+	return m.convert(k, v, m.out)
+}
+
+func (m *convertingMap) Set(k string, x interface{}) {
+	if v, ok := m.convert(k, x, m.in); ok {
+		m.m.Set(k, v)
+	} else {
+		m.m.Delete(k)
+	}
+}
+
+func (m *convertingMap) Delete(k string) {
+	m.m.Delete(k)
+}
+
+func (m *convertingMap) Range(f func(k string, v any)) {
+	for k, v := range m.All() {
+		f(k, v)
+	}
+}
+
+func (m *convertingMap) All() iter.Seq2[string, any] {
+	return func(yield func(string, any) bool) {
+		for k, v := range m.m.All() {
+			x, ok := m.convert(k, v, m.out)
+			if ok && !yield(k, x) {
+				return
+			}
+		}
+	}
+}
+
+// GetMulti converts the values after m's GetMulti has returned, so that no
+// lock is held.
+func (m *convertingMap) GetMulti(keys []string, f func(k string, v any)) {
+	var found []string
+	var values []interface{}
+	m.m.GetMulti(keys, func(k string, v any) {
+		found = append(found, k)
+		values = append(values, v)
+	})
+	for i, k := range found {
+		if x, ok := m.convert(k, values[i], m.out); ok {
+			f(k, x)
+		}
+	}
+}
+
+func (m *convertingMap) SetMulti(keys []string, values []interface{}) {
+	var set, failed []string
+	var converted []interface{}
+	for i, k := range keys {
+		if v, ok := m.convert(k, values[i], m.in); ok {
+			set = append(set, k)
+			converted = append(converted, v)
+		} else {
+			failed = append(failed, k)
+		}
+	}
+	m.m.SetMulti(set, converted)
+	if len(failed) > 0 {
+		m.m.DeleteMulti(failed)
+	}
+}
+
+func (m *convertingMap) DeleteMulti(keys []string) {
+	m.m.DeleteMulti(keys)
+}
+
+func (m *convertingMap) CompareAndSwap(k string, x interface{}, cmp func(old interface{}, found bool) bool) bool {
+	v, ok := m.convert(k, x, m.in)
+	if !ok {
+		m.m.CompareAndDelete(k, func(old interface{}) bool {
+			old, found := m.convert(k, old, m.out)
+			return cmp(old, found)
+		})
+		return false
+	}
+	return m.m.CompareAndSwap(k, v, func(old interface{}, found bool) bool {
+		if found {
+			old, found = m.convert(k, old, m.out)
+		}
+		return cmp(old, found)
+	})
+}
+
+func (m *convertingMap) CompareAndDelete(k string, cmp func(old interface{}) bool) bool {
+	return m.m.CompareAndDelete(k, func(old interface{}) bool {
+		old, ok := m.convert(k, old, m.out)
+		return ok && cmp(old)
+	})
+}
+
+// Compute returns a value converted by out, even if f returned it.
+func (m *convertingMap) Compute(k string, f func(old interface{}, found bool) (interface{}, Op)) (interface{}, bool) {
+	var stored interface{}
+	var failed bool
+	_, found := m.m.Compute(k, func(old interface{}, found bool) (interface{}, Op) {
+		stored, failed = old, false
+		if found {
+			old, found = m.convert(k, old, m.out)
+		}
+		x, op := f(old, found)
+		if op != OpSet {
+			return nil, op
+		}
+		if stored, found = m.convert(k, x, m.in); !found {
+			failed = true
+			return nil, OpDelete
+		}
+		return stored, OpSet
+	})
+	if !found || failed {
+		return nil, false
+	}
+	return m.convert(k, stored, m.out)
+}
+
+func (m *convertingMap) Count() int {
+	return m.m.Count()
+}
+
+// EntryOverhead returns the overhead of the map the values are stored in.
+func (m *convertingMap) EntryOverhead() int64 {
+	if s, ok := m.m.(entrySizer); ok {
+		return s.EntryOverhead()
+	}
+	return defaultEntryOverhead
+}
+
+func (m *convertingMap) Flush() {
+	m.m.Flush()
+}
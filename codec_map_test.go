@@ -0,0 +1,123 @@
+package cache
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"slices"
+	"testing"
+	"time"
+)
+
+type profile struct {
+	Name string
+	Tags []string
+}
+
+func (p *profile) Clone() interface{} {
+	return &profile{Name: p.Name, Tags: slices.Clone(p.Tags)}
+}
+
+func TestCodecMapCopies(t *testing.T) {
+	for name, codec := range map[string]Codec{
+		"Gob":  GobCodec{},
+		"JSON": JSONCodec{New: func() interface{} { return new(*profile) }},
+	} {
+		t.Run(name, func(t *testing.T) {
+			tc := New(NoExpiration, 0, NewCodecMap(NewConcurrentMap(), codec, nil))
+			p := &profile{Name: "a", Tags: []string{"x"}}
+			tc.Set("p", p, NoExpiration)
+			p.Tags[0] = "changed after Set"
+			x, found := tc.Get("p")
+			if !found || !reflect.DeepEqual(x, &profile{Name: "a", Tags: []string{"x"}}) {
+				t.Fatalf("Get returned %#v, %t", x, found)
+			}
+			x.(*profile).Tags[0] = "changed after Get"
+			if x, _ := tc.Get("p"); x.(*profile).Tags[0] != "x" {
+				t.Error("Get returned", x, "after the caller changed a value it got")
+			}
+			x, _ = tc.Compute("p", func(old interface{}, found bool) (interface{}, time.Duration, Op) {
+				old.(*profile).Tags = append(old.(*profile).Tags, "y")
+				return old, NoExpiration, OpSet
+			})
+			x.(*profile).Tags[1] = "changed after Compute"
+			if x, _ := tc.Get("p"); !slices.Equal(x.(*profile).Tags, []string{"x", "y"}) {
+				t.Error("Get returned", x, "after Compute")
+			}
+		})
+	}
+}
+
+func TestJSONCodec(t *testing.T) {
+	c := JSONCodec{}
+	b, err := c.Encode(map[string]int{"a": 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if x, err := c.Decode(b); err != nil || !reflect.DeepEqual(x, map[string]interface{}{"a": 1.0}) {
+		t.Errorf("Decode returned %#v, %v", x, err)
+	}
+	c.New = func() interface{} { return new(map[string]int) }
+	if x, err := c.Decode(b); err != nil || !reflect.DeepEqual(x, map[string]int{"a": 1}) {
+		t.Errorf("Decode into a new value returned %#v, %v", x, err)
+	}
+}
+
+func TestGobCodecRegistersOnce(t *testing.T) {
+	type registered struct{ N int }
+	c := GobCodec{}
+	for i := 0; i < 2; i++ {
+		b, err := c.Encode(registered{i})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if x, err := c.Decode(b); err != nil || x != (registered{i}) {
+			t.Errorf("Decode returned %#v, %v", x, err)
+		}
+	}
+	if _, ok := gobRegistered.Load(reflect.TypeOf(registered{})); !ok {
+		t.Error("the type of an encoded value was not recorded as registered")
+	}
+}
+
+func TestCodecMapErrors(t *testing.T) {
+	var errs []error
+	m := NewCodecMap(NewRwmMap(), JSONCodec{New: func() interface{} { return new(int) }}, func(key string, err error) {
+		errs = append(errs, err)
+	})
+	m.Set("a", 1)
+	m.Set("a", func() {})
+	if _, found := m.Get("a"); found || len(errs) != 1 {
+		t.Error("a write that could not be encoded left the key, or was not reported:", errs)
+	}
+	m.Set("b", "not an int")
+	if _, found := m.Get("b"); found || len(errs) != 2 {
+		t.Error("Get found a value that could not be decoded, or did not report it:", errs)
+	}
+	var typeErr *json.UnmarshalTypeError
+	if !errors.As(errs[1], &typeErr) {
+		t.Error("the decoding error is", errs[1])
+	}
+	if m.CompareAndSwap("c", func() {}, func(interface{}, bool) bool { return true }) {
+		t.Error("CompareAndSwap of a value that could not be encoded succeeded")
+	}
+	if x, found := m.Compute("c", func(interface{}, bool) (interface{}, Op) { return func() {}, OpSet }); found {
+		t.Error("Compute of a value that could not be encoded returned", x)
+	}
+}
+
+func TestCloneMap(t *testing.T) {
+	tc := New(NoExpiration, 0, NewCloneMap(NewSyncMap()))
+	tc.Set("p", &profile{Name: "a", Tags: []string{"x"}}, NoExpiration)
+	tc.Set("n", 1, NoExpiration)
+	x, _ := tc.Get("p")
+	x.(*profile).Tags[0] = "changed after Get"
+	for _, v := range tc.Items() {
+		if p, ok := v.Object.(*profile); ok && p.Tags[0] != "x" {
+			t.Error("Items returned", p, "after the caller changed a value it got")
+		}
+	}
+	if x, _ := tc.Get("n"); x != 1 {
+		t.Error("Get returned", x, "for a value that is not a Cloner")
+	}
+}
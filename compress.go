@@ -0,0 +1,215 @@
+package cache
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"errors"
+	"io"
+	"sync"
+	"sync/atomic"
+)
+
+// A Compressor compresses bytes and decompresses them back.
+type Compressor interface {
+	Compress(b []byte) ([]byte, error)
+	Decompress(b []byte) ([]byte, error)
+}
+
+// FlateCompressor is a Compressor that uses DEFLATE at Level, or
+// flate.DefaultCompression if Level is 0.
+type FlateCompressor struct {
+	Level int
+}
+
+// GzipCompressor is a Compressor that uses gzip at Level, or
+// gzip.DefaultCompression if Level is 0. It writes a header and checksum
+// that FlateCompressor doesn't, so it is a little slower and larger.
+type GzipCompressor struct {
+	Level int
+}
+
+// The writers of each compression level, from HuffmanOnly (-2) to
+// BestCompression (9), are kept for reuse, as they are large.
+var (
+	flateWriters [12]sync.Pool
+	gzipWriters  [12]sync.Pool
+)
+
+func compressionLevel(level int) (int, error) {
+	if level == 0 {
+		level = flate.DefaultCompression
+	}
+	if level < flate.HuffmanOnly || level > flate.BestCompression {
+		return 0, errors.New("cache: invalid compression level")
+	}
+	return level, nil
+}
+
+func (c FlateCompressor) Compress(b []byte) ([]byte, error) {
+	level, err := compressionLevel(c.Level)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	w, _ := flateWriters[level+2].Get().(*flate.Writer)
+	if w == nil {
+		w, _ = flate.NewWriter(&buf, level)
+	} else {
+		w.Reset(&buf)
+	}
+	defer func() {
+		// Don't keep the buffer alive with the writer.
+		w.Reset(io.Discard)
+		flateWriters[level+2].Put(w)
+	}()
+	if _, err := w.Write(b); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (FlateCompressor) Decompress(b []byte) ([]byte, error) {
+	r := flate.NewReader(bytes.NewReader(b))
+	defer r.Close()
+	return io.ReadAll(r)
+}
+
+func (c GzipCompressor) Compress(b []byte) ([]byte, error) {
+	level, err := compressionLevel(c.Level)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	w, _ := gzipWriters[level+2].Get().(*gzip.Writer)
+	if w == nil {
+		w, _ = gzip.NewWriterLevel(&buf, level)
+	} else {
+		w.Reset(&buf)
+	}
+	defer func() {
+		// Don't keep the buffer alive with the writer.
+		w.Reset(io.Discard)
+		gzipWriters[level+2].Put(w)
+	}()
+	if _, err := w.Write(b); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (GzipCompressor) Decompress(b []byte) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return io.ReadAll(r)
+}
+
+// CompressionOptions configure a CompressingCodec.
+type CompressionOptions struct {
+	// The Compressor used. The default is FlateCompressor{}.
+	Compressor Compressor
+	// Encoded values shorter than Threshold bytes are stored as they are.
+	// The default is 1024.
+	Threshold int
+}
+
+// CompressionStats are counts of the values a CompressingCodec has encoded.
+type CompressionStats struct {
+	// Values stored compressed, and stored as they are because they were
+	// short or didn't compress.
+	Compressed, Uncompressed uint64
+	// The bytes of the compressed values before and after compression.
+	BytesIn, BytesOut uint64
+}
+
+// BytesSaved returns the number of bytes compression has saved.
+func (s CompressionStats) BytesSaved() uint64 {
+	return s.BytesIn - s.BytesOut
+}
+
+// CompressingCodec is a Codec that compresses the values another Codec
+// encodes, if they are long enough and compression makes them shorter, and
+// decompresses them before decoding. Each encoded value starts with a byte
+// telling whether it is compressed.
+type CompressingCodec struct {
+	codec Codec
+	opts  CompressionOptions
+
+	compressed, uncompressed atomic.Uint64
+	bytesIn, bytesOut        atomic.Uint64
+}
+
+// The first byte of values encoded by a CompressingCodec.
+const (
+	codecUncompressed byte = iota
+	codecCompressed
+)
+
+// NewCompressingCodec returns a CompressingCodec that compresses the values
+// encoded by codec, or GobCodec if it is nil.
+func NewCompressingCodec(codec Codec, opts CompressionOptions) *CompressingCodec {
+	if codec == nil {
+		codec = GobCodec{}
+	}
+	if opts.Compressor == nil {
+		opts.Compressor = FlateCompressor{}
+	}
+	if opts.Threshold <= 0 {
+		opts.Threshold = 1024
+	}
+	return &CompressingCodec{codec: codec, opts: opts}
+}
+
+func (c *CompressingCodec) Encode(x interface{}) ([]byte, error) {
+	b, err := c.codec.Encode(x)
+	if err != nil {
+		return nil, err
+	}
+	if len(b) >= c.opts.Threshold {
+		z, err := c.opts.Compressor.Compress(b)
+		if err != nil {
+			return nil, err
+		}
+		if len(z) < len(b) {
+			c.compressed.Add(1)
+			c.bytesIn.Add(uint64(len(b)))
+			c.bytesOut.Add(uint64(len(z)))
+			return append([]byte{codecCompressed}, z...), nil
+		}
+	}
+	c.uncompressed.Add(1)
+	return append([]byte{codecUncompressed}, b...), nil
+}
+
+func (c *CompressingCodec) Decode(b []byte) (interface{}, error) {
+	if len(b) == 0 {
+		return nil, errors.New("cache: encoded value is empty")
+	}
+	data := b[1:]
+	if b[0] == codecCompressed {
+		var err error
+		if data, err = c.opts.Compressor.Decompress(data); err != nil {
+			return nil, err
+		}
+	}
+	return c.codec.Decode(data)
+}
+
+// Stats returns counts of the values the codec has encoded.
+func (c *CompressingCodec) Stats() CompressionStats {
+	return CompressionStats{
+		Compressed:   c.compressed.Load(),
+		Uncompressed: c.uncompressed.Load(),
+		BytesIn:      c.bytesIn.Load(),
+		BytesOut:     c.bytesOut.Load(),
+	}
+}
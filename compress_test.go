@@ -0,0 +1,115 @@
+package cache
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+// response returns JSON like an API response of about n bytes.
+func response(n int) json.RawMessage {
+	var b bytes.Buffer
+	b.WriteString(`{"items":[`)
+	for i := 0; b.Len() < n; i++ {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		fmt.Fprintf(&b, `{"id":%d,"name":"item %d","tags":["a","b"],"price":%d.99}`, i, i, i%100)
+	}
+	b.WriteString(`]}`)
+	return b.Bytes()
+}
+
+func TestCompressingCodec(t *testing.T) {
+	random := make([]byte, 4096)
+	rand.New(rand.NewSource(1)).Read(random)
+	for name, compressor := range map[string]Compressor{
+		"Flate":     FlateCompressor{},
+		"Gzip":      GzipCompressor{},
+		"BestSpeed": FlateCompressor{Level: 1},
+	} {
+		t.Run(name, func(t *testing.T) {
+			c := NewCompressingCodec(JSONCodec{}, CompressionOptions{Compressor: compressor, Threshold: 100})
+			tc := New(NoExpiration, 0, NewByteMap(ByteMapOptions{Codec: c}))
+			large := string(response(200 << 10))
+			tc.Set("large", large, NoExpiration)
+			tc.Set("small", "short", NoExpiration)
+			tc.Set("random", random, NoExpiration)
+			if x, _ := tc.Get("large"); x != large {
+				t.Error("Get returned a different value for a compressed value")
+			}
+			if x, _ := tc.Get("small"); x != "short" {
+				t.Error("Get returned", x, "for a short value")
+			}
+			// JSON encodes random as a base64 string, which doesn't compress.
+			if x, found := tc.Get("random"); !found || len(x.(string)) < len(random) {
+				t.Error("Get returned", x, found, "for a value that didn't compress")
+			}
+			s := c.Stats()
+			if s.Compressed != 1 || s.Uncompressed != 2 {
+				t.Errorf("%d values were compressed and %d weren't, want 1 and 2", s.Compressed, s.Uncompressed)
+			}
+			if s.BytesIn < 200<<10 || s.BytesSaved() < s.BytesIn/2 {
+				t.Errorf("compressing %d bytes saved %d", s.BytesIn, s.BytesSaved())
+			}
+		})
+	}
+}
+
+func TestCompressingCodecErrors(t *testing.T) {
+	c := NewCompressingCodec(nil, CompressionOptions{Compressor: FlateCompressor{Level: 42}, Threshold: 1})
+	if _, err := c.Encode(strings.Repeat("a", 100)); err == nil {
+		t.Error("Encode with an invalid compression level succeeded")
+	}
+	if _, err := NewCompressingCodec(nil, CompressionOptions{}).Decode(nil); err == nil {
+		t.Error("Decode of nothing succeeded")
+	}
+	if _, err := c.Decode([]byte{codecCompressed, 1, 2, 3}); err == nil {
+		t.Error("Decode of corrupt data succeeded")
+	}
+}
+
+// BenchmarkCompression measures the CPU cost of storing a 200 KB JSON
+// response compressed, and reports the compressed size.
+func BenchmarkCompression(b *testing.B) {
+	value := response(200 << 10)
+	for _, bc := range []struct {
+		name       string
+		compressor Compressor
+	}{
+		{"None", nil},
+		{"FlateBestSpeed", FlateCompressor{Level: 1}},
+		{"Flate", FlateCompressor{}},
+		{"Gzip", GzipCompressor{}},
+	} {
+		opts := CompressionOptions{Compressor: bc.compressor}
+		if bc.compressor == nil {
+			opts.Threshold = len(value) * 2
+		}
+		c := NewCompressingCodec(bytesCodec{}, opts)
+		encoded, _ := c.Encode(value)
+		b.Run(bc.name+"/Encode", func(b *testing.B) {
+			b.SetBytes(int64(len(value)))
+			for i := 0; i < b.N; i++ {
+				c.Encode(value)
+			}
+			b.ReportMetric(float64(len(encoded)), "stored-bytes")
+		})
+		b.Run(bc.name+"/Decode", func(b *testing.B) {
+			b.SetBytes(int64(len(value)))
+			for i := 0; i < b.N; i++ {
+				c.Decode(encoded)
+			}
+		})
+	}
+}
+
+// bytesCodec is a Codec of byte slices, which stores them as they are, so
+// that benchmarks measure compression alone.
+type bytesCodec struct{}
+
+func (bytesCodec) Encode(x interface{}) ([]byte, error) { return x.(json.RawMessage), nil }
+func (bytesCodec) Decode(b []byte) (interface{}, error) { return json.RawMessage(b), nil }
@@ -0,0 +1,163 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// ErrGap is returned when reading an EventLog from a sequence number whose
+// entry has already been overwritten. The reader has missed changes and must
+// start again from a full copy of the cache.
+var ErrGap = errors.New("cache: event log reader fell behind")
+
+// ErrLogClosed is returned when reading from a closed EventLog.
+var ErrLogClosed = errors.New("cache: event log closed")
+
+// LogEntry is an Event recorded by an EventLog with its sequence number.
+type LogEntry struct {
+	Seq uint64
+	Event
+}
+
+// EventLog records every change made to a Cache, numbered in the order the
+// changes were made, in a ring that keeps the most recent entries. Changes to
+// the same key are recorded in the order they were applied, and a flush is
+// ordered with respect to every other change, so replaying the log in order
+// reproduces the cache's contents. Sequence numbers start at 1.
+type EventLog struct {
+	mu     sync.Mutex
+	ring   []LogEntry
+	next   uint64
+	wait   chan struct{}
+	closed bool
+	hub    *eventHub
+}
+
+// NewEventLog returns an EventLog that records the changes made to c from now
+// on, keeping the most recent size entries. Recording makes the cache
+// serialize writes to keys that share a lock until the log is closed.
+func NewEventLog(c *Cache, size int) *EventLog {
+	if size < 1 {
+		size = 1
+	}
+	l := &EventLog{
+		ring: make([]LogEntry, size),
+		next: 1,
+		wait: make(chan struct{}),
+		hub:  &c.events,
+	}
+	c.events.subscribe(l)
+	return l
+}
+
+func (l *EventLog) notify(ev Event) {
+	l.mu.Lock()
+	l.ring[l.next%uint64(len(l.ring))] = LogEntry{Seq: l.next, Event: ev}
+	l.next++
+	close(l.wait)
+	l.wait = make(chan struct{})
+	l.mu.Unlock()
+}
+
+// LastSeq returns the sequence number of the most recent entry, or 0 if
+// nothing has been recorded yet.
+func (l *EventLog) LastSeq() uint64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.next - 1
+}
+
+// oldest returns the sequence number of the oldest entry still in the ring.
+// l.mu must be held.
+func (l *EventLog) oldest() uint64 {
+	if l.next <= uint64(len(l.ring)) {
+		return 1
+	}
+	return l.next - uint64(len(l.ring))
+}
+
+// Read returns up to max entries starting with sequence number from, without
+// waiting for new ones. It returns ErrGap if the entry for from has been
+// overwritten.
+func (l *EventLog) Read(from uint64, max int) ([]LogEntry, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.read(from, max)
+}
+
+// read is Read with l.mu held.
+func (l *EventLog) read(from uint64, max int) ([]LogEntry, error) {
+	if from == 0 {
+		from = 1
+	}
+	if from < l.oldest() {
+		return nil, ErrGap
+	}
+	var entries []LogEntry
+	for seq := from; seq < l.next && len(entries) < max; seq++ {
+		entries = append(entries, l.ring[seq%uint64(len(l.ring))])
+	}
+	return entries, nil
+}
+
+// Tail returns a LogReader that reads entries starting with sequence number
+// from. Tail(l.LastSeq()+1) only reads changes made from now on.
+func (l *EventLog) Tail(from uint64) *LogReader {
+	if from == 0 {
+		from = 1
+	}
+	return &LogReader{log: l, next: from}
+}
+
+// Close stops recording changes and makes readers return ErrLogClosed once
+// they have read the entries already recorded.
+func (l *EventLog) Close() {
+	l.hub.unsubscribe(l)
+	l.mu.Lock()
+	if !l.closed {
+		l.closed = true
+		close(l.wait)
+	}
+	l.mu.Unlock()
+}
+
+// LogReader reads an EventLog in order. A LogReader must not be used from
+// more than one goroutine.
+type LogReader struct {
+	log     *EventLog
+	next    uint64
+	pending []LogEntry
+}
+
+// Next returns the next entry, waiting until one is recorded or ctx is done.
+// It returns ErrGap if the reader has fallen so far behind that the entry
+// has been overwritten, after which every call returns ErrGap.
+func (r *LogReader) Next(ctx context.Context) (LogEntry, error) {
+	for len(r.pending) == 0 {
+		l := r.log
+		l.mu.Lock()
+		entries, err := l.read(r.next, 128)
+		wait, closed := l.wait, l.closed
+		l.mu.Unlock()
+		if err != nil {
+			return LogEntry{}, err
+		}
+		if len(entries) > 0 {
+			r.pending = entries
+			break
+		}
+		if closed {
+			return LogEntry{}, ErrLogClosed
+		}
+		select {
+		case <-wait:
+		case <-ctx.Done():
+			return LogEntry{}, ctx.Err()
+		}
+	}
+	entry := r.pending[0]
+	r.pending = r.pending[1:]
+	r.next = entry.Seq + 1
+	return entry, nil
+}
@@ -0,0 +1,136 @@
+package cache
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestEventLog(t *testing.T) {
+	testEventLog(t, NewRwmMap())
+	testEventLog(t, NewSyncMap())
+	testEventLog(t, NewConcurrentMap())
+}
+
+func testEventLog(t *testing.T, m CacheMap) {
+	tc := New(DefaultExpiration, 0, m)
+	tc.Set("before", 0, DefaultExpiration)
+	l := NewEventLog(tc, 100)
+	defer l.Close()
+	if seq := l.LastSeq(); seq != 0 {
+		t.Error("LastSeq of an empty log is not 0:", seq)
+	}
+
+	tc.Set("a", 1, DefaultExpiration)
+	tc.Set("b", 2, time.Nanosecond)
+	tc.Delete("a")
+	<-time.After(time.Millisecond)
+	tc.DeleteExpired()
+	tc.Flush()
+
+	entries, err := l.Read(0, 100)
+	if err != nil {
+		t.Fatal("Read failed:", err)
+	}
+	want := []string{"set a", "set b", "delete a", "expire b", "flush "}
+	if len(entries) != len(want) {
+		t.Fatalf("log has %d entries, want %d: %v", len(entries), len(want), entries)
+	}
+	for i, e := range entries {
+		if e.Seq != uint64(i+1) {
+			t.Errorf("entry %d has sequence number %d", i, e.Seq)
+		}
+		if got := e.Type.String() + " " + e.Key; got != want[i] {
+			t.Errorf("entry %d is %q, want %q", i, got, want[i])
+		}
+	}
+	if seq := l.LastSeq(); seq != 5 {
+		t.Error("LastSeq is not 5:", seq)
+	}
+
+	entries, _ = l.Read(4, 1)
+	if len(entries) != 1 || entries[0].Seq != 4 {
+		t.Error("Read from 4 of at most 1 entry returned", entries)
+	}
+}
+
+func TestEventLogGap(t *testing.T) {
+	tc := New(DefaultExpiration, 0, NewRwmMap())
+	l := NewEventLog(tc, 4)
+	defer l.Close()
+	r := l.Tail(1)
+	for i := 0; i < 10; i++ {
+		tc.Set("foo", i, DefaultExpiration)
+	}
+	if _, err := l.Read(6, 10); err != ErrGap {
+		t.Error("Read of an overwritten entry did not return ErrGap:", err)
+	}
+	entries, err := l.Read(7, 10)
+	if err != nil || len(entries) != 4 || entries[0].NewValue != 6 {
+		t.Error("Read of the oldest entries failed:", entries, err)
+	}
+	if _, err := r.Next(context.Background()); err != ErrGap {
+		t.Error("Next of a reader that fell behind did not return ErrGap:", err)
+	}
+}
+
+func TestEventLogTail(t *testing.T) {
+	tc := New(DefaultExpiration, 0, NewConcurrentMap())
+	tc.Set("old", 0, DefaultExpiration)
+	l := NewEventLog(tc, 1024)
+
+	const writers, each = 4, 100
+	wg := new(sync.WaitGroup)
+	wg.Add(writers)
+	for w := 0; w < writers; w++ {
+		go func(w int) {
+			for i := 0; i < each; i++ {
+				tc.Set("key"+strconv.Itoa(w), i, DefaultExpiration)
+			}
+			wg.Done()
+		}(w)
+	}
+
+	// Replaying the log must leave each key with its last value.
+	r := l.Tail(l.LastSeq() + 1)
+	last := map[string]interface{}{}
+	var seq uint64
+	for n := 0; n < writers*each; n++ {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		e, err := r.Next(ctx)
+		cancel()
+		if err != nil {
+			t.Fatal("Next failed:", err)
+		}
+		if e.Seq != seq+1 {
+			t.Fatalf("entry %d follows entry %d", e.Seq, seq)
+		}
+		seq = e.Seq
+		if prev, ok := last[e.Key]; ok && e.NewValue.(int) != prev.(int)+1 {
+			t.Errorf("%s went from %v to %v", e.Key, prev, e.NewValue)
+		}
+		last[e.Key] = e.NewValue
+	}
+	wg.Wait()
+	for k, v := range last {
+		if x, _ := tc.Get(k); x != v {
+			t.Errorf("log ends with %s=%v, cache has %v", k, v, x)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := r.Next(ctx); err != context.DeadlineExceeded {
+		t.Error("Next without new entries did not wait for the context:", err)
+	}
+	l.Close()
+	if _, err := r.Next(context.Background()); err != ErrLogClosed {
+		t.Error("Next on a closed log did not return ErrLogClosed:", err)
+	}
+	tc.Set("after", 1, DefaultExpiration)
+	if seq := l.LastSeq(); seq != writers*each {
+		t.Error("a closed log recorded a change:", seq)
+	}
+}
@@ -1,5 +1,3 @@
 module github.com/wyyadd/go-cache
 
-go 1.20
-
-require github.com/orcaman/concurrent-map v1.0.0
+go 1.23
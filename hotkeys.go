@@ -0,0 +1,234 @@
+package cache
+
+import (
+	"cmp"
+	"container/heap"
+	"hash/maphash"
+	"math"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// HotKeyOptions configure the tracking of a cache's hot keys.
+type HotKeyOptions struct {
+	// The number of hottest keys tracked. The default is 100.
+	K int
+	// The counters of a count-min sketch estimate how often each key is
+	// used: Width counters in each of Depth rows. Estimates are never too
+	// low, and are too high by at most about 3/Width of all uses with
+	// probability 1-2^-Depth. The defaults are 2048 and 4.
+	Width, Depth int
+	// Uses are counted with exponential decay, so that a use Window ago
+	// counts for 1/e of a use now, and a key used at a steady rate r has a
+	// count of about r*Window. The default is 10 seconds.
+	Window time.Duration
+	// If Rate and OnHot are set, OnHot is called with a key and its rate
+	// when the key's rate, in uses per second, rises to Rate or more. It is
+	// called again after the key's rate has fallen below Rate and risen
+	// again. OnHot is called by the goroutine using the key, without locks
+	// of the cache held, so it must be quick, but may use the cache.
+	Rate  float64
+	OnHot func(key string, rate float64)
+}
+
+// HotKey is a key's estimated number of uses, counted with decay, and the
+// rate of use that count corresponds to.
+type HotKey struct {
+	Key   string
+	Count float64
+	// Uses per second.
+	Rate float64
+}
+
+// decayDivisions is the most times counts are decayed in each window.
+const decayDivisions = 16
+
+// hotKeys is a tracker of heavy hitters: a count-min sketch of the uses of
+// all keys, and a heap of the K keys with the highest estimates. Counting a
+// use updates the sketch without locks; the heap is locked only for keys
+// whose estimates are high enough to be in it.
+type hotKeys struct {
+	opts  HotKeyOptions
+	seeds []maphash.Seed
+	// cells holds the sketch's counters, as float64 bits, row by row.
+	cells []atomic.Uint64
+	// The time counts were last decayed, in Unix nanoseconds.
+	decayed atomic.Int64
+
+	mu  sync.Mutex
+	top topKeys
+	idx map[string]*topKey
+	// min is the lowest count in a full heap, as float64 bits, or 0.
+	min atomic.Uint64
+}
+
+type topKey struct {
+	key   string
+	count float64
+	hot   bool
+	i     int
+}
+
+// topKeys is a min-heap of keys by count.
+type topKeys []*topKey
+
+func (h topKeys) Len() int           { return len(h) }
+func (h topKeys) Less(i, j int) bool { return h[i].count < h[j].count }
+func (h topKeys) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].i, h[j].i = i, j
+}
+func (h *topKeys) Push(x any) {
+	k := x.(*topKey)
+	k.i = len(*h)
+	*h = append(*h, k)
+}
+func (h *topKeys) Pop() any {
+	old := *h
+	k := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return k
+}
+
+func newHotKeys(opts HotKeyOptions) *hotKeys {
+	if opts.K <= 0 {
+		opts.K = 100
+	}
+	if opts.Width <= 0 {
+		opts.Width = 2048
+	}
+	if opts.Depth <= 0 {
+		opts.Depth = 4
+	}
+	if opts.Window <= 0 {
+		opts.Window = 10 * time.Second
+	}
+	h := &hotKeys{
+		opts:  opts,
+		seeds: make([]maphash.Seed, opts.Depth),
+		cells: make([]atomic.Uint64, opts.Width*opts.Depth),
+		idx:   make(map[string]*topKey, opts.K),
+	}
+	for i := range h.seeds {
+		h.seeds[i] = maphash.MakeSeed()
+	}
+	h.decayed.Store(time.Now().UnixNano())
+	return h
+}
+
+// addFloat adds d to the float64 whose bits are in u.
+func addFloat(u *atomic.Uint64, d float64) float64 {
+	for {
+		old := u.Load()
+		x := math.Float64frombits(old) + d
+		if u.CompareAndSwap(old, math.Float64bits(x)) {
+			return x
+		}
+	}
+}
+
+// record counts a use of key. It does nothing if h is nil, so caches that
+// don't track hot keys can call it.
+func (h *hotKeys) record(key string) {
+	if h == nil {
+		return
+	}
+	h.decay(time.Now())
+	est := math.Inf(1)
+	for i, seed := range h.seeds {
+		cell := &h.cells[i*h.opts.Width+int(maphash.String(seed, key)%uint64(h.opts.Width))]
+		est = min(est, addFloat(cell, 1))
+	}
+	if est <= math.Float64frombits(h.min.Load()) {
+		return
+	}
+
+	h.mu.Lock()
+	k, ok := h.idx[key]
+	switch {
+	case ok:
+		k.count = est
+		heap.Fix(&h.top, k.i)
+	case len(h.top) < h.opts.K:
+		k = &topKey{key: key, count: est}
+		heap.Push(&h.top, k)
+		h.idx[key] = k
+	case est > h.top[0].count:
+		delete(h.idx, h.top[0].key)
+		k = &topKey{key: key, count: est}
+		h.top[0] = k
+		heap.Fix(&h.top, 0)
+		h.idx[key] = k
+	}
+	if len(h.top) == h.opts.K {
+		h.min.Store(math.Float64bits(h.top[0].count))
+	}
+	var hot bool
+	rate := est / h.opts.Window.Seconds()
+	if k != nil && h.opts.OnHot != nil && h.opts.Rate > 0 {
+		hot = rate >= h.opts.Rate && !k.hot
+		k.hot = rate >= h.opts.Rate
+	}
+	h.mu.Unlock()
+	if hot {
+		h.opts.OnHot(key, rate)
+	}
+}
+
+// decay scales the counts down for the time passed since they were last
+// decayed, if that is more than a fraction of the window. Only one of the
+// goroutines that find counts due for decay does it.
+func (h *hotKeys) decay(now time.Time) {
+	last := h.decayed.Load()
+	elapsed := now.UnixNano() - last
+	if elapsed < int64(h.opts.Window)/decayDivisions || !h.decayed.CompareAndSwap(last, now.UnixNano()) {
+		return
+	}
+	f := math.Exp(-float64(elapsed) / float64(h.opts.Window))
+	for i := range h.cells {
+		for {
+			old := h.cells[i].Load()
+			if old == 0 || h.cells[i].CompareAndSwap(old, math.Float64bits(math.Float64frombits(old)*f)) {
+				break
+			}
+		}
+	}
+	h.mu.Lock()
+	for _, k := range h.top {
+		k.count *= f
+		if k.hot && k.count/h.opts.Window.Seconds() < h.opts.Rate {
+			k.hot = false
+		}
+	}
+	if len(h.top) == h.opts.K {
+		h.min.Store(math.Float64bits(h.top[0].count))
+	}
+	h.mu.Unlock()
+}
+
+// hottest returns the n tracked keys with the highest counts, hottest first.
+func (h *hotKeys) hottest(n int) []HotKey {
+	if h == nil {
+		return nil
+	}
+	h.decay(time.Now())
+	h.mu.Lock()
+	keys := make([]HotKey, len(h.top))
+	for i, k := range h.top {
+		keys[i] = HotKey{Key: k.key, Count: k.count, Rate: k.count / h.opts.Window.Seconds()}
+	}
+	h.mu.Unlock()
+	slices.SortFunc(keys, func(a, b HotKey) int {
+		if c := cmp.Compare(b.Count, a.Count); c != 0 {
+			return c
+		}
+		return strings.Compare(a.Key, b.Key)
+	})
+	if n < len(keys) {
+		keys = keys[:max(n, 0)]
+	}
+	return keys
+}
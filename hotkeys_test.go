@@ -0,0 +1,103 @@
+package cache
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestHotKeys(t *testing.T) {
+	tc := New(NoExpiration, 0, NewConcurrentMap())
+	if keys := tc.HotKeys(10); keys != nil {
+		t.Error("HotKeys returned", keys, "without tracking")
+	}
+	tc.TrackHotKeys(HotKeyOptions{K: 5, Window: time.Hour})
+	tc.Set("hot", 1, NoExpiration)
+	for i := 0; i < 999; i++ {
+		tc.Get("hot")
+	}
+	for i := 0; i < 100; i++ {
+		for j := 0; j < 5; j++ {
+			tc.Get(fmt.Sprint("cold", i))
+		}
+	}
+	tc.SetMulti(map[string]interface{}{"warm": 1}, NoExpiration)
+	tc.GetMulti([]string{"warm", "warm", "warm", "warm", "warm", "warm", "warm", "warm", "warm"})
+
+	keys := tc.HotKeys(2)
+	if len(keys) != 2 || keys[0].Key != "hot" || keys[1].Key != "warm" {
+		t.Fatal("HotKeys returned", keys)
+	}
+	if keys[0].Count < 999 || keys[0].Count > 1100 {
+		t.Error("the hottest key's count is", keys[0].Count, "want about 1000")
+	}
+	if want := keys[0].Count / time.Hour.Seconds(); keys[0].Rate != want {
+		t.Error("the hottest key's rate is", keys[0].Rate, "want", want)
+	}
+	if keys := tc.HotKeys(10); len(keys) != 5 {
+		t.Error("HotKeys returned", len(keys), "keys, want the 5 tracked")
+	}
+	if keys := tc.HotKeys(-1); keys == nil || len(keys) != 0 {
+		t.Error("HotKeys(-1) returned", keys)
+	}
+
+	tc.StopTrackingHotKeys()
+	if keys := tc.HotKeys(10); keys != nil {
+		t.Error("HotKeys returned", keys, "after tracking stopped")
+	}
+}
+
+func TestHotKeysDecay(t *testing.T) {
+	c := NewLRUCache(10, NoExpiration, time.Minute)
+	defer c.Close()
+	c.TrackHotKeys(HotKeyOptions{Window: 50 * time.Millisecond})
+	for i := 0; i < 100; i++ {
+		c.Get("a")
+	}
+	<-time.After(100 * time.Millisecond)
+	c.Set("b", 1, NoExpiration)
+	keys := c.HotKeys(10)
+	if len(keys) != 2 || keys[0].Key != "a" {
+		t.Fatal("HotKeys returned", keys)
+	}
+	// Two windows have passed, so the count is at most 100/e².
+	if keys[0].Count > 100/7.0 {
+		t.Error("the count of a key not used for two windows is", keys[0].Count)
+	}
+}
+
+func TestHotKeysOnHot(t *testing.T) {
+	tc := New(NoExpiration, 0, NewConcurrentMap())
+	var mu sync.Mutex
+	var hot []string
+	tc.TrackHotKeys(HotKeyOptions{
+		Window: 100 * time.Millisecond,
+		Rate:   200,
+		OnHot: func(key string, rate float64) {
+			if rate < 200 {
+				t.Error("OnHot was called with rate", rate)
+			}
+			mu.Lock()
+			hot = append(hot, key)
+			mu.Unlock()
+		},
+	})
+	burst := func() {
+		for i := 0; i < 100; i++ {
+			tc.Get("a")
+			if i%10 == 0 {
+				tc.Get("b")
+			}
+		}
+	}
+	burst()
+	// Let the rate of a fall below 200, so that OnHot can be called again.
+	<-time.After(300 * time.Millisecond)
+	burst()
+	mu.Lock()
+	defer mu.Unlock()
+	if len(hot) != 2 || hot[0] != "a" || hot[1] != "a" {
+		t.Error("OnHot was called for", hot, "want a twice")
+	}
+}
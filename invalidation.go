@@ -0,0 +1,358 @@
+package cache
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrBroadcasterClosed is returned by a Broadcaster that has been closed.
+var ErrBroadcasterClosed = errors.New("cache: broadcaster closed")
+
+// Invalidation tells the caches of other instances to drop their copies of a
+// key, or of every key.
+type Invalidation struct {
+	// The instance that published the invalidation, which ignores it when
+	// it is delivered back.
+	Origin string
+	// Numbers the messages an instance publishes, starting at 1, so that
+	// duplicates can be ignored and lost messages noticed.
+	Seq uint64
+	Key string
+	// Flush invalidates every key.
+	Flush bool
+	// A heartbeat invalidates nothing, but carries the Seq of the last
+	// message its origin published so that the loss of that message can be
+	// noticed.
+	Heartbeat bool
+}
+
+// A Broadcaster delivers invalidations between instances. It may deliver an
+// invalidation more than once, lose it, or deliver it back to its sender;
+// Invalidator copes with all three.
+type Broadcaster interface {
+	// Publish sends inv to the other instances.
+	Publish(inv Invalidation) error
+	// Receive returns the next invalidation published by an instance,
+	// waiting until there is one. It returns ErrBroadcasterClosed once the
+	// broadcaster has been closed.
+	Receive() (Invalidation, error)
+	Close() error
+}
+
+// The shortest and longest waits between attempts to receive after Receive
+// fails.
+const (
+	minReceiveBackoff = 10 * time.Millisecond
+	maxReceiveBackoff = time.Second
+)
+
+// InvalidatorOptions configure an Invalidator.
+type InvalidatorOptions struct {
+	// How often a heartbeat is published. The default is a second. Lost
+	// invalidations are noticed by the next message that arrives, so this
+	// bounds how long a copy that should have been invalidated can be
+	// served.
+	Heartbeat time.Duration
+	// The number of invalidations waiting to be published that are buffered.
+	// The default is 1024. Invalidations that don't fit are dropped, which
+	// other instances treat as lost.
+	Buffer int
+}
+
+// InvalidatorStats are counters of an Invalidator's activity.
+type InvalidatorStats struct {
+	// Invalidations published, and those dropped because the buffer was
+	// full.
+	Published uint64
+	Dropped   uint64
+	// Invalidations from other instances applied, ignored because they had
+	// already been applied, and gaps in the messages from an instance after
+	// which the whole cache was flushed.
+	Applied    uint64
+	Duplicates uint64
+	Gaps       uint64
+}
+
+// An Invalidator keeps a cache from serving copies of items that other
+// instances have changed. It publishes an invalidation on a Broadcaster for
+// every key set or deleted in its cache, and for every flush, and deletes the
+// keys invalidated by other instances. Invalidations it applies are not
+// published again, and expired and evicted items are not published, since
+// each instance removes those itself.
+//
+// Delivery is at least once: an invalidation that arrives twice is applied
+// once, and when messages from an instance are lost, which receivers notice
+// by a gap in their sequence numbers, the whole cache is flushed, since the
+// keys they invalidated are unknown.
+type Invalidator struct {
+	c      *cache
+	b      Broadcaster
+	origin string
+	opts   InvalidatorOptions
+	queue  chan Invalidation
+	// Set when an invalidation has been dropped, so that the sender skips
+	// a sequence number to make the loss visible.
+	lost atomic.Bool
+	mu   sync.Mutex
+	// The last sequence number received from each origin.
+	last map[string]uint64
+	stop chan struct{}
+	// Closed when the sender and receiver have finished.
+	sent, received chan struct{}
+	once           sync.Once
+
+	published  atomic.Uint64
+	dropped    atomic.Uint64
+	applied    atomic.Uint64
+	duplicates atomic.Uint64
+	gaps       atomic.Uint64
+}
+
+// NewInvalidator starts publishing the invalidations of c on b and applying
+// those of other instances to c. The Invalidator must be closed when it is
+// no longer needed, which closes b.
+func NewInvalidator(c *Cache, b Broadcaster, opts InvalidatorOptions) *Invalidator {
+	if opts.Heartbeat <= 0 {
+		opts.Heartbeat = time.Second
+	}
+	if opts.Buffer <= 0 {
+		opts.Buffer = 1024
+	}
+	id := make([]byte, 8)
+	rand.Read(id)
+	i := &Invalidator{
+		c:        c.cache,
+		b:        b,
+		origin:   hex.EncodeToString(id),
+		opts:     opts,
+		queue:    make(chan Invalidation, opts.Buffer),
+		last:     map[string]uint64{},
+		stop:     make(chan struct{}),
+		sent:     make(chan struct{}),
+		received: make(chan struct{}),
+	}
+	c.events.subscribe(i)
+	go i.send()
+	go i.receive()
+	return i
+}
+
+// Origin returns the name the invalidator publishes invalidations under.
+func (i *Invalidator) Origin() string {
+	return i.origin
+}
+
+func (i *Invalidator) notify(ev Event) {
+	var inv Invalidation
+	switch ev.Type {
+	case EventSet, EventDelete:
+		inv.Key = ev.Key
+	case EventFlush:
+		inv.Flush = true
+	default:
+		return
+	}
+	select {
+	case i.queue <- inv:
+	default:
+		i.lost.Store(true)
+		i.dropped.Add(1)
+	}
+}
+
+// send publishes queued invalidations and heartbeats. Sequence numbers are
+// assigned here, rather than when invalidations are queued, so that they are
+// published in order.
+func (i *Invalidator) send() {
+	defer close(i.sent)
+	ticker := time.NewTicker(i.opts.Heartbeat)
+	defer ticker.Stop()
+	var seq uint64
+	publish := func(inv Invalidation) {
+		if i.lost.Swap(false) {
+			seq++
+		}
+		if !inv.Heartbeat {
+			seq++
+		}
+		inv.Origin, inv.Seq = i.origin, seq
+		// A failed publish is a lost message, which the next one reveals.
+		if i.b.Publish(inv) == nil && !inv.Heartbeat {
+			i.published.Add(1)
+		}
+	}
+	for {
+		select {
+		case inv := <-i.queue:
+			publish(inv)
+		case <-ticker.C:
+			publish(Invalidation{Heartbeat: true})
+		case <-i.stop:
+			// Publish what was queued before Close.
+			for {
+				select {
+				case inv := <-i.queue:
+					publish(inv)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+// receive applies the invalidations of other instances. While Receive keeps
+// failing, such as when the network is down, it waits longer and longer
+// between attempts, up to maxReceiveBackoff.
+func (i *Invalidator) receive() {
+	defer close(i.received)
+	var backoff time.Duration
+	for {
+		inv, err := i.b.Receive()
+		switch {
+		case errors.Is(err, ErrBroadcasterClosed):
+			return
+		case err != nil:
+			backoff = min(max(2*backoff, minReceiveBackoff), maxReceiveBackoff)
+			select {
+			case <-time.After(backoff):
+			case <-i.stop:
+				return
+			}
+		default:
+			backoff = 0
+			i.apply(inv)
+		}
+	}
+}
+
+// apply applies an invalidation received from the broadcaster.
+func (i *Invalidator) apply(inv Invalidation) {
+	if inv.Origin == i.origin {
+		return
+	}
+	i.mu.Lock()
+	last, seen := i.last[inv.Origin]
+	if seen && inv.Seq <= last {
+		i.mu.Unlock()
+		if !inv.Heartbeat {
+			i.duplicates.Add(1)
+		}
+		return
+	}
+	i.last[inv.Origin] = inv.Seq
+	i.mu.Unlock()
+	// The heartbeat of an origin carries the number of its last message,
+	// so one that is new means that message was lost.
+	gap := seen && (inv.Seq > last+1 || inv.Heartbeat)
+	switch {
+	case gap:
+		i.gaps.Add(1)
+		i.c.flush(i)
+	case inv.Flush:
+		i.c.flush(i)
+	case inv.Heartbeat:
+		return
+	default:
+		i.c.delete(inv.Key, i)
+	}
+	if !inv.Heartbeat {
+		i.applied.Add(1)
+	}
+}
+
+// Stats returns the invalidator's counters.
+func (i *Invalidator) Stats() InvalidatorStats {
+	return InvalidatorStats{
+		Published:  i.published.Load(),
+		Dropped:    i.dropped.Load(),
+		Applied:    i.applied.Load(),
+		Duplicates: i.duplicates.Load(),
+		Gaps:       i.gaps.Load(),
+	}
+}
+
+// Close stops publishing and applying invalidations, after publishing those
+// already queued, and closes the broadcaster.
+func (i *Invalidator) Close() error {
+	var err error
+	i.once.Do(func() {
+		i.c.events.unsubscribe(i)
+		close(i.stop)
+		// The sender finishes before the broadcaster is closed, and the
+		// receiver after, when Receive fails.
+		<-i.sent
+		err = i.b.Close()
+		<-i.received
+	})
+	return err
+}
+
+// A MemoryBus connects the Broadcasters of caches in one process, such as in
+// tests. It delivers every invalidation to every broadcaster, including the
+// sender's, in the order they were published.
+type MemoryBus struct {
+	mu      sync.RWMutex
+	members map[*memoryBroadcaster]struct{}
+}
+
+// NewMemoryBus returns an empty MemoryBus.
+func NewMemoryBus() *MemoryBus {
+	return &MemoryBus{members: map[*memoryBroadcaster]struct{}{}}
+}
+
+// Join returns a Broadcaster that publishes to and receives from the bus.
+func (bus *MemoryBus) Join() Broadcaster {
+	m := &memoryBroadcaster{bus: bus, ch: make(chan Invalidation, 1024), closed: make(chan struct{})}
+	bus.mu.Lock()
+	bus.members[m] = struct{}{}
+	bus.mu.Unlock()
+	return m
+}
+
+type memoryBroadcaster struct {
+	bus    *MemoryBus
+	ch     chan Invalidation
+	closed chan struct{}
+	once   sync.Once
+}
+
+func (m *memoryBroadcaster) Publish(inv Invalidation) error {
+	select {
+	case <-m.closed:
+		return ErrBroadcasterClosed
+	default:
+	}
+	m.bus.mu.RLock()
+	defer m.bus.mu.RUnlock()
+	for member := range m.bus.members {
+		select {
+		case member.ch <- inv:
+		case <-member.closed:
+		}
+	}
+	return nil
+}
+
+func (m *memoryBroadcaster) Receive() (Invalidation, error) {
+	select {
+	case inv := <-m.ch:
+		return inv, nil
+	case <-m.closed:
+		return Invalidation{}, ErrBroadcasterClosed
+	}
+}
+
+func (m *memoryBroadcaster) Close() error {
+	m.once.Do(func() {
+		close(m.closed)
+		m.bus.mu.Lock()
+		delete(m.bus.members, m)
+		m.bus.mu.Unlock()
+	})
+	return nil
+}
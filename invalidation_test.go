@@ -0,0 +1,213 @@
+package cache
+
+import (
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// waitFor polls cond until it is true or a second has passed.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(time.Second); !cond(); {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for", what)
+		}
+		<-time.After(time.Millisecond)
+	}
+}
+
+func TestInvalidator(t *testing.T) {
+	bus := NewMemoryBus()
+	a := New(NoExpiration, 0, NewConcurrentMap())
+	b := New(NoExpiration, 0, NewRwmMap())
+	for _, c := range []*Cache{a, b} {
+		c.Set("foo", "stale", NoExpiration)
+		c.Set("bar", "stale", NoExpiration)
+	}
+	ia := NewInvalidator(a, bus.Join(), InvalidatorOptions{})
+	defer ia.Close()
+	ib := NewInvalidator(b, bus.Join(), InvalidatorOptions{})
+	defer ib.Close()
+
+	a.Set("foo", "fresh", NoExpiration)
+	waitFor(t, "a's set to be applied", func() bool {
+		_, found := b.Get("foo")
+		return !found
+	})
+	if x, _ := a.Get("foo"); x != "fresh" {
+		t.Error("a's set was invalidated by its own invalidation, or b's delete:", x)
+	}
+
+	a.Delete("bar")
+	waitFor(t, "a's delete to be applied", func() bool {
+		_, found := b.Get("bar")
+		return !found
+	})
+	b.Delete("missing")
+	b.Set("expiring", 1, time.Nanosecond)
+	waitFor(t, "b's set to be applied", func() bool { return ia.Stats().Applied == 1 })
+	b.DeleteExpired()
+
+	a.Set("keep", 1, NoExpiration)
+	b.Flush()
+	waitFor(t, "b's flush to be applied", func() bool { return a.ItemCount() == 0 })
+
+	// Let every invalidation be delivered, then check none was published
+	// by the instance applying it.
+	<-time.After(20 * time.Millisecond)
+	sa, sb := ia.Stats(), ib.Stats()
+	if sa.Published != 3 || sb.Published != 2 {
+		t.Errorf("a and b published %d and %d invalidations, want 3 and 2", sa.Published, sb.Published)
+	}
+	if sa.Applied != sb.Published || sb.Applied != sa.Published {
+		t.Errorf("a applied %d of b's %d invalidations and b %d of a's %d", sa.Applied, sb.Published, sb.Applied, sa.Published)
+	}
+	if sa.Gaps != 0 || sb.Gaps != 0 || sa.Duplicates != 0 || sb.Duplicates != 0 {
+		t.Errorf("Stats are %+v and %+v, want no gaps or duplicates", sa, sb)
+	}
+}
+
+// fakeBroadcaster delivers invalidations published by a test.
+type fakeBroadcaster struct {
+	ch     chan Invalidation
+	closed chan struct{}
+}
+
+func newFakeBroadcaster() *fakeBroadcaster {
+	return &fakeBroadcaster{ch: make(chan Invalidation), closed: make(chan struct{})}
+}
+
+func (f *fakeBroadcaster) Publish(inv Invalidation) error { return nil }
+
+func (f *fakeBroadcaster) Receive() (Invalidation, error) {
+	select {
+	case inv := <-f.ch:
+		return inv, nil
+	case <-f.closed:
+		return Invalidation{}, ErrBroadcasterClosed
+	}
+}
+
+func (f *fakeBroadcaster) Close() error {
+	close(f.closed)
+	return nil
+}
+
+func TestInvalidatorDuplicatesAndGaps(t *testing.T) {
+	tc := New(NoExpiration, 0, NewConcurrentMap())
+	f := newFakeBroadcaster()
+	inv := NewInvalidator(tc, f, InvalidatorOptions{})
+	defer inv.Close()
+	set := func(keys ...string) {
+		for _, k := range keys {
+			tc.Set(k, 1, NoExpiration)
+		}
+	}
+	// Sends wait for the previous invalidation to be applied, so checks
+	// after the next send see its effect.
+	send := func(invs ...Invalidation) {
+		for _, i := range invs {
+			f.ch <- i
+		}
+	}
+	settle := func() { send(Invalidation{Origin: inv.Origin()}) }
+
+	set("a", "b", "c", "d")
+	send(Invalidation{Origin: "x", Seq: 7, Key: "a"}, Invalidation{Origin: "x", Seq: 7, Key: "b"})
+	settle()
+	if tc.ItemCount() != 3 {
+		t.Error("an invalidation was not applied once:", tc.Items())
+	}
+	if _, found := tc.Get("b"); !found {
+		t.Error("a duplicate invalidation was applied")
+	}
+	send(Invalidation{Origin: "x", Seq: 8, Heartbeat: true})
+	settle()
+	if tc.ItemCount() != 0 {
+		t.Error("a heartbeat after a lost invalidation did not flush the cache")
+	}
+
+	set("a", "b")
+	send(Invalidation{Origin: "x", Seq: 8, Heartbeat: true}, Invalidation{Origin: "x", Seq: 9, Key: "a"})
+	settle()
+	if _, found := tc.Get("b"); !found {
+		t.Error("a heartbeat without a loss flushed the cache")
+	}
+	send(Invalidation{Origin: "x", Seq: 11, Key: "zzz"})
+	settle()
+	if tc.ItemCount() != 0 {
+		t.Error("a gap in sequence numbers did not flush the cache")
+	}
+
+	set("a")
+	send(Invalidation{Origin: inv.Origin(), Seq: 1, Key: "a"}, Invalidation{Origin: "y", Seq: 1, Flush: true})
+	settle()
+	if st := inv.Stats(); st.Applied != 4 || st.Duplicates != 1 || st.Gaps != 2 {
+		t.Errorf("Stats are %+v, want 4 applied, 1 duplicate and 2 gaps", st)
+	}
+	if tc.ItemCount() != 0 {
+		t.Error("a flush invalidation was not applied")
+	}
+}
+
+func TestInvalidatorDrops(t *testing.T) {
+	bus := NewMemoryBus()
+	a := New(NoExpiration, 0, NewConcurrentMap())
+	b := New(NoExpiration, 0, NewConcurrentMap())
+	ib := NewInvalidator(b, bus.Join(), InvalidatorOptions{Heartbeat: 5 * time.Millisecond})
+	defer ib.Close()
+	ia := NewInvalidator(a, bus.Join(), InvalidatorOptions{Buffer: 1, Heartbeat: 5 * time.Millisecond})
+	defer ia.Close()
+
+	a.Set("first", 1, NoExpiration)
+	waitFor(t, "the first invalidation", func() bool { return ib.Stats().Applied == 1 })
+	b.Set("cached", 1, NoExpiration)
+	for i := 0; i < 1000 && ia.Stats().Dropped == 0; i++ {
+		a.Set("k", i, NoExpiration)
+	}
+	if ia.Stats().Dropped == 0 {
+		t.Skip("no invalidation was dropped")
+	}
+	waitFor(t, "the lost invalidation to be noticed", func() bool { return ib.Stats().Gaps > 0 })
+	if _, found := b.Get("cached"); found {
+		t.Error("a lost invalidation did not flush the cache")
+	}
+}
+
+// failingBroadcaster is a Broadcaster whose Receive fails until it is closed.
+type failingBroadcaster struct {
+	receives atomic.Int32
+	closed   chan struct{}
+}
+
+func (f *failingBroadcaster) Publish(inv Invalidation) error { return nil }
+
+func (f *failingBroadcaster) Receive() (Invalidation, error) {
+	f.receives.Add(1)
+	select {
+	case <-f.closed:
+		return Invalidation{}, ErrBroadcasterClosed
+	default:
+		return Invalidation{}, errors.New("network is down")
+	}
+}
+
+func (f *failingBroadcaster) Close() error {
+	close(f.closed)
+	return nil
+}
+
+func TestInvalidatorReceiveBackoff(t *testing.T) {
+	f := &failingBroadcaster{closed: make(chan struct{})}
+	i := NewInvalidator(New(NoExpiration, 0, NewConcurrentMap()), f, InvalidatorOptions{})
+	<-time.After(100 * time.Millisecond)
+	if err := i.Close(); err != nil {
+		t.Error("Close failed:", err)
+	}
+	// 10, 20 and 40ms waits fit in 100ms.
+	if n := f.receives.Load(); n > 5 {
+		t.Errorf("Receive was called %d times in 100ms while it failed", n)
+	}
+}
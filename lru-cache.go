@@ -2,31 +2,56 @@ package cache
 
 import (
 	"container/list"
+	"iter"
 	"runtime"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
 type LRUCache struct {
 	mu       sync.RWMutex
 	maxItems int
-	stopChan chan struct{}
+	// The most bytes the items may use, or 0 for no limit, and the bytes
+	// used by their keys and values. maxBytes is only changed with mu held,
+	// but is read without it to decide whether to size a value. Values are
+	// only sized while there is a limit, so valueBytes is only kept then.
+	maxBytes   atomic.Int64
+	keyBytes   int64
+	valueBytes int64
+	stopChan   chan struct{}
+	stopOnce   sync.Once
 
 	expireTime time.Duration
 	cleanTime  time.Duration
 
 	cache   map[string]*list.Element
 	lruList *list.List
+
+	events    eventHub
+	stats     stats
+	observing observing
+	hot       atomic.Pointer[hotKeys]
 }
 
 type CacheItem struct {
 	key      string
 	value    interface{}
 	expireAt time.Time
+	// The size of value, as estimated by SizeOf when it was set, or when
+	// the byte limit was set if that was later.
+	size int64
 }
 
+// lruEntryOverhead is the estimated number of bytes an LRUCache uses for
+// each item besides its key and value: a map slot, a list element and a
+// CacheItem.
+const lruEntryOverhead = 32 + 48 + 64
+
+// isExpired reports whether the item has expired. Items with a zero expireAt
+// never expire.
 func (c *CacheItem) isExpired() bool {
-	return c.expireAt.Before(time.Now())
+	return !c.expireAt.IsZero() && c.expireAt.Before(time.Now())
 }
 
 func NewLRUCache(maxItems int, expireTime time.Duration, cleanTime time.Duration) *LRUCache {
@@ -39,56 +64,460 @@ func NewLRUCache(maxItems int, expireTime time.Duration, cleanTime time.Duration
 		stopChan:   make(chan struct{}),
 	}
 	go c.startGC()
-	runtime.SetFinalizer(c, func() { c.stopChan <- struct{}{} })
+	runtime.SetFinalizer(c, (*LRUCache).Close)
 	return c
 }
 
 func (c *LRUCache) Get(key string) (interface{}, bool) {
 	c.mu.RLock()
-	if ele, hit := c.cache[key]; hit && !ele.Value.(*CacheItem).isExpired() {
+	ele, hit := c.cache[key]
+	if hit && !ele.Value.(*CacheItem).isExpired() {
+		// The value is read under the lock, since set replaces it in place.
+		value := ele.Value.(*CacheItem).value
 		c.mu.RUnlock()
 		c.mu.Lock()
 		c.lruList.MoveToFront(ele)
 		c.mu.Unlock()
-		return ele.Value.(*CacheItem).value, true
+		c.lookup(key, GetHit)
+		return value, true
 	}
 	c.mu.RUnlock()
+	if hit {
+		c.lookup(key, GetExpired)
+	} else {
+		c.lookup(key, GetMiss)
+	}
 	return nil, false
 }
 
-func (c *LRUCache) Set(key string, value interface{}) {
+// lookup counts a lookup of key and tells the Observer of it.
+func (c *LRUCache) lookup(key string, r GetResult) {
+	c.stats.lookup(key, r == GetHit)
+	c.observing.get(key, r)
+	c.hot.Load().record(key)
+}
+
+// Set adds an item to the cache, replacing any existing item. A duration of
+// DefaultExpiration uses the cache's expiration time, and NoExpiration, or
+// any other negative duration, keeps the item until it is evicted or deleted,
+// as in Cache.Set.
+func (c *LRUCache) Set(key string, value interface{}, d time.Duration) {
+	c.hot.Load().record(key)
+	size := c.sizeOf(value)
 	c.mu.Lock()
 	defer c.mu.Unlock()
+	c.set(key, value, size, c.expireAt(d))
+}
+
+// SetDefault adds an item to the cache, replacing any existing item, using the
+// cache's expiration time.
+func (c *LRUCache) SetDefault(key string, value interface{}) {
+	c.Set(key, value, DefaultExpiration)
+}
+
+// GetWithExpiration returns an item and its expiration time, or the zero time
+// if it never expires, and whether it was found and has not expired. Like
+// Get, it marks the item as recently used.
+func (c *LRUCache) GetWithExpiration(key string) (interface{}, time.Time, bool) {
+	c.mu.Lock()
+	ele, hit := c.cache[key]
+	if !hit {
+		c.mu.Unlock()
+		c.lookup(key, GetMiss)
+		return nil, time.Time{}, false
+	}
+	if ele.Value.(*CacheItem).isExpired() {
+		c.mu.Unlock()
+		c.lookup(key, GetExpired)
+		return nil, time.Time{}, false
+	}
+	c.lruList.MoveToFront(ele)
+	item := ele.Value.(*CacheItem)
+	value, expireAt := item.value, item.expireAt
+	c.mu.Unlock()
+	c.lookup(key, GetHit)
+	return value, expireAt, true
+}
+
+// unsized is the size of a value that sizeOf didn't size.
+const unsized = -1
+
+// sizeOf returns the size of value if there is a byte limit, and unsized
+// otherwise. It is called before c.mu is taken, so set sizes the value
+// itself if a limit has been set since.
+func (c *LRUCache) sizeOf(value interface{}) int64 {
+	if c.maxBytes.Load() == 0 {
+		return unsized
+	}
+	return SizeOf(value)
+}
+
+// set adds or replaces an item whose value has the given size, or unsized,
+// then evicts items if the cache holds more than it may. c.mu must be held,
+// so callers size values with sizeOf before taking it where they can.
+func (c *LRUCache) set(key string, value interface{}, size int64, expireAt time.Time) {
+	switch {
+	case c.maxBytes.Load() == 0:
+		size = 0
+	case size == unsized:
+		size = SizeOf(value)
+	}
 	if ele, hit := c.cache[key]; hit {
 		c.lruList.MoveToFront(ele)
 
-		ele.Value.(*CacheItem).expireAt = time.Now().Add(c.expireTime)
-		ele.Value.(*CacheItem).value = value
+		item := ele.Value.(*CacheItem)
+		old := item.value
+		if item.isExpired() {
+			old = nil
+		}
+		item.expireAt = expireAt
+		item.value = value
+		c.valueBytes += size - item.size
+		item.size = size
+		c.publishSet(item, old)
+		c.trim()
 		return
 	}
 
-	ele := c.lruList.PushFront(&CacheItem{key: key, value: value, expireAt: time.Now().Add(c.expireTime)})
+	ele := c.lruList.PushFront(&CacheItem{key: key, value: value, expireAt: expireAt, size: size})
 	c.cache[key] = ele
+	c.keyBytes += int64(len(key))
+	c.valueBytes += size
+	c.publishSet(ele.Value.(*CacheItem), nil)
+	c.trim()
+}
+
+// trim evicts the least recently used items while there are more than
+// maxItems or they use more than maxBytes. c.mu must be held.
+func (c *LRUCache) trim() {
+	maxBytes := c.maxBytes.Load()
+	for c.lruList.Len() > c.maxItems || (maxBytes > 0 && c.lruList.Len() > 0 && c.usage().Total() > maxBytes) {
+		c.remove(c.lruList.Back(), EventEvict)
+	}
+}
 
-	if c.lruList.Len() > c.maxItems {
-		// Remove least recently used item
-		ele := c.lruList.Back()
-		if ele != nil {
-			c.lruList.Remove(ele)
-			delete(c.cache, ele.Value.(*CacheItem).key)
+// usage returns the memory used by the items. c.mu must be held. Without a
+// byte limit, values aren't sized as they are set, so they are sized here.
+func (c *LRUCache) usage() MemoryUsage {
+	valueBytes := c.valueBytes
+	if c.maxBytes.Load() == 0 {
+		valueBytes = 0
+		for ele := c.lruList.Front(); ele != nil; ele = ele.Next() {
+			valueBytes += SizeOf(ele.Value.(*CacheItem).value)
 		}
 	}
+	return MemoryUsage{
+		Items:         len(c.cache),
+		KeyBytes:      c.keyBytes,
+		ValueBytes:    valueBytes,
+		OverheadBytes: int64(len(c.cache)) * lruEntryOverhead,
+	}
+}
+
+// MemoryUsage estimates the memory used by the cache's items, including
+// expired items that have not yet been removed. With a limit set by
+// SetMaxBytes, the sizes of values are estimated with SizeOf when they are
+// set, so changes made to them after that are not seen. Without one, values
+// are only sized by MemoryUsage, which then takes time proportional to the
+// number of items.
+func (c *LRUCache) MemoryUsage() MemoryUsage {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.usage()
+}
+
+// SetMaxBytes limits the memory used by the items, as estimated by
+// MemoryUsage, to n bytes, evicting the least recently used items when it is
+// exceeded, or removes the limit if n is 0 or less. An item too large to fit
+// is evicted as soon as it is set. Values are only sized while there is a
+// limit, so setting one where there was none sizes every item.
+func (c *LRUCache) SetMaxBytes(n int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.maxBytes.Load() == 0 && n > 0 {
+		c.valueBytes = 0
+		for ele := c.lruList.Front(); ele != nil; ele = ele.Next() {
+			item := ele.Value.(*CacheItem)
+			item.size = SizeOf(item.value)
+			c.valueBytes += item.size
+		}
+	}
+	c.maxBytes.Store(max(n, 0))
+	c.trim()
+}
+
+// publishSet publishes the event of setting item, replacing old.
+func (c *LRUCache) publishSet(item *CacheItem, old interface{}) {
+	if !c.events.active() {
+		return
+	}
+	var e int64
+	if !item.expireAt.IsZero() {
+		e = item.expireAt.UnixNano()
+	}
+	c.events.publish(Event{Type: EventSet, Key: item.key, OldValue: old, NewValue: item.value, Expiration: e})
+}
+
+// remove removes an item and publishes an event of type t for it. c.mu must
+// be held.
+func (c *LRUCache) remove(ele *list.Element, t EventType) {
+	item := ele.Value.(*CacheItem)
+	c.lruList.Remove(ele)
+	delete(c.cache, item.key)
+	c.keyBytes -= int64(len(item.key))
+	c.valueBytes -= item.size
+	switch t {
+	case EventEvict:
+		c.stats.evictions.Add(1)
+	case EventExpire:
+		c.stats.expirations.Add(1)
+	}
+	if c.events.active() {
+		old := item.value
+		if t != EventExpire && item.isExpired() {
+			old = nil
+		}
+		c.events.publish(Event{Type: t, Key: item.key, OldValue: old})
+	}
 }
 
 func (c *LRUCache) Delete(key string) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 	if ele, hit := c.cache[key]; hit {
-		c.lruList.Remove(ele)
-		delete(c.cache, key)
+		c.remove(ele, EventDelete)
 	}
 }
 
+// Flush deletes all items from the cache.
+func (c *LRUCache) Flush() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	clear(c.cache)
+	c.lruList.Init()
+	c.keyBytes, c.valueBytes = 0, 0
+	if c.events.active() {
+		c.events.publish(Event{Type: EventFlush})
+	}
+}
+
+// Watch returns a channel that receives an Event for every change to the
+// item for key, like Cache.Watch, including evict events when items are
+// removed to make room for others. Events are published with the cache's
+// lock held, so a watcher using the BlockWriters policy holds up every
+// access to the cache until it has room.
+func (c *LRUCache) Watch(key string, opts WatchOptions) (<-chan Event, func()) {
+	return c.events.watch(key, opts)
+}
+
+// TrackHotKeys starts tracking the keys looked up and set most often, like
+// Cache.TrackHotKeys.
+func (c *LRUCache) TrackHotKeys(opts HotKeyOptions) {
+	c.hot.Store(newHotKeys(opts))
+}
+
+// StopTrackingHotKeys stops tracking hot keys.
+func (c *LRUCache) StopTrackingHotKeys() {
+	c.hot.Store(nil)
+}
+
+// HotKeys returns up to n of the keys looked up and set most often, hottest
+// first, or nil if hot keys aren't being tracked.
+func (c *LRUCache) HotKeys(n int) []HotKey {
+	return c.hot.Load().hottest(n)
+}
+
+// SetObserver sets the Observer told of the cache's operations, replacing any
+// other, or removes it if o is nil. The Observer is told of changes with the
+// cache's lock held.
+func (c *LRUCache) SetObserver(o Observer) {
+	c.observing.set(&c.events, o)
+}
+
+// Compute atomically updates the item for key, like Cache.Compute. It calls f
+// with the lock held, so f must not access the cache. A duration of
+// DefaultExpiration uses the cache's expiration time, and NoExpiration keeps
+// the item until it is evicted or deleted. Computing an item marks it as
+// recently used unless it is deleted.
+func (c *LRUCache) Compute(key string, f func(old interface{}, found bool) (interface{}, time.Duration, Op)) (interface{}, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var old interface{}
+	ele, hit := c.cache[key]
+	found := hit && !ele.Value.(*CacheItem).isExpired()
+	if found {
+		old = ele.Value.(*CacheItem).value
+	}
+	value, d, op := f(old, found)
+	switch op {
+	case OpSet:
+		at := c.expireAt(d)
+		if d == KeepExpiration && found {
+			at = ele.Value.(*CacheItem).expireAt
+		}
+		c.set(key, value, unsized, at)
+		return value, true
+	case OpDelete:
+		if hit {
+			c.remove(ele, EventDelete)
+		}
+		return nil, false
+	}
+	if found {
+		c.lruList.MoveToFront(ele)
+	}
+	return old, found
+}
+
+// expireAt returns the expiration time of an item set now with duration d,
+// interpreted as in Set, or the zero time if it never expires.
+func (c *LRUCache) expireAt(d time.Duration) time.Time {
+	if d == DefaultExpiration {
+		d = c.expireTime
+	}
+	if d <= 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(d)
+}
+
+// GetMulti gets several items at once, taking the lock once for the whole
+// batch. It returns a map of the keys that were found and have not expired to
+// their values, and marks each of them as recently used.
+func (c *LRUCache) GetMulti(keys []string) map[string]interface{} {
+	values := make(map[string]interface{}, len(keys))
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, key := range keys {
+		if ele, hit := c.cache[key]; hit && !ele.Value.(*CacheItem).isExpired() {
+			c.lruList.MoveToFront(ele)
+			values[key] = ele.Value.(*CacheItem).value
+		}
+	}
+	if len(keys) > 0 {
+		c.stats.lookups(keys[0], len(values), len(keys)-len(values))
+	}
+	c.observing.getMulti(keys, values)
+	if h := c.hot.Load(); h != nil {
+		for _, key := range keys {
+			h.record(key)
+		}
+	}
+	return values
+}
+
+// SetMulti adds several items at once with duration d, as in Set, taking the
+// lock once for the whole batch. If the batch holds more items than fit in the
+// cache, which of them are kept is unspecified.
+func (c *LRUCache) SetMulti(items map[string]interface{}, d time.Duration) {
+	if h := c.hot.Load(); h != nil {
+		for key := range items {
+			h.record(key)
+		}
+	}
+	sizes := make(map[string]int64, len(items))
+	for key, value := range items {
+		sizes[key] = c.sizeOf(value)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	expireAt := c.expireAt(d)
+	for key, value := range items {
+		c.set(key, value, sizes[key], expireAt)
+	}
+}
+
+// DeleteMulti deletes several items at once, taking the lock once for the
+// whole batch.
+func (c *LRUCache) DeleteMulti(keys []string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, key := range keys {
+		if ele, hit := c.cache[key]; hit {
+			c.remove(ele, EventDelete)
+		}
+	}
+}
+
+// All returns an iterator over the keys and values of all unexpired items,
+// from most to least recently used. It iterates over a snapshot of the keys,
+// looking up each value when its key is visited, so keys deleted during
+// iteration are skipped and keys added are not seen. Iterating does not
+// affect the recency of the items.
+func (c *LRUCache) All() iter.Seq2[string, interface{}] {
+	return func(yield func(string, interface{}) bool) {
+		c.mu.RLock()
+		keys := make([]string, 0, c.lruList.Len())
+		for ele := c.lruList.Front(); ele != nil; ele = ele.Next() {
+			keys = append(keys, ele.Value.(*CacheItem).key)
+		}
+		c.mu.RUnlock()
+		for _, k := range keys {
+			c.mu.RLock()
+			ele, hit := c.cache[k]
+			if !hit || ele.Value.(*CacheItem).isExpired() {
+				c.mu.RUnlock()
+				continue
+			}
+			v := ele.Value.(*CacheItem).value
+			c.mu.RUnlock()
+			if !yield(k, v) {
+				return
+			}
+		}
+	}
+}
+
+// Keys returns an iterator over the keys of all unexpired items. It has the
+// same guarantees as All.
+func (c *LRUCache) Keys() iter.Seq[string] {
+	return func(yield func(string) bool) {
+		for k := range c.All() {
+			if !yield(k) {
+				return
+			}
+		}
+	}
+}
+
+// Values returns an iterator over the values of all unexpired items. It has
+// the same guarantees as All.
+func (c *LRUCache) Values() iter.Seq[interface{}] {
+	return func(yield func(interface{}) bool) {
+		for _, v := range c.All() {
+			if !yield(v) {
+				return
+			}
+		}
+	}
+}
+
+// Stats returns the cache's counters and its number of items, including
+// expired items that have not yet been removed.
+func (c *LRUCache) Stats() Stats {
+	st := c.stats.read()
+	c.mu.RLock()
+	st.Items = len(c.cache)
+	c.mu.RUnlock()
+	return st
+}
+
+// ItemCount returns the number of items in the cache, including expired items
+// that have not yet been removed.
+func (c *LRUCache) ItemCount() int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return len(c.cache)
+}
+
+// Close stops the goroutine removing expired items. The cache can still be
+// used, but expired items are only removed when they are overwritten or
+// evicted.
+func (c *LRUCache) Close() error {
+	c.stopOnce.Do(func() { close(c.stopChan) })
+	return nil
+}
+
 func (c *LRUCache) startGC() {
 	ticker := time.NewTicker(c.cleanTime)
 	for {
@@ -97,14 +526,18 @@ func (c *LRUCache) startGC() {
 			ticker.Stop()
 			return
 		case <-ticker.C:
+			start := time.Now()
+			n := 0
 			c.mu.Lock()
-			for key, ele := range c.cache {
+			for _, ele := range c.cache {
 				if ele.Value.(*CacheItem).isExpired() {
-					c.lruList.Remove(ele)
-					delete(c.cache, key)
+					c.remove(ele, EventExpire)
+					n++
 				}
 			}
 			c.mu.Unlock()
+			c.stats.sweep(start)
+			c.observing.sweep(start, n)
 		}
 	}
 }
@@ -13,17 +13,36 @@ func TestNewLRUCache(t *testing.T) {
 }
 
 func TestLRUCache_Get(t *testing.T) {
-	cache := NewLRUCache(10, 10, 10)
-	cache.Set("key", "value")
+	cache := NewLRUCache(10, time.Minute, time.Minute)
+	cache.Set("key", "value", DefaultExpiration)
 	value, ok := cache.Get("key")
 	if !ok || value != "value" {
 		t.Error("LRUCache Get failed")
 	}
 }
 
+// Run with -race to check that Get doesn't read values that Set replaces.
+func TestLRUCache_GetWhileSet(t *testing.T) {
+	cache := NewLRUCache(10, time.Minute, time.Minute)
+	cache.Set("key", 0, DefaultExpiration)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 1; i <= 1000; i++ {
+			cache.Set("key", i, DefaultExpiration)
+		}
+	}()
+	for i := 0; i < 1000; i++ {
+		if _, ok := cache.Get("key"); !ok {
+			t.Fatal("LRUCache Get missed a key being set")
+		}
+	}
+	<-done
+}
+
 func TestLRUCache_Delete(t *testing.T) {
 	cache := NewLRUCache(10, 10, 10)
-	cache.Set("key", "value")
+	cache.Set("key", "value", DefaultExpiration)
 	cache.Delete("key")
 	_, ok := cache.Get("key")
 	if ok {
@@ -31,12 +50,184 @@ func TestLRUCache_Delete(t *testing.T) {
 	}
 }
 
+func TestLRUCache_Flush(t *testing.T) {
+	cache := NewLRUCache(10, time.Minute, time.Minute)
+	cache.Set("a", 1, DefaultExpiration)
+	cache.Set("b", 2, DefaultExpiration)
+	events, cancel := cache.Watch("", WatchOptions{Prefix: true})
+	defer cancel()
+	cache.Flush()
+	if _, ok := cache.Get("a"); ok {
+		t.Error("LRUCache Flush failed")
+	}
+	if ev := <-events; ev.Type != EventFlush {
+		t.Error("Flush published", ev)
+	}
+	cache.Set("c", 3, DefaultExpiration)
+	if v, ok := cache.Get("c"); !ok || v != 3 {
+		t.Error("LRUCache Set after Flush failed")
+	}
+}
+
 func TestLRUCache_GC(t *testing.T) {
 	cache := NewLRUCache(10, time.Second, 2*time.Second)
-	cache.Set("key", "value")
+	cache.Set("key", "value", DefaultExpiration)
 	time.Sleep(3 * time.Second)
 	_, ok := cache.Get("key")
 	if ok {
 		t.Error("LRUCache GC failed")
 	}
 }
+
+func TestLRUCache_All(t *testing.T) {
+	cache := NewLRUCache(10, time.Minute, time.Minute)
+	cache.Set("a", 1, DefaultExpiration)
+	cache.Set("b", 2, DefaultExpiration)
+	cache.Set("c", 3, DefaultExpiration)
+	var keys []string
+	for k := range cache.Keys() {
+		keys = append(keys, k)
+	}
+	if len(keys) != 3 || keys[0] != "c" || keys[1] != "b" || keys[2] != "a" {
+		t.Error("LRUCache Keys not in most to least recently used order:", keys)
+	}
+	for k, v := range cache.All() {
+		if k != "c" || v != 3 {
+			t.Error("LRUCache All yielded wrong first item:", k, v)
+		}
+		break
+	}
+	sum := 0
+	for v := range cache.Values() {
+		sum += v.(int)
+	}
+	if sum != 6 {
+		t.Error("LRUCache Values summed to", sum)
+	}
+}
+
+func TestLRUCache_Multi(t *testing.T) {
+	cache := NewLRUCache(10, time.Minute, time.Minute)
+	cache.SetMulti(map[string]interface{}{"a": 1, "b": 2, "c": 3}, DefaultExpiration)
+	got := cache.GetMulti([]string{"a", "b", "missing"})
+	if len(got) != 2 || got["a"] != 1 || got["b"] != 2 {
+		t.Error("LRUCache GetMulti failed:", got)
+	}
+	cache.DeleteMulti([]string{"a", "c"})
+	got = cache.GetMulti([]string{"a", "b", "c"})
+	if len(got) != 1 || got["b"] != 2 {
+		t.Error("LRUCache DeleteMulti failed:", got)
+	}
+}
+
+func TestLRUCache_Compute(t *testing.T) {
+	cache := NewLRUCache(10, time.Minute, time.Minute)
+	add := func(old interface{}, found bool) (interface{}, time.Duration, Op) {
+		if !found {
+			return 1, DefaultExpiration, OpSet
+		}
+		return old.(int) + 1, NoExpiration, OpSet
+	}
+	cache.Compute("key", add)
+	if value, ok := cache.Compute("key", add); !ok || value != 2 {
+		t.Error("LRUCache Compute failed:", value)
+	}
+	cache.Compute("key", func(old interface{}, found bool) (interface{}, time.Duration, Op) {
+		return nil, DefaultExpiration, OpDelete
+	})
+	if _, ok := cache.Get("key"); ok {
+		t.Error("LRUCache Compute with OpDelete failed")
+	}
+	cache.Compute("short", func(old interface{}, found bool) (interface{}, time.Duration, Op) {
+		return 1, time.Nanosecond, OpSet
+	})
+	time.Sleep(time.Millisecond)
+	if _, ok := cache.Get("short"); ok {
+		t.Error("LRUCache Compute did not use the returned duration")
+	}
+	cache.Compute("short", func(old interface{}, found bool) (interface{}, time.Duration, Op) {
+		return 2, 20 * time.Millisecond, OpSet
+	})
+	cache.Compute("short", func(old interface{}, found bool) (interface{}, time.Duration, Op) {
+		return 3, KeepExpiration, OpSet
+	})
+	time.Sleep(30 * time.Millisecond)
+	if _, ok := cache.Get("short"); ok {
+		t.Error("LRUCache Compute with KeepExpiration did not keep the expiration")
+	}
+}
+
+func TestLRUCache_MaxBytes(t *testing.T) {
+	cache := NewLRUCache(100, NoExpiration, time.Minute)
+	defer cache.Close()
+	// Each item uses 1 byte of key, 16+10 of value, and the overhead.
+	itemSize := int64(1 + 26 + lruEntryOverhead)
+	cache.SetMaxBytes(3 * itemSize)
+	for _, k := range []string{"a", "b", "c"} {
+		cache.Set(k, "0123456789", NoExpiration)
+	}
+	if u := cache.MemoryUsage(); u.Items != 3 || u.Total() != 3*itemSize {
+		t.Errorf("MemoryUsage is %+v, total %d, want 3 items of %d bytes", u, u.Total(), itemSize)
+	}
+	cache.Get("a")
+	cache.Set("d", "0123456789", NoExpiration)
+	if _, found := cache.Get("b"); found {
+		t.Error("the least recently used item was not evicted when the budget was exceeded")
+	}
+	// Growing a value evicts others to make room.
+	cache.Set("a", "0123456789"+string(make([]byte, itemSize)), NoExpiration)
+	if u := cache.MemoryUsage(); u.Items != 2 || u.Total() > 3*itemSize {
+		t.Errorf("MemoryUsage is %+v after growing a value, want 2 items within the budget", u)
+	}
+	cache.Set("huge", string(make([]byte, 4*itemSize)), NoExpiration)
+	if _, found := cache.Get("huge"); found {
+		t.Error("an item larger than the budget was kept")
+	}
+
+	cache.SetMaxBytes(itemSize)
+	if u := cache.MemoryUsage(); u.Items > 1 || u.Total() > itemSize {
+		t.Errorf("MemoryUsage is %+v after lowering the budget", u)
+	}
+	cache.SetMaxBytes(0)
+	for _, k := range []string{"a", "b", "c", "d", "e"} {
+		cache.Set(k, "0123456789", NoExpiration)
+	}
+	if n := cache.ItemCount(); n != 5 {
+		t.Error("the cache holds", n, "items after the budget was removed, want 5")
+	}
+	if u := cache.MemoryUsage(); u.Total() != 5*itemSize {
+		t.Errorf("MemoryUsage is %+v without a budget, total %d, want %d", u, u.Total(), 5*itemSize)
+	}
+	// Items set without a budget are sized when one is set.
+	cache.SetMaxBytes(2 * itemSize)
+	if u := cache.MemoryUsage(); u.Items != 2 || u.Total() != 2*itemSize {
+		t.Errorf("MemoryUsage is %+v after setting a budget, want 2 items of %d bytes", u, itemSize)
+	}
+	cache.Delete("a")
+	cache.Flush()
+	if u := cache.MemoryUsage(); u != (MemoryUsage{}) {
+		t.Errorf("MemoryUsage is %+v after Flush", u)
+	}
+}
+
+func TestLRUCache_NegativeDuration(t *testing.T) {
+	cache := NewLRUCache(10, time.Hour, time.Minute)
+	defer cache.Close()
+	tc := New(time.Hour, 0, NewRwmMap())
+	for _, d := range []time.Duration{NoExpiration, KeepExpiration, -time.Second} {
+		cache.Set("a", 1, d)
+		if _, e, found := cache.GetWithExpiration("a"); !found || !e.IsZero() {
+			t.Errorf("LRUCache Set with duration %v set an item that expires at %v, found %t", d, e, found)
+		}
+		tc.Set("a", 1, d)
+		if _, e, found := tc.GetWithExpiration("a"); !found || !e.IsZero() {
+			t.Errorf("Cache Set with duration %v set an item that expires at %v, found %t", d, e, found)
+		}
+	}
+	zero := NewLRUCache(10, 0, time.Minute)
+	defer zero.Close()
+	zero.Set("a", 1, DefaultExpiration)
+	if _, e, found := zero.GetWithExpiration("a"); !found || !e.IsZero() {
+		t.Error("LRUCache with no expiration time set an item that expires at", e)
+	}
+}
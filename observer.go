@@ -0,0 +1,206 @@
+package cache
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// GetResult is the outcome of a lookup told to an Observer.
+type GetResult int
+
+const (
+	// The key was found and had not expired.
+	GetHit GetResult = iota + 1
+	// The key was not found.
+	GetMiss
+	// The key was found, but its item had expired and not yet been removed.
+	GetExpired
+)
+
+func (r GetResult) String() string {
+	switch r {
+	case GetHit:
+		return "hit"
+	case GetMiss:
+		return "miss"
+	case GetExpired:
+		return "expired"
+	}
+	return "unknown"
+}
+
+// An Observer is told of a cache's operations, to trace or log them. Its
+// methods are called by the goroutine doing the operation, some with locks of
+// the cache held, so they must be quick and must not use the cache. Embed
+// NopObserver to implement only some of them.
+type Observer interface {
+	// OnGet is called for each key looked up. Lookups of several keys at
+	// once report expired items as misses.
+	OnGet(key string, result GetResult)
+	// OnSet is called when an item is added or replaced, with its
+	// expiration time, or the zero time if it never expires.
+	OnSet(key string, expiration time.Time)
+	// OnDelete is called when an item is deleted.
+	OnDelete(key string)
+	// OnEvict is called when an item is removed by the cache, with reason
+	// EventExpire if it had expired and EventEvict if it was removed to
+	// make room for others.
+	OnEvict(key string, reason EventType)
+	// OnLoadStart is called when a value missing from a cache starts to be
+	// loaded, such as by a peer.Group, and OnLoadDone when the load has
+	// finished. OnLoadDone is called with the context returned by
+	// OnLoadStart, which can carry a trace span.
+	OnLoadStart(ctx context.Context, key string) context.Context
+	OnLoadDone(ctx context.Context, key string, err error)
+	// OnSweep is called after the janitor has swept the cache for expired
+	// items, with the number it removed and the time it took.
+	OnSweep(removed int, d time.Duration)
+}
+
+// NopObserver is an Observer that does nothing.
+type NopObserver struct{}
+
+func (NopObserver) OnGet(string, GetResult)                                   {}
+func (NopObserver) OnSet(string, time.Time)                                   {}
+func (NopObserver) OnDelete(string)                                           {}
+func (NopObserver) OnEvict(string, EventType)                                 {}
+func (NopObserver) OnLoadStart(ctx context.Context, _ string) context.Context { return ctx }
+func (NopObserver) OnLoadDone(context.Context, string, error)                 {}
+func (NopObserver) OnSweep(int, time.Duration)                                {}
+
+// observing holds the Observer of a cache, if it has one. The Observer is
+// told of changes as a subscriber of the cache's events, and of lookups and
+// sweeps directly.
+type observing struct {
+	mu  sync.Mutex
+	sub atomic.Pointer[observerSub]
+}
+
+// observerSub is a subscriber that tells an Observer of events.
+type observerSub struct {
+	o Observer
+}
+
+func (s *observerSub) notify(ev Event) {
+	switch ev.Type {
+	case EventSet:
+		var e time.Time
+		if ev.Expiration > 0 {
+			e = time.Unix(0, ev.Expiration)
+		}
+		s.o.OnSet(ev.Key, e)
+	case EventDelete:
+		s.o.OnDelete(ev.Key)
+	case EventExpire, EventEvict:
+		s.o.OnEvict(ev.Key, ev.Type)
+	}
+}
+
+// set replaces the Observer subscribed to h with o, or removes it if o is
+// nil.
+func (ob *observing) set(h *eventHub, o Observer) {
+	ob.mu.Lock()
+	defer ob.mu.Unlock()
+	if old := ob.sub.Load(); old != nil {
+		h.unsubscribe(old)
+	}
+	if o == nil {
+		ob.sub.Store(nil)
+		return
+	}
+	s := &observerSub{o}
+	ob.sub.Store(s)
+	h.subscribe(s)
+}
+
+func (ob *observing) get(k string, r GetResult) {
+	if s := ob.sub.Load(); s != nil {
+		s.o.OnGet(k, r)
+	}
+}
+
+// getMulti tells the Observer of a lookup of keys that found values.
+func (ob *observing) getMulti(keys []string, values map[string]interface{}) {
+	s := ob.sub.Load()
+	if s == nil {
+		return
+	}
+	for _, k := range keys {
+		if _, found := values[k]; found {
+			s.o.OnGet(k, GetHit)
+		} else {
+			s.o.OnGet(k, GetMiss)
+		}
+	}
+}
+
+// sweep tells the Observer of a sweep that started at start and removed
+// removed items.
+func (ob *observing) sweep(start time.Time, removed int) {
+	if s := ob.sub.Load(); s != nil {
+		s.o.OnSweep(removed, time.Since(start))
+	}
+}
+
+// SlogObserver is an Observer that logs each operation to Logger, or to
+// slog.Default() if Logger is nil, at Level.
+type SlogObserver struct {
+	Logger *slog.Logger
+	Level  slog.Level
+}
+
+func (o SlogObserver) log(ctx context.Context, msg string, attrs ...slog.Attr) {
+	l := o.Logger
+	if l == nil {
+		l = slog.Default()
+	}
+	if l.Enabled(ctx, o.Level) {
+		l.LogAttrs(ctx, o.Level, msg, attrs...)
+	}
+}
+
+func (o SlogObserver) OnGet(key string, result GetResult) {
+	o.log(context.Background(), "cache get", slog.String("key", key), slog.String("result", result.String()))
+}
+
+func (o SlogObserver) OnSet(key string, expiration time.Time) {
+	if expiration.IsZero() {
+		o.log(context.Background(), "cache set", slog.String("key", key))
+		return
+	}
+	o.log(context.Background(), "cache set", slog.String("key", key), slog.Time("expiration", expiration))
+}
+
+func (o SlogObserver) OnDelete(key string) {
+	o.log(context.Background(), "cache delete", slog.String("key", key))
+}
+
+func (o SlogObserver) OnEvict(key string, reason EventType) {
+	o.log(context.Background(), "cache evict", slog.String("key", key), slog.String("reason", reason.String()))
+}
+
+// loadStartKey is the context key of the time a load started.
+type loadStartKey struct{}
+
+func (o SlogObserver) OnLoadStart(ctx context.Context, key string) context.Context {
+	o.log(ctx, "cache load start", slog.String("key", key))
+	return context.WithValue(ctx, loadStartKey{}, time.Now())
+}
+
+func (o SlogObserver) OnLoadDone(ctx context.Context, key string, err error) {
+	attrs := []slog.Attr{slog.String("key", key)}
+	if start, ok := ctx.Value(loadStartKey{}).(time.Time); ok {
+		attrs = append(attrs, slog.Duration("duration", time.Since(start)))
+	}
+	if err != nil {
+		attrs = append(attrs, slog.Any("error", err))
+	}
+	o.log(ctx, "cache load done", attrs...)
+}
+
+func (o SlogObserver) OnSweep(removed int, d time.Duration) {
+	o.log(context.Background(), "cache sweep", slog.Int("removed", removed), slog.Duration("duration", d))
+}
@@ -0,0 +1,150 @@
+package cache
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recorder is an Observer that records what it is told.
+type recorder struct {
+	NopObserver
+	mu    sync.Mutex
+	calls []string
+}
+
+func (r *recorder) record(format string, args ...interface{}) {
+	r.mu.Lock()
+	r.calls = append(r.calls, fmt.Sprintf(format, args...))
+	r.mu.Unlock()
+}
+
+func (r *recorder) OnGet(key string, result GetResult) { r.record("get %s %s", key, result) }
+func (r *recorder) OnSet(key string, e time.Time)      { r.record("set %s %t", key, e.IsZero()) }
+func (r *recorder) OnDelete(key string)                { r.record("delete %s", key) }
+func (r *recorder) OnEvict(key string, reason EventType) {
+	r.record("evict %s %s", key, reason)
+}
+
+func (r *recorder) OnSweep(removed int, d time.Duration) { r.record("sweep %d", removed) }
+
+// take returns the recorded calls and forgets them.
+func (r *recorder) take() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	calls := r.calls
+	r.calls = nil
+	return calls
+}
+
+func checkCalls(t *testing.T, r *recorder, want ...string) {
+	t.Helper()
+	if got := r.take(); strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("the Observer was told:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestObserver(t *testing.T) {
+	tc := New(NoExpiration, 0, NewConcurrentMap())
+	r := &recorder{}
+	tc.SetObserver(r)
+
+	tc.Set("a", 1, NoExpiration)
+	tc.Set("b", 2, time.Nanosecond)
+	tc.Get("a")
+	tc.Get("b")
+	tc.Get("c")
+	tc.GetMulti([]string{"a", "c"})
+	tc.Delete("a")
+	tc.Delete("c")
+	tc.DeleteExpired()
+	checkCalls(t, r,
+		"set a true", "set b false",
+		"get a hit", "get b expired", "get c miss",
+		"get a hit", "get c miss",
+		"delete a",
+		"evict b expire")
+
+	tc.SetObserver(nil)
+	tc.Set("a", 1, NoExpiration)
+	tc.Get("a")
+	checkCalls(t, r)
+}
+
+func TestObserverSweeps(t *testing.T) {
+	tc := New(NoExpiration, time.Millisecond, NewConcurrentMap())
+	defer tc.Close()
+	r := &recorder{}
+	tc.SetObserver(r)
+	tc.Set("a", 1, time.Nanosecond)
+	waitFor(t, "a sweep to remove the item", func() bool { return tc.ItemCount() == 0 })
+	waitFor(t, "the sweep to be observed", func() bool {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		for _, c := range r.calls {
+			if c == "sweep 1" {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+func TestLRUCache_Observer(t *testing.T) {
+	c := NewLRUCache(1, NoExpiration, time.Minute)
+	defer c.Close()
+	r := &recorder{}
+	c.SetObserver(r)
+	c.Set("a", 1, NoExpiration)
+	c.Set("b", 2, time.Nanosecond)
+	c.Get("a")
+	c.Get("b")
+	c.GetWithExpiration("b")
+	c.Delete("b")
+	checkCalls(t, r,
+		"set a true", "set b false", "evict a evict",
+		"get a miss", "get b expired", "get b expired",
+		"delete b")
+}
+
+func TestSlogObserver(t *testing.T) {
+	var buf bytes.Buffer
+	o := SlogObserver{Logger: slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{
+		ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+			switch a.Key {
+			case slog.TimeKey, "duration":
+				return slog.Attr{}
+			}
+			return a
+		},
+	})), Level: slog.LevelInfo}
+	o.OnGet("a", GetHit)
+	o.OnSet("a", time.Time{})
+	o.OnEvict("a", EventEvict)
+	ctx := o.OnLoadStart(context.Background(), "b")
+	o.OnLoadDone(ctx, "b", errors.New("failed"))
+	o.OnSweep(3, time.Second)
+	want := `level=INFO msg="cache get" key=a result=hit
+level=INFO msg="cache set" key=a
+level=INFO msg="cache evict" key=a reason=evict
+level=INFO msg="cache load start" key=b
+level=INFO msg="cache load done" key=b error=failed
+level=INFO msg="cache sweep" removed=3
+`
+	if buf.String() != want {
+		t.Errorf("SlogObserver logged:\n%s\nwant:\n%s", buf.String(), want)
+	}
+
+	buf.Reset()
+	o.Level = slog.LevelDebug
+	o.OnDelete("a")
+	if buf.Len() != 0 {
+		t.Error("SlogObserver logged below the handler's level:", buf.String())
+	}
+}
@@ -0,0 +1,232 @@
+package peer
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"sync/atomic"
+	"time"
+
+	cache "github.com/wyyadd/go-cache"
+)
+
+// GroupOptions configure a Group.
+type GroupOptions struct {
+	// How long the values a peer owns are cached after they're loaded. Zero
+	// means they never expire.
+	TTL time.Duration
+	// How long values mirrored from other peers are cached. The default is
+	// a minute, or TTL if that is shorter.
+	HotTTL time.Duration
+	// The fraction of values fetched from other peers that are mirrored.
+	// Zero means 1 in 10, and a negative fraction means none are.
+	HotFraction float64
+	// How often expired values are deleted. The default is a minute.
+	CleanupInterval time.Duration
+	// If not nil, Observer is set as the Observer of the caches holding
+	// the group's values, and told of the loads made with the Getter.
+	Observer cache.Observer
+}
+
+// GroupStats are counters of a Group's activity.
+type GroupStats struct {
+	// Calls of Get, and those served by values cached by their owner and
+	// by mirrored values.
+	Gets    uint64
+	Hits    uint64
+	HotHits uint64
+	// Values fetched from other peers, and failed fetches, after which the
+	// values are loaded locally.
+	PeerLoads  uint64
+	PeerErrors uint64
+	// Values loaded by the Getter, and gets and requests that waited for a
+	// load or fetch of the same key to finish instead of starting another.
+	Loads       uint64
+	SharedLoads uint64
+	// Requests for values from other peers.
+	ServerRequests uint64
+}
+
+// A Group is a cache of one kind of value, shared among peers.
+type Group struct {
+	name   string
+	getter Getter
+	picker PeerPicker
+	opts   GroupOptions
+	// main holds the values this peer owns, and hot those mirrored from
+	// other peers.
+	main, hot *cache.Cache
+	// unregister, if not nil, removes the group from its HTTPPool.
+	unregister func()
+	loads      flightGroup
+	fetches    flightGroup
+	gets       atomic.Uint64
+	hits       atomic.Uint64
+	hotHits    atomic.Uint64
+	peerLoads  atomic.Uint64
+	peerErrors atomic.Uint64
+	localLoads atomic.Uint64
+	shared     atomic.Uint64
+	requests   atomic.Uint64
+}
+
+// NewGroup returns a Group named name that loads values with getter and
+// fetches the values of keys owned by other peers from the peers picked by
+// picker. If picker is nil, this peer owns every key. Peers must give groups
+// of the same values the same name. Groups served over HTTP are created with
+// HTTPPool.NewGroup instead.
+func NewGroup(name string, getter Getter, picker PeerPicker, opts GroupOptions) *Group {
+	if opts.HotTTL <= 0 {
+		opts.HotTTL = time.Minute
+		if opts.TTL > 0 {
+			opts.HotTTL = min(opts.HotTTL, opts.TTL)
+		}
+	}
+	if opts.HotFraction == 0 {
+		opts.HotFraction = 0.1
+	}
+	if opts.CleanupInterval <= 0 {
+		opts.CleanupInterval = time.Minute
+	}
+	ttl := opts.TTL
+	if ttl <= 0 {
+		ttl = cache.NoExpiration
+	}
+	g := &Group{
+		name:   name,
+		getter: getter,
+		picker: picker,
+		opts:   opts,
+		main:   cache.New(ttl, opts.CleanupInterval, cache.NewConcurrentMap()),
+		hot:    cache.New(opts.HotTTL, opts.CleanupInterval, cache.NewConcurrentMap()),
+	}
+	if opts.Observer != nil {
+		g.main.SetObserver(opts.Observer)
+		g.hot.SetObserver(opts.Observer)
+	}
+	return g
+}
+
+// Name returns the group's name.
+func (g *Group) Name() string {
+	return g.name
+}
+
+// Close stops the janitors of the caches holding the group's values and, if
+// the group was created by an HTTPPool, removes it from the pool, so that
+// peers can no longer fetch its values and its name can be used again. The
+// group should not be used afterwards.
+func (g *Group) Close() error {
+	if g.unregister != nil {
+		g.unregister()
+	}
+	g.hot.Close()
+	return g.main.Close()
+}
+
+// Get returns the value of key: from this peer's cache if it is there, and
+// otherwise from the peer that owns the key, or from the Getter if this peer
+// does. If the owner can't be reached, the value is loaded by this peer.
+//
+// Concurrent gets of a key share one load, which uses the context of the
+// get that started it. The returned slice is shared and must not be
+// modified.
+func (g *Group) Get(ctx context.Context, key string) ([]byte, error) {
+	g.gets.Add(1)
+	if v, ok := g.main.Get(key); ok {
+		g.hits.Add(1)
+		return v.([]byte), nil
+	}
+	if v, ok := g.hot.Get(key); ok {
+		g.hotHits.Add(1)
+		return v.([]byte), nil
+	}
+	if g.picker != nil {
+		if p, ok := g.picker.PickPeer(key); ok {
+			return g.fetch(ctx, p, key)
+		}
+	}
+	return g.load(ctx, key)
+}
+
+// fetch returns the value of key from its owner, p.
+func (g *Group) fetch(ctx context.Context, p Peer, key string) ([]byte, error) {
+	v, err, shared := g.fetches.Do(key, func() ([]byte, error) {
+		v, err := p.Fetch(ctx, g.name, key)
+		if err == nil {
+			g.peerLoads.Add(1)
+			if rand.Float64() < g.opts.HotFraction {
+				g.hot.Set(key, v, cache.DefaultExpiration)
+			}
+			return v, nil
+		}
+		if errors.Is(err, ErrNotFound) || ctx.Err() != nil {
+			return nil, err
+		}
+		g.peerErrors.Add(1)
+		return g.load(ctx, key)
+	})
+	if shared {
+		g.shared.Add(1)
+	}
+	return v, err
+}
+
+// load returns the value of a key this peer owns, loading it with the Getter
+// if it isn't cached.
+func (g *Group) load(ctx context.Context, key string) ([]byte, error) {
+	v, err, shared := g.loads.Do(key, func() ([]byte, error) {
+		// The value may have been cached by a load that finished after
+		// the caller looked for it.
+		if v, ok := g.main.Get(key); ok {
+			return v.([]byte), nil
+		}
+		v, err := g.getLocally(ctx, key)
+		if err != nil {
+			return nil, err
+		}
+		g.localLoads.Add(1)
+		g.main.Set(key, v, cache.DefaultExpiration)
+		return v, nil
+	})
+	if shared {
+		g.shared.Add(1)
+	}
+	return v, err
+}
+
+// getLocally loads the value of key with the Getter, telling the Observer.
+func (g *Group) getLocally(ctx context.Context, key string) ([]byte, error) {
+	if g.opts.Observer == nil {
+		return g.getter.Get(ctx, key)
+	}
+	ctx = g.opts.Observer.OnLoadStart(ctx, key)
+	v, err := g.getter.Get(ctx, key)
+	g.opts.Observer.OnLoadDone(ctx, key, err)
+	return v, err
+}
+
+// serve returns the value of key for another peer, which has picked this
+// peer as its owner.
+func (g *Group) serve(ctx context.Context, key string) ([]byte, error) {
+	g.requests.Add(1)
+	if v, ok := g.main.Get(key); ok {
+		g.hits.Add(1)
+		return v.([]byte), nil
+	}
+	return g.load(ctx, key)
+}
+
+// Stats returns the group's counters.
+func (g *Group) Stats() GroupStats {
+	return GroupStats{
+		Gets:           g.gets.Load(),
+		Hits:           g.hits.Load(),
+		HotHits:        g.hotHits.Load(),
+		PeerLoads:      g.peerLoads.Load(),
+		PeerErrors:     g.peerErrors.Load(),
+		Loads:          g.localLoads.Load(),
+		SharedLoads:    g.shared.Load(),
+		ServerRequests: g.requests.Load(),
+	}
+}
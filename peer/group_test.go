@@ -0,0 +1,133 @@
+package peer
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	cache "github.com/wyyadd/go-cache"
+)
+
+func TestGroup(t *testing.T) {
+	var loads atomic.Int32
+	g := NewGroup("test", GetterFunc(func(ctx context.Context, key string) ([]byte, error) {
+		loads.Add(1)
+		if key == "missing" {
+			return nil, ErrNotFound
+		}
+		return []byte("value of " + key), nil
+	}), nil, GroupOptions{TTL: 20 * time.Millisecond})
+	defer g.Close()
+
+	ctx := context.Background()
+	for i := 0; i < 3; i++ {
+		v, err := g.Get(ctx, "foo")
+		if err != nil || string(v) != "value of foo" {
+			t.Fatalf("Get returned %q, %v", v, err)
+		}
+	}
+	if loads.Load() != 1 {
+		t.Error("the value was loaded", loads.Load(), "times, want once")
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := g.Get(ctx, "missing"); !errors.Is(err, ErrNotFound) {
+			t.Error("Get of a missing key returned", err)
+		}
+	}
+	if loads.Load() != 3 {
+		t.Error("ErrNotFound was cached")
+	}
+	<-time.After(30 * time.Millisecond)
+	g.Get(ctx, "foo")
+	if loads.Load() != 4 {
+		t.Error("the value was not loaded again after it expired")
+	}
+	st := g.Stats()
+	if st.Gets != 6 || st.Hits != 2 || st.Loads != 2 || st.PeerLoads != 0 {
+		t.Errorf("Stats are %+v", st)
+	}
+}
+
+func TestGroupSharesLoads(t *testing.T) {
+	var loads atomic.Int32
+	release := make(chan struct{})
+	g := NewGroup("test", GetterFunc(func(ctx context.Context, key string) ([]byte, error) {
+		loads.Add(1)
+		<-release
+		return []byte("v"), nil
+	}), nil, GroupOptions{})
+	defer g.Close()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if v, err := g.Get(context.Background(), "key"); err != nil || string(v) != "v" {
+				t.Errorf("Get returned %q, %v", v, err)
+			}
+		}()
+	}
+	<-time.After(10 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	if loads.Load() != 1 {
+		t.Error("concurrent gets loaded the value", loads.Load(), "times, want once")
+	}
+	if st := g.Stats(); st.SharedLoads+st.Hits != 9 {
+		t.Errorf("Stats are %+v, want 9 shared loads or hits", st)
+	}
+}
+
+// loadObserver counts the loads it is told of.
+type loadObserver struct {
+	cache.NopObserver
+	mu     sync.Mutex
+	starts []string
+	errs   []error
+}
+
+type spanKey struct{}
+
+func (o *loadObserver) OnLoadStart(ctx context.Context, key string) context.Context {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.starts = append(o.starts, key)
+	return context.WithValue(ctx, spanKey{}, key)
+}
+
+func (o *loadObserver) OnLoadDone(ctx context.Context, key string, err error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if ctx.Value(spanKey{}) != key {
+		err = errors.New("OnLoadDone was not passed the context returned by OnLoadStart")
+	}
+	o.errs = append(o.errs, err)
+}
+
+func TestGroupObserver(t *testing.T) {
+	o := &loadObserver{}
+	g := NewGroup("test", GetterFunc(func(ctx context.Context, key string) ([]byte, error) {
+		if ctx.Value(spanKey{}) != key {
+			t.Error("the Getter was not passed the context returned by OnLoadStart")
+		}
+		if key == "missing" {
+			return nil, ErrNotFound
+		}
+		return []byte(key), nil
+	}), nil, GroupOptions{Observer: o})
+	defer g.Close()
+	ctx := context.Background()
+	g.Get(ctx, "foo")
+	g.Get(ctx, "foo")
+	g.Get(ctx, "missing")
+	if len(o.starts) != 2 || o.starts[0] != "foo" || o.starts[1] != "missing" {
+		t.Error("the Observer was told of loads of", o.starts)
+	}
+	if len(o.errs) != 2 || o.errs[0] != nil || !errors.Is(o.errs[1], ErrNotFound) {
+		t.Error("the Observer was told loads finished with", o.errs)
+	}
+}
@@ -0,0 +1,192 @@
+package peer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+const (
+	defaultBasePath = "/_cache/"
+	defaultReplicas = 50
+	// The most of an error response that is read into an error.
+	maxErrorBody = 512
+	// The header ServeHTTP marks a 404 with when a key has no value, to tell
+	// it apart from a 404 for a path that isn't served, like a wrong
+	// BasePath.
+	notFoundHeader = "X-Cache-Not-Found"
+)
+
+// HTTPPoolOptions configure an HTTPPool.
+type HTTPPoolOptions struct {
+	// The path values are served under. The default is "/_cache/".
+	BasePath string
+	// The number of virtual nodes each peer is placed at on the ring of
+	// hashes that keys are assigned to peers by. The default is 50.
+	Replicas int
+	// Hashes keys and virtual nodes. The default is CRC-32.
+	Hash func(data []byte) uint32
+	// Fetches values from peers. The default is http.DefaultClient.
+	Client *http.Client
+}
+
+// An HTTPPool is a PeerPicker for a set of peers that serve their values over
+// HTTP, and an http.Handler that serves this peer's values to them.
+//
+// Values are served at BasePath followed by a group's name and a key, each
+// escaped as a path segment, and are fetched from a peer by appending the
+// same path to its URL.
+type HTTPPool struct {
+	self   string
+	opts   HTTPPoolOptions
+	mu     sync.RWMutex
+	ring   *Ring
+	peers  map[string]*httpPeer
+	groups map[string]*Group
+}
+
+// NewHTTPPool returns an HTTPPool for the peer at self, a base URL such as
+// "http://10.0.0.1:8000". The pool picks no peers until Set is called.
+func NewHTTPPool(self string, opts HTTPPoolOptions) *HTTPPool {
+	if opts.BasePath == "" {
+		opts.BasePath = defaultBasePath
+	}
+	if !strings.HasSuffix(opts.BasePath, "/") {
+		opts.BasePath += "/"
+	}
+	if opts.Replicas <= 0 {
+		opts.Replicas = defaultReplicas
+	}
+	if opts.Client == nil {
+		opts.Client = http.DefaultClient
+	}
+	return &HTTPPool{self: self, opts: opts, groups: map[string]*Group{}}
+}
+
+// Set replaces the pool's peers with peers, base URLs in the same form as
+// self, which should be one of them. Every peer should be given the same
+// set.
+func (p *HTTPPool) Set(peers ...string) {
+	ring := NewRing(p.opts.Replicas, p.opts.Hash)
+	ring.Add(peers...)
+	m := make(map[string]*httpPeer, len(peers))
+	for _, peer := range peers {
+		m[peer] = &httpPeer{base: strings.TrimSuffix(peer, "/") + p.opts.BasePath, client: p.opts.Client}
+	}
+	p.mu.Lock()
+	p.ring, p.peers = ring, m
+	p.mu.Unlock()
+}
+
+// PickPeer returns the peer that owns key, or false if it is owned by this
+// peer or the pool has no peers.
+func (p *HTTPPool) PickPeer(key string) (Peer, bool) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if p.ring == nil {
+		return nil, false
+	}
+	owner := p.ring.Owner(key)
+	if owner == "" || owner == p.self {
+		return nil, false
+	}
+	return p.peers[owner], true
+}
+
+// NewGroup returns a new Group named name that fetches values from the
+// pool's peers, and serves its values to them. It panics if the pool already
+// has a group of that name.
+func (p *HTTPPool) NewGroup(name string, getter Getter, opts GroupOptions) *Group {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if _, ok := p.groups[name]; ok {
+		panic("peer: duplicate group " + name)
+	}
+	g := NewGroup(name, getter, p, opts)
+	g.unregister = func() {
+		p.mu.Lock()
+		if p.groups[name] == g {
+			delete(p.groups, name)
+		}
+		p.mu.Unlock()
+	}
+	p.groups[name] = g
+	return g
+}
+
+// ServeHTTP serves the values of the pool's groups to other peers.
+func (p *HTTPPool) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path, ok := strings.CutPrefix(r.URL.EscapedPath(), p.opts.BasePath)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	escapedName, escapedKey, ok := strings.Cut(path, "/")
+	name, err1 := url.PathUnescape(escapedName)
+	key, err2 := url.PathUnescape(escapedKey)
+	if !ok || err1 != nil || err2 != nil {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	p.mu.RLock()
+	g := p.groups[name]
+	p.mu.RUnlock()
+	if g == nil {
+		http.Error(w, "no such group: "+name, http.StatusBadRequest)
+		return
+	}
+	v, err := g.serve(r.Context(), key)
+	switch {
+	case errors.Is(err, ErrNotFound):
+		w.Header().Set(notFoundHeader, "1")
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	default:
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Write(v)
+	}
+}
+
+// An httpPeer is a peer whose values are served at base.
+type httpPeer struct {
+	base   string
+	client *http.Client
+}
+
+func (h *httpPeer) Fetch(ctx context.Context, group, key string) ([]byte, error) {
+	u := h.base + url.PathEscape(group) + "/" + url.PathEscape(key)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+		v, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return nil, fmt.Errorf("peer: reading %s: %w", u, err)
+		}
+		return v, nil
+	case http.StatusNotFound:
+		if resp.Header.Get(notFoundHeader) != "" {
+			return nil, ErrNotFound
+		}
+	}
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBody))
+	return nil, fmt.Errorf("peer: fetching %s: %s: %s", u, resp.Status, strings.TrimSpace(string(msg)))
+}
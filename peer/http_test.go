@@ -0,0 +1,229 @@
+package peer
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+// testPeers runs n peers as httptest servers, each with a group named
+// "test" whose getter records which peer loaded each key.
+type testPeers struct {
+	servers []*httptest.Server
+	pools   []*HTTPPool
+	groups  []*Group
+	mu      sync.Mutex
+	loaded  map[string][]int
+}
+
+func startPeers(t *testing.T, n int, opts GroupOptions) *testPeers {
+	t.Helper()
+	tp := &testPeers{loaded: map[string][]int{}}
+	urls := make([]string, n)
+	for i := 0; i < n; i++ {
+		i := i
+		s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			tp.pools[i].ServeHTTP(w, r)
+		}))
+		t.Cleanup(s.Close)
+		tp.servers = append(tp.servers, s)
+		urls[i] = s.URL
+	}
+	for i := 0; i < n; i++ {
+		i := i
+		pool := NewHTTPPool(urls[i], HTTPPoolOptions{})
+		pool.Set(urls...)
+		tp.pools = append(tp.pools, pool)
+		tp.groups = append(tp.groups, pool.NewGroup("test", GetterFunc(func(ctx context.Context, key string) ([]byte, error) {
+			if key == "missing" {
+				return nil, ErrNotFound
+			}
+			if key == "broken" {
+				return nil, errors.New("broken getter")
+			}
+			tp.mu.Lock()
+			tp.loaded[key] = append(tp.loaded[key], i)
+			tp.mu.Unlock()
+			return []byte("value of " + key), nil
+		}), opts))
+	}
+	for _, g := range tp.groups {
+		t.Cleanup(func() { g.Close() })
+	}
+	return tp
+}
+
+// owner returns the index of the peer that owns key.
+func (tp *testPeers) owner(key string) int {
+	for i, pool := range tp.pools {
+		if _, ok := pool.PickPeer(key); !ok {
+			return i
+		}
+	}
+	return -1
+}
+
+func TestHTTPPool(t *testing.T) {
+	tp := startPeers(t, 3, GroupOptions{HotFraction: -1})
+	ctx := context.Background()
+	keys := make([]string, 30)
+	for i := range keys {
+		keys[i] = "key/" + strconv.Itoa(i) + " %"
+	}
+	for _, g := range tp.groups {
+		for _, k := range keys {
+			v, err := g.Get(ctx, k)
+			if err != nil || string(v) != "value of "+k {
+				t.Fatalf("Get(%q) returned %q, %v", k, v, err)
+			}
+		}
+	}
+	owners := map[int]int{}
+	for _, k := range keys {
+		owner := tp.owner(k)
+		owners[owner]++
+		if got := tp.loaded[k]; len(got) != 1 || got[0] != owner {
+			t.Errorf("%q was loaded by peers %v, want only its owner %d", k, got, owner)
+		}
+	}
+	if len(owners) != 3 {
+		t.Errorf("keys were owned by %d peers, want 3: %v", len(owners), owners)
+	}
+	var peerLoads, requests uint64
+	for _, g := range tp.groups {
+		st := g.Stats()
+		peerLoads += st.PeerLoads
+		requests += st.ServerRequests
+	}
+	if peerLoads != 60 || requests != 60 {
+		t.Errorf("peers fetched %d values and served %d, want 60 and 60", peerLoads, requests)
+	}
+
+	for _, g := range tp.groups {
+		if _, err := g.Get(ctx, "missing"); !errors.Is(err, ErrNotFound) {
+			t.Error("Get of a missing key returned", err)
+		}
+		if _, err := g.Get(ctx, "broken"); err == nil {
+			t.Error("Get of a key whose getter failed succeeded")
+		}
+	}
+}
+
+func TestHTTPPoolMirrorsHotKeys(t *testing.T) {
+	tp := startPeers(t, 2, GroupOptions{HotFraction: 1})
+	ctx := context.Background()
+	var k string
+	for i := 0; tp.owner(k) != 1; i++ {
+		k = "key" + strconv.Itoa(i)
+	}
+	g := tp.groups[0]
+	for i := 0; i < 5; i++ {
+		if v, err := g.Get(ctx, k); err != nil || string(v) != "value of "+k {
+			t.Fatalf("Get returned %q, %v", v, err)
+		}
+	}
+	if st := g.Stats(); st.PeerLoads != 1 || st.HotHits != 4 {
+		t.Errorf("Stats are %+v, want 1 peer load and 4 hot hits", st)
+	}
+	if st := tp.groups[1].Stats(); st.ServerRequests != 1 {
+		t.Error("the owner served", st.ServerRequests, "requests, want 1")
+	}
+}
+
+func TestHTTPPoolPeerDown(t *testing.T) {
+	tp := startPeers(t, 2, GroupOptions{HotFraction: -1})
+	var k string
+	for i := 0; tp.owner(k) != 1; i++ {
+		k = "key" + strconv.Itoa(i)
+	}
+	tp.servers[1].Close()
+	g := tp.groups[0]
+	if v, err := g.Get(context.Background(), k); err != nil || string(v) != "value of "+k {
+		t.Fatalf("Get returned %q, %v", v, err)
+	}
+	if got := tp.loaded[k]; len(got) != 1 || got[0] != 0 {
+		t.Errorf("%q was loaded by peers %v, want 0", k, got)
+	}
+	if st := g.Stats(); st.PeerErrors != 1 || st.Loads != 1 {
+		t.Errorf("Stats are %+v, want 1 peer error and 1 load", st)
+	}
+}
+
+func TestHTTPPeerNotFound(t *testing.T) {
+	tp := startPeers(t, 1, GroupOptions{})
+	ctx := context.Background()
+	h := &httpPeer{base: tp.servers[0].URL + defaultBasePath, client: http.DefaultClient}
+	if _, err := h.Fetch(ctx, "test", "missing"); !errors.Is(err, ErrNotFound) {
+		t.Error("Fetch of a missing key returned", err)
+	}
+	// A 404 for a path the pool doesn't serve is an error, so the key is
+	// loaded locally instead of being reported missing.
+	h = &httpPeer{base: tp.servers[0].URL + "/elsewhere/", client: http.DefaultClient}
+	if _, err := h.Fetch(ctx, "test", "foo"); err == nil || errors.Is(err, ErrNotFound) {
+		t.Error("Fetch from a wrong base path returned", err)
+	}
+}
+
+func TestHTTPPoolServeHTTP(t *testing.T) {
+	tp := startPeers(t, 1, GroupOptions{})
+	tests := []struct {
+		method, path string
+		status       int
+	}{
+		{"GET", "/_cache/test/foo", http.StatusOK},
+		{"GET", "/_cache/test/a%2Fb", http.StatusOK},
+		{"GET", "/_cache/test/missing", http.StatusNotFound},
+		{"GET", "/_cache/test/broken", http.StatusInternalServerError},
+		{"GET", "/_cache/other/foo", http.StatusBadRequest},
+		{"GET", "/_cache/test", http.StatusBadRequest},
+		{"POST", "/_cache/test/foo", http.StatusMethodNotAllowed},
+		{"GET", "/elsewhere", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		req, _ := http.NewRequest(tt.method, tp.servers[0].URL+tt.path, nil)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != tt.status {
+			t.Errorf("%s %s returned %d, want %d", tt.method, tt.path, resp.StatusCode, tt.status)
+		}
+	}
+	if got := tp.loaded["a/b"]; len(got) != 1 {
+		t.Error("an escaped key was not unescaped")
+	}
+}
+
+func TestHTTPPoolGroupClose(t *testing.T) {
+	tp := startPeers(t, 1, GroupOptions{})
+	getter := GetterFunc(func(ctx context.Context, key string) ([]byte, error) {
+		return []byte(key), nil
+	})
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Error("NewGroup did not panic for a duplicate name")
+			}
+		}()
+		tp.pools[0].NewGroup("test", getter, GroupOptions{})
+	}()
+	tp.groups[0].Close()
+	resp, err := http.Get(tp.servers[0].URL + "/_cache/test/foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Error("a closed group was served with status", resp.StatusCode)
+	}
+	g := tp.pools[0].NewGroup("test", getter, GroupOptions{})
+	defer g.Close()
+	if v, err := g.Get(context.Background(), "foo"); err != nil || string(v) != "foo" {
+		t.Errorf("a group replacing a closed one returned %q, %v", v, err)
+	}
+}
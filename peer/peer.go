@@ -0,0 +1,54 @@
+// Package peer shares a cache among a set of peers, in the style of
+// groupcache. Each key is owned by one peer, chosen by consistent hashing.
+// The owner loads the key's value with a Getter, once however many peers ask
+// for it at the same time, and caches it. Other peers fetch the value from
+// the owner over HTTP, and mirror a fraction of the values they fetch so that
+// hot keys are served locally.
+//
+// A peer runs an HTTPPool, which serves its values to the other peers and
+// picks the owners of keys, and creates a Group with the pool for each kind
+// of value it caches:
+//
+//	pool := peer.NewHTTPPool("http://10.0.0.1:8000", peer.HTTPPoolOptions{})
+//	pool.Set("http://10.0.0.1:8000", "http://10.0.0.2:8000", "http://10.0.0.3:8000")
+//	users := pool.NewGroup("users", peer.GetterFunc(loadUser), peer.GroupOptions{TTL: time.Minute})
+//	go http.ListenAndServe(":8000", pool)
+//	...
+//	data, err := users.Get(ctx, "alice")
+package peer
+
+import (
+	"context"
+	"errors"
+)
+
+// ErrNotFound can be returned by a Getter for a key that has no value. It is
+// passed on to peers that fetch the key, which don't then try to load it
+// themselves.
+var ErrNotFound = errors.New("peer: not found")
+
+// A Getter loads the value of a key that isn't cached.
+type Getter interface {
+	Get(ctx context.Context, key string) ([]byte, error)
+}
+
+// A GetterFunc is a function that implements Getter.
+type GetterFunc func(ctx context.Context, key string) ([]byte, error)
+
+func (f GetterFunc) Get(ctx context.Context, key string) ([]byte, error) {
+	return f(ctx, key)
+}
+
+// A Peer is another peer that values can be fetched from.
+type Peer interface {
+	// Fetch returns the value of key in the named group, loading it if
+	// the peer hasn't cached it.
+	Fetch(ctx context.Context, group, key string) ([]byte, error)
+}
+
+// A PeerPicker picks the peer that owns a key.
+type PeerPicker interface {
+	// PickPeer returns the peer that owns key, or false if the key is owned
+	// by this peer.
+	PickPeer(key string) (Peer, bool)
+}
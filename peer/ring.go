@@ -0,0 +1,68 @@
+package peer
+
+import (
+	"hash/crc32"
+	"slices"
+	"strconv"
+)
+
+// A Ring assigns keys to peers by consistent hashing: each peer is placed at
+// several points, its virtual nodes, on a ring of hashes, and a key belongs
+// to the peer at the first point at or after the key's hash. Adding or
+// removing a peer only moves the keys of the points it takes or gives up.
+//
+// A Ring is not safe for concurrent use while peers are being added.
+type Ring struct {
+	hash     func(data []byte) uint32
+	replicas int
+	points   []uint32
+	owners   map[uint32]string
+}
+
+// NewRing returns an empty Ring that places each peer at replicas virtual
+// nodes, using hash to hash keys and nodes. If hash is nil, CRC-32 is used.
+func NewRing(replicas int, hash func(data []byte) uint32) *Ring {
+	if hash == nil {
+		hash = crc32.ChecksumIEEE
+	}
+	return &Ring{hash: hash, replicas: max(replicas, 1), owners: map[uint32]string{}}
+}
+
+// Add adds peers to the ring.
+func (r *Ring) Add(peers ...string) {
+	for _, p := range peers {
+		for i := 0; i < r.replicas; i++ {
+			h := r.hash([]byte(strconv.Itoa(i) + p))
+			// When virtual nodes collide, the peer with the lesser name
+			// takes the point, so the ring doesn't depend on the order
+			// peers were added in.
+			if owner, ok := r.owners[h]; ok {
+				if p < owner {
+					r.owners[h] = p
+				}
+				continue
+			}
+			r.points = append(r.points, h)
+			r.owners[h] = p
+		}
+	}
+	slices.Sort(r.points)
+}
+
+// Len returns the number of virtual nodes on the ring.
+func (r *Ring) Len() int {
+	return len(r.points)
+}
+
+// Owner returns the peer that owns key, or "" if the ring is empty.
+func (r *Ring) Owner(key string) string {
+	if len(r.points) == 0 {
+		return ""
+	}
+	h := r.hash([]byte(key))
+	i, _ := slices.BinarySearch(r.points, h)
+	if i == len(r.points) {
+		i = 0
+	}
+	return r.owners[r.points[i]]
+}
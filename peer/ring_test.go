@@ -0,0 +1,70 @@
+package peer
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestRing(t *testing.T) {
+	// Hash numbers to themselves, so the ring's points are known: peers
+	// "2", "4" and "6" with 3 replicas are at 2, 4, 6, 12, 14, 16, 22, 24
+	// and 26.
+	hash := func(data []byte) uint32 {
+		n, err := strconv.Atoi(string(data))
+		if err != nil {
+			panic(err)
+		}
+		return uint32(n)
+	}
+	r := NewRing(3, hash)
+	if got := r.Owner("1"); got != "" {
+		t.Errorf("an empty ring returned owner %q", got)
+	}
+	r.Add("6", "4", "2")
+	if r.Len() != 9 {
+		t.Error("ring has", r.Len(), "points, want 9")
+	}
+	tests := map[string]string{"2": "2", "11": "2", "23": "4", "27": "2", "15": "6"}
+	for k, want := range tests {
+		if got := r.Owner(k); got != want {
+			t.Errorf("Owner(%s) = %s, want %s", k, got, want)
+		}
+	}
+	r.Add("8")
+	tests["27"] = "8"
+	for k, want := range tests {
+		if got := r.Owner(k); got != want {
+			t.Errorf("after adding 8, Owner(%s) = %s, want %s", k, got, want)
+		}
+	}
+}
+
+func TestRingConsistency(t *testing.T) {
+	peers := []string{"http://a", "http://b", "http://c"}
+	r1, r2 := NewRing(50, nil), NewRing(50, nil)
+	r1.Add(peers...)
+	r2.Add(peers[2], peers[0], peers[1])
+
+	owned := map[string]int{}
+	for i := 0; i < 3000; i++ {
+		k := "key" + strconv.Itoa(i)
+		if r1.Owner(k) != r2.Owner(k) {
+			t.Fatalf("rings with peers added in different orders disagree about %s", k)
+		}
+		owned[r1.Owner(k)]++
+	}
+	for _, p := range peers {
+		if owned[p] < 500 {
+			t.Errorf("%s owns only %d of 3000 keys", p, owned[p])
+		}
+	}
+
+	r3 := NewRing(50, nil)
+	r3.Add(append(peers, "http://d")...)
+	for i := 0; i < 3000; i++ {
+		k := "key" + strconv.Itoa(i)
+		if owner := r3.Owner(k); owner != r1.Owner(k) && owner != "http://d" {
+			t.Errorf("adding a peer moved %s from %s to %s", k, r1.Owner(k), owner)
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package peer
+
+import (
+	"fmt"
+	"sync"
+)
+
+// A call is a load that is in progress or has finished.
+type call struct {
+	done chan struct{}
+	val  []byte
+	err  error
+}
+
+// A flightGroup runs a function once for each key at a time, so concurrent
+// loads of the same key share one result.
+type flightGroup struct {
+	mu    sync.Mutex
+	calls map[string]*call
+}
+
+// Do runs fn and returns its result, unless a call of fn for key is already
+// running, in which case it waits for that call and returns its result.
+// shared reports whether the result was shared with other callers. If fn
+// panics, Do panics with the same value and the callers waiting for it get an
+// error instead.
+func (g *flightGroup) Do(key string, fn func() ([]byte, error)) (val []byte, err error, shared bool) {
+	g.mu.Lock()
+	if c, ok := g.calls[key]; ok {
+		g.mu.Unlock()
+		<-c.done
+		return c.val, c.err, true
+	}
+	if g.calls == nil {
+		g.calls = map[string]*call{}
+	}
+	c := &call{done: make(chan struct{})}
+	g.calls[key] = c
+	g.mu.Unlock()
+
+	normalReturn := false
+	defer func() {
+		if !normalReturn {
+			// fn panicked or called runtime.Goexit. Let the panic carry on
+			// once the waiters have been released.
+			c.val, c.err = nil, fmt.Errorf("peer: load of %q did not return", key)
+			if r := recover(); r != nil {
+				c.err = fmt.Errorf("peer: load of %q panicked: %v", key, r)
+				defer panic(r)
+			}
+		}
+		g.mu.Lock()
+		delete(g.calls, key)
+		g.mu.Unlock()
+		close(c.done)
+	}()
+	c.val, c.err = fn()
+	normalReturn = true
+	return c.val, c.err, false
+}
@@ -0,0 +1,85 @@
+package peer
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestFlightGroup(t *testing.T) {
+	var g flightGroup
+	var calls, shared atomic.Int32
+	release := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			v, err, s := g.Do("key", func() ([]byte, error) {
+				calls.Add(1)
+				<-release
+				return []byte("value"), nil
+			})
+			if err != nil || string(v) != "value" {
+				t.Errorf("Do returned %q, %v", v, err)
+			}
+			if s {
+				shared.Add(1)
+			}
+		}()
+	}
+	// Wait for the first call to start and the others to join it.
+	for calls.Load() == 0 {
+		<-time.After(time.Millisecond)
+	}
+	<-time.After(10 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	if calls.Load() != 1 {
+		t.Error("fn was called", calls.Load(), "times, want 1")
+	}
+	if shared.Load() == 0 {
+		t.Error("no result was shared")
+	}
+
+	errTest := errors.New("test")
+	if _, err, _ := g.Do("key", func() ([]byte, error) { return nil, errTest }); err != errTest {
+		t.Error("Do after the first call finished returned", err)
+	}
+}
+
+func TestFlightGroupPanic(t *testing.T) {
+	var g flightGroup
+	started, release := make(chan struct{}), make(chan struct{})
+	waited := make(chan error, 1)
+	go func() {
+		<-started
+		go func() {
+			_, err, _ := g.Do("key", func() ([]byte, error) { return []byte("wrong"), nil })
+			waited <- err
+		}()
+		// Give the second call time to join the first.
+		<-time.After(10 * time.Millisecond)
+		close(release)
+	}()
+	func() {
+		defer func() {
+			if r := recover(); r != "boom" {
+				t.Errorf("Do panicked with %v, want boom", r)
+			}
+		}()
+		g.Do("key", func() ([]byte, error) {
+			close(started)
+			<-release
+			panic("boom")
+		})
+	}()
+	if err := <-waited; err == nil {
+		t.Error("a caller waiting for a call that panicked got no error")
+	}
+	if v, err, _ := g.Do("key", func() ([]byte, error) { return []byte("value"), nil }); err != nil || string(v) != "value" {
+		t.Errorf("Do after a call panicked returned %q, %v", v, err)
+	}
+}
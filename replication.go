@@ -0,0 +1,260 @@
+package cache
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/gob"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// ErrPrimaryClosed is returned by Primary.Serve after Close has been called.
+var ErrPrimaryClosed = errors.New("cache: primary closed")
+
+// replHello is sent by a replica when it connects, naming the replication
+// stream it last followed and the offset it reached in it.
+type replHello struct {
+	ReplID string
+	Offset uint64
+}
+
+// replMessage is sent by a primary. The first message on a connection
+// answers the replica's replHello: either a full sync, whose record is a
+// snapshot of the cache as of Offset, or the continuation of the stream from
+// the replica's offset, without a record. Every later message is a change
+// with its offset.
+type replMessage struct {
+	ReplID string
+	Offset uint64
+	Full   bool
+	Record *aofRecord
+}
+
+// Primary streams the contents of a Cache, and every change made to it, to
+// replicas. A Primary numbers the changes it streams; a replica that
+// reconnects resumes from the number it reached as long as the Primary still
+// holds the changes it missed, and is otherwise sent a full copy of the
+// cache again.
+//
+// Values are encoded with Gob, so their types must be registered with
+// gob.Register in the replicas' processes.
+type Primary struct {
+	c   *cache
+	id  string
+	log *EventLog
+
+	mu     sync.Mutex
+	open   map[io.Closer]struct{}
+	closed bool
+}
+
+// NewPrimary returns a Primary for c that keeps the most recent backlog
+// changes for replicas that reconnect. Replicas that fall further behind
+// than that need a full sync. Streaming makes the cache serialize writes to
+// keys that share a lock until the Primary is closed.
+func NewPrimary(c *Cache, backlog int) *Primary {
+	var id [20]byte
+	rand.Read(id[:])
+	return &Primary{
+		c:    c.cache,
+		id:   hex.EncodeToString(id[:]),
+		log:  NewEventLog(c, backlog),
+		open: map[io.Closer]struct{}{},
+	}
+}
+
+// ID returns the ID of the Primary's replication stream. Offsets are only
+// meaningful within a stream.
+func (p *Primary) ID() string {
+	return p.id
+}
+
+// Offset returns the number of the most recent change.
+func (p *Primary) Offset() uint64 {
+	return p.log.LastSeq()
+}
+
+// Serve accepts replica connections on l and serves each in its own
+// goroutine. It returns when Accept fails; after Close it returns
+// ErrPrimaryClosed.
+func (p *Primary) Serve(l net.Listener) error {
+	if !p.track(l, true) {
+		return ErrPrimaryClosed
+	}
+	defer p.track(l, false)
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			p.mu.Lock()
+			closed := p.closed
+			p.mu.Unlock()
+			if closed {
+				return ErrPrimaryClosed
+			}
+			return err
+		}
+		go p.ServeConn(conn)
+	}
+}
+
+// track adds or removes a listener or connection from those closed by Close,
+// and reports whether the Primary is open.
+func (p *Primary) track(x io.Closer, add bool) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if add && !p.closed {
+		p.open[x] = struct{}{}
+	} else {
+		delete(p.open, x)
+	}
+	return !p.closed
+}
+
+// ServeConn streams to the replica on conn until the connection fails or
+// the Primary is closed, and then closes conn.
+func (p *Primary) ServeConn(conn net.Conn) error {
+	defer conn.Close()
+	if !p.track(conn, true) {
+		return ErrPrimaryClosed
+	}
+	defer p.track(conn, false)
+
+	var hello replHello
+	if err := gob.NewDecoder(conn).Decode(&hello); err != nil {
+		return err
+	}
+	// The replica sends nothing after its hello; a read returns when it
+	// hangs up.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		io.Copy(io.Discard, conn)
+		cancel()
+	}()
+
+	w := bufio.NewWriter(conn)
+	enc := gob.NewEncoder(w)
+	msg := replMessage{ReplID: p.id, Offset: hello.Offset}
+	var r *LogReader
+	if _, err := p.log.Read(hello.Offset+1, 0); hello.ReplID == p.id &&
+		hello.Offset <= p.log.LastSeq() && err == nil {
+		r = p.log.Tail(hello.Offset + 1)
+	} else {
+		msg.Full = true
+		msg.Offset = p.log.LastSeq()
+		r = p.log.Tail(msg.Offset + 1)
+		msg.Record = &aofRecord{Op: aofSnapshot, Items: p.c.Items()}
+	}
+	for {
+		if msg.Record != nil {
+			if err := registerRecordTypes(msg.Record); err != nil {
+				return err
+			}
+		}
+		if err := enc.Encode(&msg); err != nil {
+			return err
+		}
+		if len(r.pending) == 0 {
+			if err := w.Flush(); err != nil {
+				return err
+			}
+		}
+		entry, err := r.Next(ctx)
+		if err != nil {
+			return err
+		}
+		msg = replMessage{Offset: entry.Seq, Record: recordOf(entry.Event)}
+	}
+}
+
+// Close stops accepting replicas, disconnects those connected and stops
+// recording changes.
+func (p *Primary) Close() error {
+	p.mu.Lock()
+	p.closed = true
+	for x := range p.open {
+		x.Close()
+	}
+	p.mu.Unlock()
+	p.log.Close()
+	return nil
+}
+
+// Replica applies the contents of a Primary's cache, and the changes made to
+// it, to a CacheMap. Wrap the CacheMap in a Cache to read from it; writes to
+// it are overwritten by the Primary's changes to the same keys.
+type Replica struct {
+	m CacheMap
+
+	mu     sync.Mutex
+	replID string
+	offset uint64
+}
+
+// NewReplica returns a Replica that applies a Primary's changes to m.
+func NewReplica(m CacheMap) *Replica {
+	return &Replica{m: m}
+}
+
+// Offset returns the ID of the replication stream the Replica follows and
+// the offset it has applied changes up to.
+func (r *Replica) Offset() (string, uint64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.replID, r.offset
+}
+
+// Sync follows the Primary on conn, resuming from the Replica's offset if
+// the Primary can, until the connection fails. It closes conn and returns
+// the error that ended it.
+func (r *Replica) Sync(conn net.Conn) error {
+	defer conn.Close()
+	replID, offset := r.Offset()
+	if err := gob.NewEncoder(conn).Encode(&replHello{ReplID: replID, Offset: offset}); err != nil {
+		return err
+	}
+	dec := gob.NewDecoder(bufio.NewReader(conn))
+	var msg replMessage
+	if err := dec.Decode(&msg); err != nil {
+		return err
+	}
+	if msg.Full {
+		r.m.Flush()
+		applyAOFRecord(r.m, msg.Record)
+	}
+	r.mu.Lock()
+	r.replID, r.offset = msg.ReplID, msg.Offset
+	r.mu.Unlock()
+	for {
+		msg = replMessage{}
+		if err := dec.Decode(&msg); err != nil {
+			return err
+		}
+		applyAOFRecord(r.m, msg.Record)
+		r.mu.Lock()
+		r.offset = msg.Offset
+		r.mu.Unlock()
+	}
+}
+
+// Run follows a Primary over connections made by dial, reconnecting after
+// retry whenever a connection fails, until ctx is done.
+func (r *Replica) Run(ctx context.Context, dial func(ctx context.Context) (net.Conn, error), retry time.Duration) error {
+	for {
+		if conn, err := dial(ctx); err == nil {
+			stop := context.AfterFunc(ctx, func() { conn.Close() })
+			r.Sync(conn)
+			stop()
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(retry):
+		}
+	}
+}
@@ -0,0 +1,192 @@
+package cache
+
+import (
+	"context"
+	"net"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// waitForOffset waits until r has applied p's changes up to its current
+// offset.
+func waitForOffset(t *testing.T, r *Replica, p *Primary) {
+	t.Helper()
+	want := p.Offset()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		id, offset := r.Offset()
+		if id == p.ID() && offset >= want {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("replica reached offset %d, want %d", offset, want)
+		}
+		<-time.After(time.Millisecond)
+	}
+}
+
+func TestReplication(t *testing.T) {
+	testReplication(t, "tcp", "127.0.0.1:0", NewRwmMap())
+	testReplication(t, "tcp", "127.0.0.1:0", NewSyncMap())
+	testReplication(t, "unix", filepath.Join(t.TempDir(), "repl.sock"), NewConcurrentMap())
+}
+
+func testReplication(t *testing.T, network, address string, m CacheMap) {
+	tc := New(DefaultExpiration, 0, NewConcurrentMap())
+	tc.Set("before", 0, DefaultExpiration)
+	tc.Set("struct", &TestStruct{Num: 1}, time.Hour)
+	p := NewPrimary(tc, 1024)
+	defer p.Close()
+	l, err := net.Listen(network, address)
+	if err != nil {
+		t.Fatal("Couldn't listen:", err)
+	}
+	go p.Serve(l)
+
+	replicas := []*Replica{NewReplica(m), NewReplica(NewRwmMap())}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	for _, r := range replicas {
+		go r.Run(ctx, func(ctx context.Context) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, network, l.Addr().String())
+		}, 10*time.Millisecond)
+	}
+
+	for i := 0; i < 100; i++ {
+		tc.Set("key"+strconv.Itoa(i%10), i, DefaultExpiration)
+	}
+	tc.Set("expired", 1, time.Nanosecond)
+	tc.Delete("key0")
+	<-time.After(time.Millisecond)
+	tc.DeleteExpired()
+
+	for _, r := range replicas {
+		waitForOffset(t, r, p)
+		rc := New(DefaultExpiration, 0, r.m)
+		if x, _ := rc.Get("before"); x != 0 {
+			t.Error("item set before the replica connected was not synced:", x)
+		}
+		if x, found := rc.Get("struct"); !found || x.(*TestStruct).Num != 1 {
+			t.Error("struct was not synced:", x)
+		}
+		for i := 1; i < 10; i++ {
+			if x, _ := rc.Get("key" + strconv.Itoa(i)); x != 90+i {
+				t.Errorf("key%d is %v on the replica, want %d", i, x, 90+i)
+			}
+		}
+		if _, found := rc.Get("key0"); found {
+			t.Error("deleted item was not deleted on the replica")
+		}
+		if _, found := r.m.Get("expired"); found {
+			t.Error("expired item was not deleted on the replica")
+		}
+	}
+
+	tc.Flush()
+	tc.Set("after", "flush", DefaultExpiration)
+	for _, r := range replicas {
+		waitForOffset(t, r, p)
+		rc := New(DefaultExpiration, 0, r.m)
+		if items := rc.Items(); len(items) != 1 || items["after"].Object != "flush" {
+			t.Error("replica has", items, "after a flush")
+		}
+	}
+}
+
+func TestReplicationResync(t *testing.T) {
+	tc := New(DefaultExpiration, 0, NewRwmMap())
+	tc.Set("foo", 0, DefaultExpiration)
+	p := NewPrimary(tc, 8)
+	defer p.Close()
+	r := NewReplica(NewRwmMap())
+
+	resync := func() {
+		primary, replica := net.Pipe()
+		go p.ServeConn(primary)
+		done := make(chan error)
+		go func() { done <- r.Sync(replica) }()
+		waitForOffset(t, r, p)
+		replica.Close()
+		<-done
+	}
+	resync()
+	if x, _ := r.m.Get("foo"); x.(Item).Object != 0 {
+		t.Fatal("foo was not synced:", x)
+	}
+
+	// A replica that missed fewer changes than the backlog resumes where it
+	// left off, keeping what it has.
+	r.m.Set("local", Item{Object: 1})
+	for i := 1; i <= 4; i++ {
+		tc.Set("foo", i, DefaultExpiration)
+	}
+	resync()
+	if x, _ := r.m.Get("foo"); x.(Item).Object != 4 {
+		t.Error("foo was not resynced:", x)
+	}
+	if _, found := r.m.Get("local"); !found {
+		t.Error("a partial resync replaced the replica's contents")
+	}
+
+	// One that missed more needs a full sync.
+	for i := 5; i <= 20; i++ {
+		tc.Set("foo", i, DefaultExpiration)
+	}
+	resync()
+	if x, _ := r.m.Get("foo"); x.(Item).Object != 20 {
+		t.Error("foo was not resynced:", x)
+	}
+	if _, found := r.m.Get("local"); found {
+		t.Error("a full sync kept an item the primary doesn't have")
+	}
+
+	// As does one following a different primary.
+	r.m.Set("local", Item{Object: 1})
+	other := NewPrimary(tc, 8)
+	defer other.Close()
+	primary, replica := net.Pipe()
+	go other.ServeConn(primary)
+	go r.Sync(replica)
+	waitForOffset(t, r, other)
+	replica.Close()
+	if _, found := r.m.Get("local"); found {
+		t.Error("following a new primary did not start with a full sync")
+	}
+}
+
+func TestPrimaryClose(t *testing.T) {
+	tc := New(DefaultExpiration, 0, NewRwmMap())
+	p := NewPrimary(tc, 8)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("Couldn't listen:", err)
+	}
+	served := make(chan error)
+	go func() { served <- p.Serve(l) }()
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal("Couldn't dial:", err)
+	}
+	synced := make(chan error)
+	r := NewReplica(NewRwmMap())
+	go func() { synced <- r.Sync(conn) }()
+	waitForOffset(t, r, p)
+
+	p.Close()
+	select {
+	case err := <-served:
+		if err != ErrPrimaryClosed {
+			t.Error("Serve returned", err, "after Close, want ErrPrimaryClosed")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Serve did not return after Close")
+	}
+	select {
+	case <-synced:
+	case <-time.After(time.Second):
+		t.Fatal("replica was not disconnected by Close")
+	}
+}
@@ -0,0 +1,100 @@
+package server
+
+// matchGlob reports whether s matches the Redis-style glob pattern, in which
+// * matches any sequence of bytes, ? matches any byte, [abc], [^abc] and
+// [a-z] match a byte in, or not in, a set, and \ escapes the next byte.
+func matchGlob(pattern, s string) bool {
+	p, i := 0, 0
+	// On a mismatch, retry from the last * with it matching one more byte.
+	star, retry := -1, 0
+	for i < len(s) {
+		if p < len(pattern) {
+			if pattern[p] == '*' {
+				p++
+				star, retry = p, i
+				continue
+			}
+			if n, ok := matchByte(pattern[p:], s[i]); ok {
+				p += n
+				i++
+				continue
+			}
+		}
+		if star < 0 {
+			return false
+		}
+		retry++
+		p, i = star, retry
+	}
+	for p < len(pattern) && pattern[p] == '*' {
+		p++
+	}
+	return p == len(pattern)
+}
+
+// matchByte matches b against the element at the start of pattern, which
+// must not be *, and returns the element's length and whether b matches it.
+func matchByte(pattern string, b byte) (int, bool) {
+	switch pattern[0] {
+	case '?':
+		return 1, true
+	case '[':
+		return matchClass(pattern, b)
+	case '\\':
+		if len(pattern) > 1 {
+			return 2, pattern[1] == b
+		}
+	}
+	return 1, pattern[0] == b
+}
+
+// matchClass matches b against the class at the start of pattern, which
+// starts with '[', and returns the length of the class and whether b is in
+// it. A class without a closing ']' extends to the end of the pattern.
+func matchClass(pattern string, b byte) (int, bool) {
+	i := 1
+	negate := i < len(pattern) && pattern[i] == '^'
+	if negate {
+		i++
+	}
+	match := false
+	for i < len(pattern) && pattern[i] != ']' {
+		switch {
+		case pattern[i] == '\\' && i+1 < len(pattern):
+			match = match || pattern[i+1] == b
+			i += 2
+		case i+2 < len(pattern) && pattern[i+1] == '-' && pattern[i+2] != ']':
+			lo, hi := pattern[i], pattern[i+2]
+			if lo > hi {
+				lo, hi = hi, lo
+			}
+			match = match || (lo <= b && b <= hi)
+			i += 3
+		default:
+			match = match || pattern[i] == b
+			i++
+		}
+	}
+	if i < len(pattern) {
+		i++
+	}
+	return i, match != negate
+}
+
+// globLiteral returns the only string pattern matches, and false if it has
+// wildcards and so may match others.
+func globLiteral(pattern string) (string, bool) {
+	b := make([]byte, 0, len(pattern))
+	for i := 0; i < len(pattern); i++ {
+		switch pattern[i] {
+		case '*', '?', '[':
+			return "", false
+		case '\\':
+			if i+1 < len(pattern) {
+				i++
+			}
+		}
+		b = append(b, pattern[i])
+	}
+	return string(b), true
+}
@@ -0,0 +1,69 @@
+package server
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestGlobLiteral(t *testing.T) {
+	tests := []struct {
+		pattern, want string
+		ok            bool
+	}{
+		{"foo", "foo", true},
+		{`h\*llo`, "h*llo", true},
+		{`foo\`, `foo\`, true},
+		{"foo*", "", false},
+		{"h?llo", "", false},
+		{"h[ae]llo", "", false},
+	}
+	for _, tt := range tests {
+		if got, ok := globLiteral(tt.pattern); got != tt.want || ok != tt.ok {
+			t.Errorf("globLiteral(%q) = %q, %v, want %q, %v", tt.pattern, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestMatchGlob(t *testing.T) {
+	tests := []struct {
+		pattern, s string
+		want       bool
+	}{
+		{"*", "", true},
+		{"*", "anything", true},
+		{"foo", "foo", true},
+		{"foo", "foobar", false},
+		{"foo*", "foobar", true},
+		{"*bar", "foobar", true},
+		{"f*o*r", "foobar", true},
+		{"f*o*z", "foobar", false},
+		{"h?llo", "hello", true},
+		{"h?llo", "hllo", false},
+		{"h[ae]llo", "hallo", true},
+		{"h[ae]llo", "hillo", false},
+		{"h[^e]llo", "hallo", true},
+		{"h[^e]llo", "hello", false},
+		{"h[a-b]llo", "hbllo", true},
+		{"h[b-a]llo", "hallo", true},
+		{"h[a-b]llo", "hcllo", false},
+		{`h\*llo`, "h*llo", true},
+		{`h\*llo`, "hello", false},
+		{`h[\]]llo`, "h]llo", true},
+		{"user:*:name", "user:42:name", true},
+		{"user:*:name", "user:42:email", false},
+		{"[abc", "b", true},
+	}
+	for _, tt := range tests {
+		if got := matchGlob(tt.pattern, tt.s); got != tt.want {
+			t.Errorf("matchGlob(%q, %q) = %v, want %v", tt.pattern, tt.s, got, tt.want)
+		}
+	}
+}
+
+func BenchmarkMatchGlobStars(b *testing.B) {
+	pattern := strings.Repeat("*a", 20) + "b"
+	s := strings.Repeat("a", 1000)
+	for i := 0; i < b.N; i++ {
+		matchGlob(pattern, s)
+	}
+}
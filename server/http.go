@@ -0,0 +1,351 @@
+package server
+
+import (
+	"container/heap"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	cache "github.com/wyyadd/go-cache"
+)
+
+// ErrUnauthorized can be returned by HTTPOptions.Authorize to reject a
+// request that has no valid credentials with 401 Unauthorized rather than
+// 403 Forbidden.
+var ErrUnauthorized = errors.New("server: unauthorized")
+
+// ValueEncoder converts cache values to and from the bodies of HTTP requests
+// and responses.
+type ValueEncoder interface {
+	// ContentType returns the media type of encoded values.
+	ContentType() string
+	Encode(w io.Writer, x interface{}) error
+	Decode(r io.Reader) (interface{}, error)
+}
+
+// JSONEncoder encodes values as JSON. Decoded values are of the types
+// encoding/json decodes into an interface{}.
+type JSONEncoder struct{}
+
+func (JSONEncoder) ContentType() string {
+	return "application/json"
+}
+
+func (JSONEncoder) Encode(w io.Writer, x interface{}) error {
+	return json.NewEncoder(w).Encode(x)
+}
+
+func (JSONEncoder) Decode(r io.Reader) (interface{}, error) {
+	var x interface{}
+	dec := json.NewDecoder(r)
+	if err := dec.Decode(&x); err != nil {
+		return nil, err
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return nil, errors.New("invalid JSON: data after the value")
+	}
+	return x, nil
+}
+
+// TextEncoder stores bodies as strings and writes values as text, as the
+// RESP and memcached servers do, so values can be shared with their clients.
+type TextEncoder struct{}
+
+func (TextEncoder) ContentType() string {
+	return "text/plain; charset=utf-8"
+}
+
+func (TextEncoder) Encode(w io.Writer, x interface{}) error {
+	_, data := memcacheItemOf(x)
+	_, err := io.WriteString(w, data)
+	return err
+}
+
+func (TextEncoder) Decode(r io.Reader) (interface{}, error) {
+	b, err := io.ReadAll(r)
+	return string(b), err
+}
+
+// HTTPOptions configure the handler returned by NewHTTPHandler.
+type HTTPOptions struct {
+	// Encoder converts values to and from bodies. The default is
+	// JSONEncoder.
+	Encoder ValueEncoder
+	// If not nil, Authorize is called before each request is handled, and
+	// the request is rejected if it returns an error: with 401 Unauthorized
+	// if the error is ErrUnauthorized, and with 403 Forbidden otherwise.
+	Authorize func(r *http.Request) error
+	// The largest request body accepted. The default is 1 MiB.
+	MaxBodySize int64
+}
+
+const (
+	defaultMaxBodySize = 1 << 20
+	defaultListLimit   = 100
+	maxListLimit       = 1000
+)
+
+// NewHTTPHandler returns an http.Handler for inspecting and changing c:
+//
+//	GET    /keys/{key}        the value of an item
+//	PUT    /keys/{key}?ttl=   set an item from the body; ttl is a duration
+//	                          such as 30s, or "none", and defaults to the
+//	                          cache's default expiration
+//	DELETE /keys/{key}        delete an item
+//	GET    /keys?prefix=&after=&limit=
+//	                          list keys in order, a page at a time: pass the
+//	                          returned next key as after to get the next page
+//	GET    /ttl/{key}         the expiration time and remaining time to live
+//	                          of an item
+//	POST   /flush             delete all items
+//	POST   /delete-expired    delete expired items
+//	GET    /stats             the cache's Stats
+//
+// Keys may contain slashes, and other characters escaped as in any URL
+// path. Responses other than values are JSON, and errors are objects with
+// an "error" member. An item's expiration time, if it has one, is also
+// returned with its value in the X-Cache-Expiration header.
+func NewHTTPHandler(c *cache.Cache, opts HTTPOptions) http.Handler {
+	if opts.Encoder == nil {
+		opts.Encoder = JSONEncoder{}
+	}
+	if opts.MaxBodySize <= 0 {
+		opts.MaxBodySize = defaultMaxBodySize
+	}
+	h := &httpHandler{c: c, opts: opts, mux: http.NewServeMux()}
+	h.mux.HandleFunc("GET /keys/{key...}", h.get)
+	h.mux.HandleFunc("PUT /keys/{key...}", h.put)
+	h.mux.HandleFunc("DELETE /keys/{key...}", h.delete)
+	h.mux.HandleFunc("GET /keys", h.list)
+	h.mux.HandleFunc("GET /ttl/{key...}", h.ttl)
+	h.mux.HandleFunc("POST /flush", h.flush)
+	h.mux.HandleFunc("POST /delete-expired", h.deleteExpired)
+	h.mux.HandleFunc("GET /stats", h.stats)
+	return h
+}
+
+type httpHandler struct {
+	c    *cache.Cache
+	opts HTTPOptions
+	mux  *http.ServeMux
+}
+
+func (h *httpHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if h.opts.Authorize != nil {
+		if err := h.opts.Authorize(r); err != nil {
+			status := http.StatusForbidden
+			if errors.Is(err, ErrUnauthorized) {
+				status = http.StatusUnauthorized
+			}
+			writeHTTPError(w, status, err.Error())
+			return
+		}
+	}
+	h.mux.ServeHTTP(w, r)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeHTTPError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, map[string]string{"error": msg})
+}
+
+// key returns the key of a request, writing an error if it is empty.
+func key(w http.ResponseWriter, r *http.Request) (string, bool) {
+	k := r.PathValue("key")
+	if k == "" {
+		writeHTTPError(w, http.StatusBadRequest, "empty key")
+		return "", false
+	}
+	return k, true
+}
+
+// lookup returns the value and expiration time of an unexpired item.
+func (h *httpHandler) lookup(k string) (interface{}, time.Time, bool) {
+	x, e, found := h.c.GetWithExpiration(k)
+	if !found || (!e.IsZero() && !e.After(time.Now())) {
+		return nil, time.Time{}, false
+	}
+	return x, e, true
+}
+
+func (h *httpHandler) get(w http.ResponseWriter, r *http.Request) {
+	k, ok := key(w, r)
+	if !ok {
+		return
+	}
+	x, e, found := h.lookup(k)
+	if !found {
+		writeHTTPError(w, http.StatusNotFound, "not found")
+		return
+	}
+	w.Header().Set("Content-Type", h.opts.Encoder.ContentType())
+	if !e.IsZero() {
+		w.Header().Set("X-Cache-Expiration", e.UTC().Format(time.RFC3339Nano))
+	}
+	if err := h.opts.Encoder.Encode(w, x); err != nil {
+		// The status has been sent; all that can be done is to cut the
+		// response short.
+		panic(http.ErrAbortHandler)
+	}
+}
+
+func (h *httpHandler) put(w http.ResponseWriter, r *http.Request) {
+	k, ok := key(w, r)
+	if !ok {
+		return
+	}
+	d := cache.DefaultExpiration
+	if ttl := r.URL.Query().Get("ttl"); ttl == "none" {
+		d = cache.NoExpiration
+	} else if ttl != "" {
+		var err error
+		d, err = time.ParseDuration(ttl)
+		if err != nil || d <= 0 {
+			writeHTTPError(w, http.StatusBadRequest, fmt.Sprintf("invalid ttl %q", ttl))
+			return
+		}
+	}
+	x, err := h.opts.Encoder.Decode(http.MaxBytesReader(w, r.Body, h.opts.MaxBodySize))
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			writeHTTPError(w, http.StatusRequestEntityTooLarge, err.Error())
+		} else {
+			writeHTTPError(w, http.StatusBadRequest, err.Error())
+		}
+		return
+	}
+	h.c.Set(k, x, d)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *httpHandler) delete(w http.ResponseWriter, r *http.Request) {
+	k, ok := key(w, r)
+	if !ok {
+		return
+	}
+	var deleted bool
+	h.c.Compute(k, func(old interface{}, found bool) (interface{}, time.Duration, cache.Op) {
+		deleted = found
+		return nil, 0, cache.OpDelete
+	})
+	if !deleted {
+		writeHTTPError(w, http.StatusNotFound, "not found")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *httpHandler) list(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	prefix, after := q.Get("prefix"), q.Get("after")
+	limit := defaultListLimit
+	if s := q.Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 || n > maxListLimit {
+			writeHTTPError(w, http.StatusBadRequest, fmt.Sprintf("limit must be between 1 and %d", maxListLimit))
+			return
+		}
+		limit = n
+	}
+	// Keep only the first limit+1 keys in order, the extra one telling
+	// whether there is another page, rather than sorting all of them.
+	keys := &maxHeap{}
+	for k := range h.c.Keys() {
+		if !strings.HasPrefix(k, prefix) || k <= after {
+			continue
+		}
+		if keys.Len() <= limit {
+			heap.Push(keys, k)
+		} else if k < (*keys)[0] {
+			(*keys)[0] = k
+			heap.Fix(keys, 0)
+		}
+	}
+	slices.Sort(*keys)
+	resp := struct {
+		Keys []string `json:"keys"`
+		Next string   `json:"next,omitempty"`
+	}{Keys: *keys}
+	if keys.Len() > limit {
+		resp.Keys = (*keys)[:limit]
+		resp.Next = (*keys)[limit-1]
+	}
+	if resp.Keys == nil {
+		resp.Keys = []string{}
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// maxHeap is a max-heap of keys.
+type maxHeap []string
+
+func (h maxHeap) Len() int           { return len(h) }
+func (h maxHeap) Less(i, j int) bool { return h[i] > h[j] }
+func (h maxHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h *maxHeap) Push(x any)        { *h = append(*h, x.(string)) }
+func (h *maxHeap) Pop() any {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
+
+func (h *httpHandler) ttl(w http.ResponseWriter, r *http.Request) {
+	k, ok := key(w, r)
+	if !ok {
+		return
+	}
+	_, e, found := h.lookup(k)
+	if !found {
+		writeHTTPError(w, http.StatusNotFound, "not found")
+		return
+	}
+	resp := struct {
+		Key        string     `json:"key"`
+		Expiration *time.Time `json:"expiration"`
+		// The remaining time to live in seconds.
+		TTL *float64 `json:"ttl"`
+	}{Key: k}
+	if !e.IsZero() {
+		ttl := time.Until(e).Seconds()
+		resp.Expiration, resp.TTL = &e, &ttl
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func (h *httpHandler) flush(w http.ResponseWriter, r *http.Request) {
+	h.c.Flush()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *httpHandler) deleteExpired(w http.ResponseWriter, r *http.Request) {
+	h.c.DeleteExpired()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *httpHandler) stats(w http.ResponseWriter, r *http.Request) {
+	st := h.c.Stats()
+	writeJSON(w, http.StatusOK, struct {
+		Hits        uint64  `json:"hits"`
+		Misses      uint64  `json:"misses"`
+		HitRatio    float64 `json:"hit_ratio"`
+		Expirations uint64  `json:"expirations"`
+		Evictions   uint64  `json:"evictions"`
+		Items       int     `json:"items"`
+		JanitorRuns uint64  `json:"janitor_runs"`
+		// The time spent in sweeps for expired items, in seconds.
+		JanitorTime float64 `json:"janitor_seconds"`
+	}{st.Hits, st.Misses, st.HitRatio(), st.Expirations, st.Evictions, st.Items, st.JanitorRuns, st.JanitorTime.Seconds()})
+}
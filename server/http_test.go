@@ -0,0 +1,233 @@
+package server
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	cache "github.com/wyyadd/go-cache"
+)
+
+func startHTTP(t *testing.T, c *cache.Cache, opts HTTPOptions) *httptest.Server {
+	t.Helper()
+	ts := httptest.NewServer(NewHTTPHandler(c, opts))
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+// doHTTP sends a request and returns the response's status and body.
+func doHTTP(t *testing.T, method, url, body string) (int, string, http.Header) {
+	t.Helper()
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal("Couldn't send the request:", err)
+	}
+	defer resp.Body.Close()
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal("Couldn't read the response:", err)
+	}
+	return resp.StatusCode, string(b), resp.Header
+}
+
+func TestHTTPHandler(t *testing.T) {
+	tc := cache.New(cache.NoExpiration, 0, cache.NewConcurrentMap())
+	ts := startHTTP(t, tc, HTTPOptions{})
+
+	tests := []struct {
+		method, path, body string
+		status             int
+		want               string
+	}{
+		{"GET", "/keys/foo", "", http.StatusNotFound, `{"error":"not found"}` + "\n"},
+		{"PUT", "/keys/foo", `{"a":[1,2]}`, http.StatusNoContent, ""},
+		{"GET", "/keys/foo", "", http.StatusOK, `{"a":[1,2]}` + "\n"},
+		{"PUT", "/keys/a%2Fb/c", `"slashes"`, http.StatusNoContent, ""},
+		{"GET", "/keys/a%2Fb/c", "", http.StatusOK, `"slashes"` + "\n"},
+		{"PUT", "/keys/bad", `{"a":`, http.StatusBadRequest, ""},
+		{"PUT", "/keys/bad", `1 2`, http.StatusBadRequest, ""},
+		{"PUT", "/keys/bad?ttl=soon", `1`, http.StatusBadRequest, ""},
+		{"PUT", "/keys/", `1`, http.StatusBadRequest, `{"error":"empty key"}` + "\n"},
+		{"DELETE", "/keys/foo", "", http.StatusNoContent, ""},
+		{"DELETE", "/keys/foo", "", http.StatusNotFound, ""},
+		{"POST", "/keys/foo", "", http.StatusMethodNotAllowed, ""},
+	}
+	for _, tt := range tests {
+		status, body, _ := doHTTP(t, tt.method, ts.URL+tt.path, tt.body)
+		if status != tt.status {
+			t.Errorf("%s %s: got status %d, want %d: %s", tt.method, tt.path, status, tt.status, body)
+		}
+		if tt.want != "" && body != tt.want {
+			t.Errorf("%s %s: got %q, want %q", tt.method, tt.path, body, tt.want)
+		}
+	}
+	if x, found := tc.Get("a/b/c"); !found || x != "slashes" {
+		t.Errorf("PUT stored %#v, want the decoded body", x)
+	}
+	if _, found := tc.Get("bad"); found {
+		t.Error("a rejected PUT stored an item")
+	}
+}
+
+func TestHTTPHandlerTTL(t *testing.T) {
+	tc := cache.New(time.Hour, 0, cache.NewRwmMap())
+	ts := startHTTP(t, tc, HTTPOptions{})
+
+	doHTTP(t, "PUT", ts.URL+"/keys/default", "1")
+	doHTTP(t, "PUT", ts.URL+"/keys/forever?ttl=none", "1")
+	doHTTP(t, "PUT", ts.URL+"/keys/short?ttl=1ms", "1")
+	if _, e, _ := tc.GetWithExpiration("default"); time.Until(e) <= 59*time.Minute {
+		t.Error("PUT without a ttl did not use the default expiration:", e)
+	}
+	<-time.After(5 * time.Millisecond)
+
+	var ttl struct {
+		Key        string
+		Expiration *time.Time
+		TTL        *float64
+	}
+	status, body, _ := doHTTP(t, "GET", ts.URL+"/ttl/default", "")
+	if err := json.Unmarshal([]byte(body), &ttl); status != http.StatusOK || err != nil {
+		t.Fatalf("GET /ttl/default: %d %s", status, body)
+	}
+	if ttl.Key != "default" || ttl.Expiration == nil || ttl.TTL == nil || *ttl.TTL <= 59*60 || *ttl.TTL > 60*60 {
+		t.Errorf("GET /ttl/default returned %s", body)
+	}
+	status, body, _ = doHTTP(t, "GET", ts.URL+"/ttl/forever", "")
+	if status != http.StatusOK || body != `{"key":"forever","expiration":null,"ttl":null}`+"\n" {
+		t.Errorf("GET /ttl/forever: %d %s", status, body)
+	}
+	if status, _, _ := doHTTP(t, "GET", ts.URL+"/ttl/short", ""); status != http.StatusNotFound {
+		t.Error("GET /ttl of an expired item returned", status)
+	}
+	if status, _, _ := doHTTP(t, "GET", ts.URL+"/keys/short", ""); status != http.StatusNotFound {
+		t.Error("GET of an expired item returned", status)
+	}
+	_, _, header := doHTTP(t, "GET", ts.URL+"/keys/default", "")
+	if e, err := time.Parse(time.RFC3339Nano, header.Get("X-Cache-Expiration")); err != nil || time.Until(e) <= 59*time.Minute {
+		t.Errorf("X-Cache-Expiration is %q", header.Get("X-Cache-Expiration"))
+	}
+
+	if status, _, _ := doHTTP(t, "POST", ts.URL+"/delete-expired", ""); status != http.StatusNoContent {
+		t.Error("POST /delete-expired returned", status)
+	}
+	if tc.ItemCount() != 2 {
+		t.Error("POST /delete-expired left", tc.ItemCount(), "items, want 2")
+	}
+	if status, _, _ := doHTTP(t, "POST", ts.URL+"/flush", ""); status != http.StatusNoContent {
+		t.Error("POST /flush returned", status)
+	}
+	if tc.ItemCount() != 0 {
+		t.Error("POST /flush left", tc.ItemCount(), "items")
+	}
+}
+
+func TestHTTPHandlerList(t *testing.T) {
+	tc := cache.New(cache.NoExpiration, 0, cache.NewSyncMap())
+	for _, k := range []string{"user:3", "user:1", "user:2", "session:1", "user:4"} {
+		tc.Set(k, 1, cache.NoExpiration)
+	}
+	ts := startHTTP(t, tc, HTTPOptions{})
+
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{"", `{"keys":["session:1","user:1","user:2","user:3","user:4"]}`},
+		{"?prefix=user:&limit=2", `{"keys":["user:1","user:2"],"next":"user:2"}`},
+		{"?prefix=user:&limit=2&after=user:2", `{"keys":["user:3","user:4"]}`},
+		{"?limit=1", `{"keys":["session:1"],"next":"session:1"}`},
+		{"?prefix=user:&limit=1&after=user:1", `{"keys":["user:2"],"next":"user:2"}`},
+		{"?prefix=none", `{"keys":[]}`},
+	}
+	for _, tt := range tests {
+		status, body, _ := doHTTP(t, "GET", ts.URL+"/keys"+tt.query, "")
+		if status != http.StatusOK || body != tt.want+"\n" {
+			t.Errorf("GET /keys%s: got %d %s, want %s", tt.query, status, body, tt.want)
+		}
+	}
+	if status, _, _ := doHTTP(t, "GET", ts.URL+"/keys?limit=0", ""); status != http.StatusBadRequest {
+		t.Error("GET /keys?limit=0 returned", status)
+	}
+}
+
+func TestHTTPHandlerStats(t *testing.T) {
+	tc := cache.New(cache.NoExpiration, 0, cache.NewRwmMap())
+	ts := startHTTP(t, tc, HTTPOptions{})
+	doHTTP(t, "PUT", ts.URL+"/keys/foo", "1")
+	doHTTP(t, "GET", ts.URL+"/keys/foo", "")
+	doHTTP(t, "GET", ts.URL+"/keys/missing", "")
+
+	var st map[string]float64
+	status, body, _ := doHTTP(t, "GET", ts.URL+"/stats", "")
+	if err := json.Unmarshal([]byte(body), &st); status != http.StatusOK || err != nil {
+		t.Fatalf("GET /stats: %d %s", status, body)
+	}
+	if st["hits"] != 1 || st["misses"] != 1 || st["items"] != 1 || st["hit_ratio"] != 0.5 {
+		t.Errorf("GET /stats returned %s", body)
+	}
+}
+
+func TestHTTPHandlerOptions(t *testing.T) {
+	tc := cache.New(cache.NoExpiration, 0, cache.NewRwmMap())
+	ts := startHTTP(t, tc, HTTPOptions{
+		Encoder: TextEncoder{},
+		Authorize: func(r *http.Request) error {
+			switch r.Header.Get("Authorization") {
+			case "":
+				return ErrUnauthorized
+			case "Bearer secret":
+				return nil
+			}
+			return io.ErrUnexpectedEOF
+		},
+		MaxBodySize: 8,
+	})
+
+	do := func(method, path, body, auth string) (int, string, http.Header) {
+		t.Helper()
+		req, _ := http.NewRequest(method, ts.URL+path, strings.NewReader(body))
+		if auth != "" {
+			req.Header.Set("Authorization", auth)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal("Couldn't send the request:", err)
+		}
+		defer resp.Body.Close()
+		b, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(b), resp.Header
+	}
+
+	if status, _, _ := do("GET", "/stats", "", ""); status != http.StatusUnauthorized {
+		t.Error("a request without credentials returned", status)
+	}
+	if status, _, _ := do("GET", "/stats", "", "Bearer wrong"); status != http.StatusForbidden {
+		t.Error("a request with the wrong credentials returned", status)
+	}
+	if status, _, _ := do("PUT", "/keys/foo", "plain", "Bearer secret"); status != http.StatusNoContent {
+		t.Error("PUT returned", status)
+	}
+	if x, _ := tc.Get("foo"); x != "plain" {
+		t.Errorf("TextEncoder stored %#v, want the body as a string", x)
+	}
+	tc.Set("flagged", MemcacheValue{Flags: 1, Value: "mc"}, cache.NoExpiration)
+	tc.Set("int", 42, cache.NoExpiration)
+	for k, want := range map[string]string{"foo": "plain", "flagged": "mc", "int": "42"} {
+		status, body, header := do("GET", "/keys/"+k, "", "Bearer secret")
+		if status != http.StatusOK || body != want || !strings.HasPrefix(header.Get("Content-Type"), "text/plain") {
+			t.Errorf("GET /keys/%s: got %d %q (%s), want %q", k, status, body, header.Get("Content-Type"), want)
+		}
+	}
+	if status, _, _ := do("PUT", "/keys/big", "too large!", "Bearer secret"); status != http.StatusRequestEntityTooLarge {
+		t.Error("PUT of a body larger than MaxBodySize returned", status)
+	}
+}
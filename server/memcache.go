@@ -0,0 +1,555 @@
+package server
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	cache "github.com/wyyadd/go-cache"
+)
+
+// memcacheVersion is reported as the server's version. It is the version of
+// memcached whose protocol is emulated.
+const memcacheVersion = "1.6.0"
+
+const (
+	// An exptime of at most this many seconds is relative to now; a larger
+	// one is a Unix time.
+	maxRelativeExptime = 60 * 60 * 24 * 30
+	// The longest command line and the largest value accepted, as in
+	// memcached's defaults.
+	maxMemcacheLine  = 64 << 10
+	maxMemcacheValue = 1 << 20
+	maxMemcacheKey   = 250
+)
+
+// MemcacheValue is stored in the cache for an item set over the memcached
+// protocol with non-zero flags. Items with zero flags are stored as strings.
+type MemcacheValue struct {
+	Flags uint32
+	Value string
+}
+
+// MemcacheServer serves a Cache over the memcached text protocol, so that
+// memcached clients can use it as a single memcached server.
+//
+// The supported commands are get, gets, set, add, replace, append, prepend,
+// cas, incr, decr, delete, touch, flush_all, stats, version, verbosity and
+// quit. Values of other types stored by Go code are read as strings
+// formatted with fmt.Sprint and zero flags, and integers of type int, int64
+// or uint64 can be incremented. The cas unique of an item is its version.
+type MemcacheServer struct {
+	c     *cache.Cache
+	start time.Time
+	t     tracker
+
+	clients     atomic.Int64
+	connections atomic.Int64
+	gets        atomic.Int64
+	hits        atomic.Int64
+	sets        atomic.Int64
+	touches     atomic.Int64
+}
+
+// NewMemcacheServer returns a MemcacheServer for c.
+func NewMemcacheServer(c *cache.Cache) *MemcacheServer {
+	return &MemcacheServer{c: c, start: time.Now()}
+}
+
+// Serve accepts connections on l and serves each in its own goroutine. It
+// returns when Accept fails; after Close it returns ErrServerClosed.
+func (s *MemcacheServer) Serve(l net.Listener) error {
+	return s.t.serve(l, s.ServeConn)
+}
+
+// ServeConn serves the client on conn until it quits, the connection fails
+// or the server is closed, and then closes conn.
+func (s *MemcacheServer) ServeConn(conn net.Conn) error {
+	defer conn.Close()
+	if !s.t.add(conn) {
+		return ErrServerClosed
+	}
+	defer s.t.remove(conn)
+	s.connections.Add(1)
+	s.clients.Add(1)
+	defer s.clients.Add(-1)
+
+	mc := &mcConn{
+		s: s,
+		r: bufio.NewReaderSize(conn, maxMemcacheLine),
+		w: bufio.NewWriter(conn),
+	}
+	for {
+		line, err := mc.r.ReadSlice('\n')
+		if err == bufio.ErrBufferFull {
+			mc.w.WriteString("CLIENT_ERROR line too long\r\n")
+			mc.w.Flush()
+			return err
+		}
+		if err != nil {
+			if err == io.EOF && len(line) == 0 {
+				return nil
+			}
+			return err
+		}
+		fields := strings.Fields(string(line))
+		if len(fields) == 0 {
+			mc.w.WriteString("ERROR\r\n")
+		} else if quit, err := mc.exec(fields); quit || err != nil {
+			mc.w.Flush()
+			return err
+		}
+		if mc.r.Buffered() == 0 {
+			if err := mc.w.Flush(); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// Close stops accepting connections and closes those open.
+func (s *MemcacheServer) Close() error {
+	s.t.close()
+	return nil
+}
+
+// mcConn is the state of a client connection.
+type mcConn struct {
+	s *MemcacheServer
+	r *bufio.Reader
+	w *bufio.Writer
+	// Whether the current command asked for no reply.
+	noreply bool
+}
+
+const errBadFormat = "CLIENT_ERROR bad command line format"
+
+// errBadDataChunk is returned for a value not followed by CRLF, after which
+// the rest of the stream can't be parsed.
+var errBadDataChunk = errors.New("bad data chunk")
+
+// exec runs the command whose line has the given fields, and reports whether
+// the connection should be closed. It returns an error if the connection
+// can't be used any more.
+func (mc *mcConn) exec(fields []string) (bool, error) {
+	mc.noreply = len(fields) > 1 && fields[len(fields)-1] == "noreply"
+	if mc.noreply {
+		fields = fields[:len(fields)-1]
+	}
+	switch cmd, args := fields[0], fields[1:]; cmd {
+	case "get", "gets":
+		mc.get(args, cmd == "gets")
+	case "set", "add", "replace", "append", "prepend", "cas":
+		return false, mc.store(cmd, args)
+	case "incr", "decr":
+		mc.incr(args, cmd == "decr")
+	case "delete":
+		mc.delete(args)
+	case "touch":
+		mc.touch(args)
+	case "flush_all":
+		mc.flushAll(args)
+	case "stats":
+		mc.stats(args)
+	case "version":
+		mc.w.WriteString("VERSION " + memcacheVersion + "\r\n")
+	case "verbosity":
+		mc.reply("OK")
+	case "quit":
+		return true, nil
+	default:
+		mc.w.WriteString("ERROR\r\n")
+	}
+	return false, nil
+}
+
+// reply writes a reply line unless the command asked for no reply.
+func (mc *mcConn) reply(s string) {
+	if !mc.noreply {
+		mc.w.WriteString(s)
+		mc.w.WriteString("\r\n")
+	}
+}
+
+func validKey(k string) bool {
+	if len(k) > maxMemcacheKey {
+		return false
+	}
+	for i := 0; i < len(k); i++ {
+		if k[i] <= ' ' || k[i] == 0x7f {
+			return false
+		}
+	}
+	return true
+}
+
+// memcacheDuration converts a memcached exptime to a duration for
+// Cache.Set, and reports whether an item with that exptime has already
+// expired.
+func memcacheDuration(exptime int64, now time.Time) (time.Duration, bool) {
+	switch {
+	case exptime == 0:
+		return cache.NoExpiration, false
+	case exptime < 0:
+		return 0, true
+	case exptime <= maxRelativeExptime:
+		return time.Duration(exptime) * time.Second, false
+	}
+	d := time.Unix(exptime, 0).Sub(now)
+	return d, d <= 0
+}
+
+// memcacheValueOf returns the value stored in the cache for data with flags.
+func memcacheValueOf(flags uint32, data string) interface{} {
+	if flags == 0 {
+		return data
+	}
+	return MemcacheValue{Flags: flags, Value: data}
+}
+
+// memcacheItemOf returns the flags and data of a value in the cache.
+func memcacheItemOf(x interface{}) (uint32, string) {
+	switch x := x.(type) {
+	case MemcacheValue:
+		return x.Flags, x.Value
+	case string:
+		return 0, x
+	case []byte:
+		return 0, string(x)
+	}
+	return 0, fmt.Sprint(x)
+}
+
+func (mc *mcConn) get(keys []string, cas bool) {
+	if len(keys) == 0 {
+		mc.w.WriteString("ERROR\r\n")
+		return
+	}
+	for _, k := range keys {
+		if !validKey(k) {
+			mc.w.WriteString(errBadFormat + "\r\n")
+			return
+		}
+	}
+	c := mc.s.c
+	for _, k := range keys {
+		mc.s.gets.Add(1)
+		x, version, found := c.GetWithVersion(k)
+		if !found {
+			continue
+		}
+		mc.s.hits.Add(1)
+		flags, data := memcacheItemOf(x)
+		fmt.Fprintf(mc.w, "VALUE %s %d %d", k, flags, len(data))
+		if cas {
+			fmt.Fprintf(mc.w, " %d", version)
+		}
+		mc.w.WriteString("\r\n")
+		mc.w.WriteString(data)
+		mc.w.WriteString("\r\n")
+	}
+	mc.w.WriteString("END\r\n")
+}
+
+// store runs a storage command. It returns an error if the value's data
+// can't be read.
+func (mc *mcConn) store(cmd string, args []string) error {
+	n := 4
+	if cmd == "cas" {
+		n = 5
+	}
+	if len(args) != n || !validKey(args[0]) {
+		mc.w.WriteString(errBadFormat + "\r\n")
+		return nil
+	}
+	key := args[0]
+	flags, err1 := strconv.ParseUint(args[1], 10, 32)
+	exptime, err2 := strconv.ParseInt(args[2], 10, 64)
+	size, err3 := strconv.Atoi(args[3])
+	var unique uint64
+	var err4 error
+	if cmd == "cas" {
+		unique, err4 = strconv.ParseUint(args[4], 10, 64)
+	}
+	if err1 != nil || err2 != nil || err3 != nil || err4 != nil || size < 0 {
+		mc.w.WriteString(errBadFormat + "\r\n")
+		return nil
+	}
+	if size > maxMemcacheValue {
+		// Skip the value so that the next command can be read.
+		if _, err := mc.r.Discard(size + 2); err != nil {
+			return err
+		}
+		mc.w.WriteString("SERVER_ERROR object too large for cache\r\n")
+		return nil
+	}
+	b := make([]byte, size+2)
+	if _, err := io.ReadFull(mc.r, b); err != nil {
+		return err
+	}
+	if b[size] != '\r' || b[size+1] != '\n' {
+		mc.w.WriteString("CLIENT_ERROR bad data chunk\r\n")
+		return errBadDataChunk
+	}
+	data := string(b[:size])
+	mc.s.sets.Add(1)
+
+	d, expired := memcacheDuration(exptime, time.Now())
+	value := memcacheValueOf(uint32(flags), data)
+	// The op for a new value: an expired one is stored by deleting the key.
+	setOp := cache.OpSet
+	if expired {
+		setOp = cache.OpDelete
+	}
+	c := mc.s.c
+	switch cmd {
+	case "set":
+		if expired {
+			c.Delete(key)
+		} else {
+			c.Set(key, value, d)
+		}
+		mc.reply("STORED")
+	case "add", "replace":
+		var stored bool
+		c.Compute(key, func(old interface{}, found bool) (interface{}, time.Duration, cache.Op) {
+			if stored = found == (cmd == "replace"); !stored {
+				return nil, 0, cache.OpKeep
+			}
+			return value, d, setOp
+		})
+		mc.reply(storedReply(stored))
+	case "append", "prepend":
+		var stored bool
+		c.Compute(key, func(old interface{}, found bool) (interface{}, time.Duration, cache.Op) {
+			if stored = found; !found {
+				return nil, 0, cache.OpKeep
+			}
+			// The flags and expiration time of the item are kept.
+			flags, oldData := memcacheItemOf(old)
+			if cmd == "append" {
+				return memcacheValueOf(flags, oldData+data), cache.KeepExpiration, cache.OpSet
+			}
+			return memcacheValueOf(flags, data+oldData), cache.KeepExpiration, cache.OpSet
+		})
+		mc.reply(storedReply(stored))
+	case "cas":
+		// The item is checked and replaced in one transaction, so the
+		// reply is decided by the item the swap was compared with.
+		var reply string
+		err := c.Txn(func(tx *cache.Tx) error {
+			_, version, found := tx.GetWithVersion(key)
+			switch {
+			case !found:
+				reply = "NOT_FOUND"
+			case version != unique:
+				reply = "EXISTS"
+			default:
+				reply = "STORED"
+				if expired {
+					tx.Delete(key)
+				} else {
+					tx.Set(key, value, d)
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			// The item kept changing, so it isn't the one the client read.
+			reply = "EXISTS"
+		}
+		mc.reply(reply)
+	}
+	return nil
+}
+
+func storedReply(stored bool) string {
+	if stored {
+		return "STORED"
+	}
+	return "NOT_STORED"
+}
+
+func (mc *mcConn) incr(args []string, decr bool) {
+	if len(args) != 2 || !validKey(args[0]) {
+		mc.w.WriteString(errBadFormat + "\r\n")
+		return
+	}
+	delta, err := strconv.ParseUint(args[1], 10, 64)
+	if err != nil {
+		mc.w.WriteString("CLIENT_ERROR invalid numeric delta argument\r\n")
+		return
+	}
+	var result uint64
+	var found, numeric bool
+	mc.s.c.Compute(args[0], func(old interface{}, ok bool) (interface{}, time.Duration, cache.Op) {
+		if found = ok; !ok {
+			return nil, 0, cache.OpKeep
+		}
+		n, err := unsignedOf(old)
+		if numeric = err == nil; !numeric {
+			return nil, 0, cache.OpKeep
+		}
+		// Increments wrap around; decrements stop at 0.
+		switch {
+		case !decr:
+			result = n + delta
+		case delta > n:
+			result = 0
+		default:
+			result = n - delta
+		}
+		switch old := old.(type) {
+		case int:
+			if uint64(int(result)) == result && int(result) >= 0 {
+				return int(result), cache.KeepExpiration, cache.OpSet
+			}
+		case int64:
+			if int64(result) >= 0 {
+				return int64(result), cache.KeepExpiration, cache.OpSet
+			}
+		case uint64:
+			return result, cache.KeepExpiration, cache.OpSet
+		case MemcacheValue:
+			return memcacheValueOf(old.Flags, strconv.FormatUint(result, 10)), cache.KeepExpiration, cache.OpSet
+		}
+		return strconv.FormatUint(result, 10), cache.KeepExpiration, cache.OpSet
+	})
+	switch {
+	case !found:
+		mc.reply("NOT_FOUND")
+	case !numeric:
+		mc.w.WriteString("CLIENT_ERROR cannot increment or decrement non-numeric value\r\n")
+	default:
+		mc.reply(strconv.FormatUint(result, 10))
+	}
+}
+
+// unsignedOf returns the value of a cache value holding a non-negative
+// integer.
+func unsignedOf(x interface{}) (uint64, error) {
+	switch x := x.(type) {
+	case int:
+		if x >= 0 {
+			return uint64(x), nil
+		}
+	case int64:
+		if x >= 0 {
+			return uint64(x), nil
+		}
+	case uint64:
+		return x, nil
+	default:
+		_, data := memcacheItemOf(x)
+		return strconv.ParseUint(data, 10, 64)
+	}
+	return 0, strconv.ErrRange
+}
+
+func (mc *mcConn) delete(args []string) {
+	// A time of 0 is accepted for compatibility with old clients.
+	if len(args) == 0 || len(args) > 2 || (len(args) == 2 && args[1] != "0") || !validKey(args[0]) {
+		mc.w.WriteString(errBadFormat + "\r\n")
+		return
+	}
+	var deleted bool
+	mc.s.c.Compute(args[0], func(old interface{}, found bool) (interface{}, time.Duration, cache.Op) {
+		deleted = found
+		return nil, 0, cache.OpDelete
+	})
+	if deleted {
+		mc.reply("DELETED")
+	} else {
+		mc.reply("NOT_FOUND")
+	}
+}
+
+func (mc *mcConn) touch(args []string) {
+	if len(args) != 2 || !validKey(args[0]) {
+		mc.w.WriteString(errBadFormat + "\r\n")
+		return
+	}
+	exptime, err := strconv.ParseInt(args[1], 10, 64)
+	if err != nil {
+		mc.w.WriteString("CLIENT_ERROR invalid exptime argument\r\n")
+		return
+	}
+	mc.s.touches.Add(1)
+	d, expired := memcacheDuration(exptime, time.Now())
+	var touched bool
+	mc.s.c.Compute(args[0], func(old interface{}, found bool) (interface{}, time.Duration, cache.Op) {
+		switch touched = found; {
+		case !found:
+			return nil, 0, cache.OpKeep
+		case expired:
+			return nil, 0, cache.OpDelete
+		}
+		return old, d, cache.OpSet
+	})
+	if touched {
+		mc.reply("TOUCHED")
+	} else {
+		mc.reply("NOT_FOUND")
+	}
+}
+
+// flushAll deletes every item, after a delay in seconds if one is given.
+func (mc *mcConn) flushAll(args []string) {
+	if len(args) > 1 {
+		mc.w.WriteString(errBadFormat + "\r\n")
+		return
+	}
+	var delay int64
+	if len(args) == 1 {
+		n, err := strconv.ParseInt(args[0], 10, 64)
+		if err != nil || n < 0 {
+			mc.w.WriteString(errBadFormat + "\r\n")
+			return
+		}
+		delay = n
+	}
+	if delay > 0 {
+		c := mc.s.c
+		time.AfterFunc(time.Duration(delay)*time.Second, c.Flush)
+	} else {
+		mc.s.c.Flush()
+	}
+	mc.reply("OK")
+}
+
+func (mc *mcConn) stats(args []string) {
+	if len(args) > 0 {
+		// No detailed statistics are kept.
+		mc.w.WriteString("END\r\n")
+		return
+	}
+	s := mc.s
+	stats := []struct {
+		name  string
+		value int64
+	}{
+		{"pid", int64(os.Getpid())},
+		{"uptime", int64(time.Since(s.start).Seconds())},
+		{"time", time.Now().Unix()},
+		{"curr_connections", s.clients.Load()},
+		{"total_connections", s.connections.Load()},
+		{"cmd_get", s.gets.Load()},
+		{"cmd_set", s.sets.Load()},
+		{"cmd_touch", s.touches.Load()},
+		{"get_hits", s.hits.Load()},
+		{"get_misses", s.gets.Load() - s.hits.Load()},
+		{"curr_items", int64(s.c.ItemCount())},
+	}
+	fmt.Fprintf(mc.w, "STAT version %s\r\n", memcacheVersion)
+	for _, st := range stats {
+		fmt.Fprintf(mc.w, "STAT %s %d\r\n", st.name, st.value)
+	}
+	mc.w.WriteString("END\r\n")
+}
@@ -0,0 +1,232 @@
+package server
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	cache "github.com/wyyadd/go-cache"
+)
+
+type mcClient struct {
+	t    *testing.T
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+func startMemcache(t *testing.T, c *cache.Cache) (*MemcacheServer, *mcClient) {
+	t.Helper()
+	s := NewMemcacheServer(c)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("Couldn't listen:", err)
+	}
+	go s.Serve(l)
+	t.Cleanup(func() { s.Close() })
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal("Couldn't dial the server:", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return s, &mcClient{t: t, conn: conn, r: bufio.NewReader(conn)}
+}
+
+// do sends req and checks that the response is want. Both use \n for line
+// ends, which are sent and expected as \r\n.
+func (c *mcClient) do(req, want string) {
+	c.t.Helper()
+	crlf := func(s string) string { return strings.ReplaceAll(s, "\n", "\r\n") }
+	if _, err := io.WriteString(c.conn, crlf(req)); err != nil {
+		c.t.Fatal("Couldn't send the request:", err)
+	}
+	want = crlf(want)
+	c.conn.SetReadDeadline(time.Now().Add(time.Second))
+	got := make([]byte, len(want))
+	n, err := io.ReadFull(c.r, got)
+	if err != nil {
+		c.t.Errorf("%q: got %q, want %q: %v", req, got[:n], want, err)
+	}
+	if string(got) != want {
+		c.t.Errorf("%q: got %q, want %q", req, got, want)
+	}
+}
+
+// line sends req and returns the first line of the response.
+func (c *mcClient) line(req string) string {
+	c.t.Helper()
+	io.WriteString(c.conn, strings.ReplaceAll(req, "\n", "\r\n"))
+	c.conn.SetReadDeadline(time.Now().Add(time.Second))
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		c.t.Fatalf("%q: %v", req, err)
+	}
+	return strings.TrimSuffix(line, "\r\n")
+}
+
+func TestMemcacheServer(t *testing.T) {
+	_, c := startMemcache(t, cache.New(cache.NoExpiration, 0, cache.NewConcurrentMap()))
+
+	c.do("get foo\n", "END\n")
+	c.do("set foo 0 0 3\nbar\n", "STORED\n")
+	c.do("get foo\n", "VALUE foo 0 3\nbar\nEND\n")
+	c.do("set flagged 42 0 2\nhi\n", "STORED\n")
+	c.do("get foo missing flagged\n", "VALUE foo 0 3\nbar\nVALUE flagged 42 2\nhi\nEND\n")
+	c.do("set empty 0 0 0\n\n", "STORED\n")
+	c.do("get empty\n", "VALUE empty 0 0\n\nEND\n")
+
+	c.do("add foo 0 0 1\nx\n", "NOT_STORED\n")
+	c.do("add new 0 0 1\nx\n", "STORED\n")
+	c.do("replace missing 0 0 1\nx\n", "NOT_STORED\n")
+	c.do("replace new 0 0 1\ny\n", "STORED\n")
+	c.do("get new\n", "VALUE new 0 1\ny\nEND\n")
+
+	c.do("append flagged 0 0 3\n!!!\n", "STORED\n")
+	c.do("prepend flagged 0 0 3\n<<<\n", "STORED\n")
+	c.do("get flagged\n", "VALUE flagged 42 8\n<<<hi!!!\nEND\n")
+	c.do("append missing 0 0 1\nx\n", "NOT_STORED\n")
+
+	c.do("incr missing 1\n", "NOT_FOUND\n")
+	c.do("set n 0 0 2\n10\n", "STORED\n")
+	c.do("incr n 5\n", "15\n")
+	c.do("decr n 100\n", "0\n")
+	c.do("set n 0 0 20\n18446744073709551615\n", "STORED\n")
+	c.do("incr n 2\n", "1\n")
+	c.do("incr foo 1\n", "CLIENT_ERROR cannot increment or decrement non-numeric value\n")
+	c.do("incr n abc\n", "CLIENT_ERROR invalid numeric delta argument\n")
+
+	c.do("delete foo\n", "DELETED\n")
+	c.do("delete foo\n", "NOT_FOUND\n")
+	c.do("delete new 0\n", "DELETED\n")
+	c.do("touch n 100\n", "TOUCHED\n")
+	c.do("touch missing 100\n", "NOT_FOUND\n")
+
+	c.do("set quiet 0 0 1 noreply\nq\nget quiet\n", "VALUE quiet 0 1\nq\nEND\n")
+	c.do("delete quiet noreply\nget quiet\n", "END\n")
+
+	c.do("bogus\n", "ERROR\n")
+	c.do("set foo 0 0\n", errBadFormat+"\n")
+	c.do("get "+strings.Repeat("k", 251)+"\n", errBadFormat+"\n")
+	c.do("version\n", "VERSION "+memcacheVersion+"\n")
+	c.do("verbosity 1\n", "OK\n")
+	c.do("flush_all\n", "OK\n")
+	c.do("get n flagged\n", "END\n")
+}
+
+func TestMemcacheServerCAS(t *testing.T) {
+	_, c := startMemcache(t, cache.New(cache.NoExpiration, 0, cache.NewSyncMap()))
+	c.do("cas foo 0 0 1 1\nx\n", "NOT_FOUND\n")
+	c.do("cas foo 0 0 1 0\nx\n", "NOT_FOUND\n")
+	c.do("get foo\n", "END\n")
+	c.do("set foo 0 0 3\nbar\n", "STORED\n")
+	value := regexp.MustCompile(`^VALUE foo 0 3 (\d+)$`)
+	m := value.FindStringSubmatch(c.line("gets foo\n"))
+	if m == nil {
+		t.Fatal("gets did not return a cas unique")
+	}
+	c.do("", "bar\nEND\n")
+	unique, _ := strconv.ParseUint(m[1], 10, 64)
+	c.do("cas foo 0 0 3 "+strconv.FormatUint(unique+1, 10)+"\nbaz\n", "EXISTS\n")
+	c.do("cas foo 0 0 3 "+m[1]+"\nbaz\n", "STORED\n")
+	c.do("cas foo 0 0 3 "+m[1]+"\nqux\n", "EXISTS\n")
+	c.do("get foo\n", "VALUE foo 0 3\nbaz\nEND\n")
+}
+
+func TestMemcacheServerExptime(t *testing.T) {
+	tc := cache.New(cache.NoExpiration, 0, cache.NewRwmMap())
+	_, c := startMemcache(t, tc)
+
+	c.do("set never 0 0 1\nx\n", "STORED\n")
+	c.do("set relative 0 100 1\nx\n", "STORED\n")
+	c.do("set absolute 0 "+strconv.FormatInt(time.Now().Add(time.Hour).Unix(), 10)+" 1\nx\n", "STORED\n")
+	c.do("set negative 0 -1 1\nx\n", "STORED\n")
+	c.do("set past 0 "+strconv.FormatInt(time.Now().Add(-time.Hour).Unix(), 10)+" 1\nx\n", "STORED\n")
+	c.do("get negative past\n", "END\n")
+
+	if _, e, _ := tc.GetWithExpiration("never"); !e.IsZero() {
+		t.Error("exptime 0 set an expiration:", e)
+	}
+	if _, e, _ := tc.GetWithExpiration("relative"); time.Until(e) <= 99*time.Second || time.Until(e) > 100*time.Second {
+		t.Error("exptime 100 did not expire in 100 seconds:", e)
+	}
+	if _, e, _ := tc.GetWithExpiration("absolute"); time.Until(e) <= 59*time.Minute || time.Until(e) > time.Hour {
+		t.Error("an absolute exptime did not expire at that time:", e)
+	}
+
+	c.do("touch never 100\n", "TOUCHED\n")
+	if _, e, _ := tc.GetWithExpiration("never"); e.IsZero() {
+		t.Error("touch did not set an expiration")
+	}
+	c.do("incr relative 1\n", "CLIENT_ERROR cannot increment or decrement non-numeric value\n")
+	c.do("set relative 0 100 1\n1\n", "STORED\n")
+	c.do("incr relative 1\n", "2\n")
+	if _, e, _ := tc.GetWithExpiration("relative"); e.IsZero() {
+		t.Error("incr did not keep the expiration")
+	}
+	c.do("touch never -1\n", "TOUCHED\n")
+	c.do("get never\n", "END\n")
+}
+
+func TestMemcacheServerSharesCache(t *testing.T) {
+	tc := cache.New(cache.NoExpiration, 0, cache.NewRwmMap())
+	tc.Set("int", 41, cache.NoExpiration)
+	_, c := startMemcache(t, tc)
+	c.do("get int\n", "VALUE int 0 2\n41\nEND\n")
+	c.do("incr int 1\n", "42\n")
+	if x, _ := tc.Get("int"); x != 42 {
+		t.Errorf("incr stored %#v, want int 42", x)
+	}
+	c.do("set flagged 7 0 2\nhi\n", "STORED\n")
+	if x, _ := tc.Get("flagged"); x != (MemcacheValue{Flags: 7, Value: "hi"}) {
+		t.Errorf("set with flags stored %#v", x)
+	}
+}
+
+func TestMemcacheServerStats(t *testing.T) {
+	_, c := startMemcache(t, cache.New(cache.NoExpiration, 0, cache.NewRwmMap()))
+	c.do("set foo 0 0 1\nx\nget foo missing\n", "STORED\nVALUE foo 0 1\nx\nEND\n")
+	stats := map[string]string{}
+	io.WriteString(c.conn, "stats\r\n")
+	for {
+		line := c.line("")
+		if line == "END" {
+			break
+		}
+		f := strings.Fields(line)
+		if len(f) != 3 || f[0] != "STAT" {
+			t.Fatalf("malformed stats line %q", line)
+		}
+		stats[f[1]] = f[2]
+	}
+	want := map[string]string{"cmd_get": "2", "get_hits": "1", "get_misses": "1", "cmd_set": "1", "curr_items": "1", "curr_connections": "1"}
+	for k, v := range want {
+		if stats[k] != v {
+			t.Errorf("stat %s is %q, want %q", k, stats[k], v)
+		}
+	}
+}
+
+func TestMemcacheServerProtocolErrors(t *testing.T) {
+	_, c := startMemcache(t, cache.New(cache.NoExpiration, 0, cache.NewRwmMap()))
+	big := strings.Repeat("x", maxMemcacheValue+1)
+	c.do("set big 0 0 "+strconv.Itoa(len(big))+"\n"+big+"\nget big\n", "SERVER_ERROR object too large for cache\nEND\n")
+	c.do("set foo 0 0 1\nxyz\n", "CLIENT_ERROR bad data chunk\n")
+	c.conn.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := c.r.ReadByte(); err != io.EOF {
+		t.Error("connection was not closed after a bad data chunk:", err)
+	}
+}
+
+func TestMemcacheServerQuit(t *testing.T) {
+	s, c := startMemcache(t, cache.New(cache.NoExpiration, 0, cache.NewRwmMap()))
+	io.WriteString(c.conn, "quit\r\n")
+	c.conn.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := c.r.ReadByte(); err != io.EOF {
+		t.Error("connection was not closed after quit:", err)
+	}
+	s.Close()
+}
@@ -0,0 +1,150 @@
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	cache "github.com/wyyadd/go-cache"
+)
+
+// A StatsSource is a cache whose Stats can be exported as metrics, such as a
+// Cache or an LRUCache.
+type StatsSource interface {
+	Stats() cache.Stats
+}
+
+// MetricsOptions configure a MetricsHandler.
+type MetricsOptions struct {
+	// The prefix of the metric names. The default is "gocache".
+	Namespace string
+	// Labels added to every metric, such as the instance's region.
+	Labels map[string]string
+}
+
+// A MetricsHandler serves the Stats of a set of named caches in the
+// Prometheus text exposition format, for Prometheus to scrape:
+//
+//	<namespace>_hits_total             lookups that found an item
+//	<namespace>_misses_total           lookups that didn't
+//	<namespace>_expirations_total      items removed because they expired
+//	<namespace>_evictions_total        items removed to make room for others
+//	<namespace>_items                  the number of items
+//	<namespace>_janitor_runs_total     sweeps for expired items
+//	<namespace>_janitor_seconds_total  the time spent in sweeps
+//
+// Each metric has a cache label with the name a cache was registered under,
+// and the labels of the handler's MetricsOptions.
+type MetricsHandler struct {
+	namespace string
+	labels    string
+	mu        sync.Mutex
+	caches    map[string]StatsSource
+}
+
+var (
+	metricNameRE = regexp.MustCompile(`^[a-zA-Z_:][a-zA-Z0-9_:]*$`)
+	labelNameRE  = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+)
+
+// NewMetricsHandler returns a MetricsHandler without caches. It panics if the
+// namespace or a label name is not valid in Prometheus, or a label is named
+// cache.
+func NewMetricsHandler(opts MetricsOptions) *MetricsHandler {
+	if opts.Namespace == "" {
+		opts.Namespace = "gocache"
+	}
+	if !metricNameRE.MatchString(opts.Namespace) {
+		panic("server: invalid metric namespace " + strconv.Quote(opts.Namespace))
+	}
+	names := make([]string, 0, len(opts.Labels))
+	for name := range opts.Labels {
+		if !labelNameRE.MatchString(name) || strings.HasPrefix(name, "__") || name == "cache" {
+			panic("server: invalid metric label name " + strconv.Quote(name))
+		}
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	var labels strings.Builder
+	for _, name := range names {
+		fmt.Fprintf(&labels, ",%s=%s", name, quoteLabel(opts.Labels[name]))
+	}
+	return &MetricsHandler{namespace: opts.Namespace, labels: labels.String(), caches: map[string]StatsSource{}}
+}
+
+// Register adds the metrics of c, labeled with name. It panics if a cache is
+// already registered under name.
+func (h *MetricsHandler) Register(name string, c StatsSource) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, ok := h.caches[name]; ok {
+		panic("server: metrics of a cache named " + strconv.Quote(name) + " are already registered")
+	}
+	h.caches[name] = c
+}
+
+// Unregister removes the metrics of the cache registered under name.
+func (h *MetricsHandler) Unregister(name string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.caches, name)
+}
+
+// metrics are the exported metrics, in the order they are written.
+var metrics = []struct {
+	name, typ, help string
+	value           func(st cache.Stats) string
+}{
+	{"hits_total", "counter", "Lookups of a key that was found and had not expired.",
+		func(st cache.Stats) string { return strconv.FormatUint(st.Hits, 10) }},
+	{"misses_total", "counter", "Lookups of a key that was not found or had expired.",
+		func(st cache.Stats) string { return strconv.FormatUint(st.Misses, 10) }},
+	{"expirations_total", "counter", "Items removed because they expired.",
+		func(st cache.Stats) string { return strconv.FormatUint(st.Expirations, 10) }},
+	{"evictions_total", "counter", "Items removed to make room for others.",
+		func(st cache.Stats) string { return strconv.FormatUint(st.Evictions, 10) }},
+	{"items", "gauge", "Items in the cache, including expired items not yet removed.",
+		func(st cache.Stats) string { return strconv.Itoa(st.Items) }},
+	{"janitor_runs_total", "counter", "Sweeps for expired items.",
+		func(st cache.Stats) string { return strconv.FormatUint(st.JanitorRuns, 10) }},
+	{"janitor_seconds_total", "counter", "Time spent in sweeps for expired items.",
+		func(st cache.Stats) string { return strconv.FormatFloat(st.JanitorTime.Seconds(), 'g', -1, 64) }},
+}
+
+func (h *MetricsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.mu.Lock()
+	names := make([]string, 0, len(h.caches))
+	for name := range h.caches {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	stats := make([]cache.Stats, len(names))
+	for i, name := range names {
+		stats[i] = h.caches[name].Stats()
+	}
+	h.mu.Unlock()
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	bw := bufio.NewWriter(w)
+	for _, m := range metrics {
+		name := h.namespace + "_" + m.name
+		fmt.Fprintf(bw, "# HELP %s %s\n# TYPE %s %s\n", name, m.help, name, m.typ)
+		for i, st := range stats {
+			fmt.Fprintf(bw, "%s{cache=%s%s} %s\n", name, quoteLabel(names[i]), h.labels, m.value(st))
+		}
+	}
+	bw.Flush()
+}
+
+// labelEscaper escapes label values as the text exposition format requires.
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// quoteLabel returns the label value v, escaped and in double quotes.
+func quoteLabel(v string) string {
+	return `"` + labelEscaper.Replace(v) + `"`
+}
@@ -0,0 +1,88 @@
+package server
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	cache "github.com/wyyadd/go-cache"
+)
+
+func TestMetricsHandler(t *testing.T) {
+	tc := cache.New(cache.NoExpiration, 0, cache.NewConcurrentMap())
+	tc.Set("a", 1, cache.NoExpiration)
+	tc.Get("a")
+	tc.Get("b")
+	lru := cache.NewLRUCache(1, time.Minute, time.Minute)
+	defer lru.Close()
+	lru.Set("a", 1, cache.DefaultExpiration)
+	lru.Set("b", 2, cache.DefaultExpiration)
+
+	h := NewMetricsHandler(MetricsOptions{Namespace: "app", Labels: map[string]string{"region": "eu"}})
+	h.Register("users", tc)
+	h.Register(`"lru"`, lru)
+	h.Register("gone", tc)
+	h.Unregister("gone")
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Error("Content-Type is", ct)
+	}
+	body := rec.Body.String()
+	for _, want := range []string{
+		"# HELP app_hits_total Lookups of a key that was found and had not expired.\n# TYPE app_hits_total counter\n",
+		`app_hits_total{cache="\"lru\"",region="eu"} 0` + "\n",
+		`app_hits_total{cache="users",region="eu"} 1` + "\n",
+		`app_misses_total{cache="users",region="eu"} 1` + "\n",
+		`app_evictions_total{cache="\"lru\"",region="eu"} 1` + "\n",
+		"# TYPE app_items gauge\n",
+		`app_items{cache="\"lru\"",region="eu"} 1` + "\n",
+		`app_items{cache="users",region="eu"} 1` + "\n",
+		`app_janitor_seconds_total{cache="users",region="eu"} 0` + "\n",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("the metrics don't include %q:\n%s", want, body)
+		}
+	}
+	if strings.Contains(body, "gone") {
+		t.Error("the metrics include an unregistered cache:\n", body)
+	}
+	if n := strings.Count(body, "\n"); n != 7*4 {
+		t.Errorf("the metrics have %d lines, want %d", n, 7*4)
+	}
+}
+
+func TestMetricsHandlerDefaults(t *testing.T) {
+	h := NewMetricsHandler(MetricsOptions{})
+	h.Register("c", cache.New(cache.NoExpiration, 0, cache.NewConcurrentMap()))
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if !strings.Contains(rec.Body.String(), `gocache_items{cache="c"} 0`) {
+		t.Error("the metrics don't use the default namespace:\n", rec.Body.String())
+	}
+
+	for _, opts := range []MetricsOptions{
+		{Namespace: "1app"},
+		{Labels: map[string]string{"cache": "x"}},
+		{Labels: map[string]string{"bad-name": "x"}},
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("NewMetricsHandler(%+v) did not panic", opts)
+				}
+			}()
+			NewMetricsHandler(opts)
+		}()
+	}
+	lru := cache.NewLRUCache(1, time.Minute, time.Minute)
+	defer lru.Close()
+	defer func() {
+		if recover() == nil {
+			t.Error("registering a name twice did not panic")
+		}
+	}()
+	h.Register("c", lru)
+}
@@ -0,0 +1,871 @@
+package server
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	cache "github.com/wyyadd/go-cache"
+)
+
+// respVersion is reported as the server's version by HELLO and INFO. It is
+// the version of Redis whose commands are emulated, which some clients check
+// before using a command.
+const respVersion = "7.0.0"
+
+const (
+	// The largest bulk string, the most elements of a command and the
+	// longest line accepted, as in Redis.
+	maxBulkLen    = 512 << 20
+	maxArrayLen   = 1 << 20
+	maxInlineLine = 64 << 10
+)
+
+// RESPServer serves a Cache over the Redis serialization protocol, so that
+// Redis clients can use it as a single Redis database. It speaks RESP2, and
+// RESP3 to clients that switch to it with HELLO 3.
+//
+// The supported commands are PING, ECHO, QUIT, HELLO, SELECT 0, COMMAND, GET,
+// SET (with EX, PX, NX, XX and KEEPTTL), DEL, EXISTS, EXPIRE, PEXPIRE, TTL,
+// PTTL, PERSIST, INCR, DECR, INCRBY, DECRBY, MGET, MSET, KEYS, SCAN, FLUSHDB,
+// FLUSHALL, DBSIZE and INFO. Values are stored in the cache as strings; the
+// values of items set over the memcached protocol are read as they were set,
+// values of other types stored by Go code are formatted with fmt.Sprint, and
+// integers of type int or int64 can be incremented.
+type RESPServer struct {
+	c     *cache.Cache
+	start time.Time
+	t     tracker
+
+	clients     atomic.Int64
+	connections atomic.Int64
+	commands    atomic.Int64
+
+	scans scans
+}
+
+// NewRESPServer returns a RESPServer for c.
+func NewRESPServer(c *cache.Cache) *RESPServer {
+	return &RESPServer{c: c, start: time.Now()}
+}
+
+// Serve accepts connections on l and serves each in its own goroutine. It
+// returns when Accept fails; after Close it returns ErrServerClosed.
+func (s *RESPServer) Serve(l net.Listener) error {
+	return s.t.serve(l, s.ServeConn)
+}
+
+// ServeConn serves the client on conn until it quits, the connection fails
+// or the server is closed, and then closes conn.
+func (s *RESPServer) ServeConn(conn net.Conn) error {
+	defer conn.Close()
+	if !s.t.add(conn) {
+		return ErrServerClosed
+	}
+	defer s.t.remove(conn)
+	s.connections.Add(1)
+	s.clients.Add(1)
+	defer s.clients.Add(-1)
+
+	rc := &respConn{
+		s:     s,
+		r:     bufio.NewReaderSize(conn, maxInlineLine),
+		w:     bufio.NewWriter(conn),
+		proto: 2,
+	}
+	for {
+		args, err := rc.readCommand()
+		if err != nil {
+			var perr protocolError
+			if errors.As(err, &perr) {
+				rc.writeError("ERR Protocol error: " + string(perr))
+				rc.w.Flush()
+			}
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		if len(args) == 0 {
+			continue
+		}
+		s.commands.Add(1)
+		quit := rc.exec(args)
+		if quit || rc.r.Buffered() == 0 {
+			if err := rc.w.Flush(); err != nil {
+				return err
+			}
+		}
+		if quit {
+			return nil
+		}
+	}
+}
+
+// Close stops accepting connections and closes those open.
+func (s *RESPServer) Close() error {
+	s.t.close()
+	return nil
+}
+
+type protocolError string
+
+func (e protocolError) Error() string {
+	return "protocol error: " + string(e)
+}
+
+// respConn is the state of a client connection.
+type respConn struct {
+	s     *RESPServer
+	r     *bufio.Reader
+	w     *bufio.Writer
+	proto int
+}
+
+// readCommand reads a command, either as an array of bulk strings or as an
+// inline command line.
+func (rc *respConn) readCommand() ([]string, error) {
+	line, err := rc.readLine()
+	if err != nil {
+		return nil, err
+	}
+	if len(line) == 0 || line[0] != '*' {
+		return strings.Fields(line), nil
+	}
+	n, err := strconv.Atoi(line[1:])
+	if err != nil || n > maxArrayLen {
+		return nil, protocolError("invalid multibulk length")
+	}
+	args := make([]string, 0, max(n, 0))
+	for i := 0; i < n; i++ {
+		line, err := rc.readLine()
+		if err != nil {
+			return nil, err
+		}
+		if len(line) == 0 || line[0] != '$' {
+			return nil, protocolError(fmt.Sprintf("expected '$', got '%.1s'", line))
+		}
+		size, err := strconv.Atoi(line[1:])
+		if err != nil || size < 0 || size > maxBulkLen {
+			return nil, protocolError("invalid bulk length")
+		}
+		b := make([]byte, size+2)
+		if _, err := io.ReadFull(rc.r, b); err != nil {
+			return nil, err
+		}
+		if b[size] != '\r' || b[size+1] != '\n' {
+			return nil, protocolError("bulk string not terminated by CRLF")
+		}
+		args = append(args, string(b[:size]))
+	}
+	return args, nil
+}
+
+// readLine reads a line terminated by CRLF, or by LF for inline commands.
+func (rc *respConn) readLine() (string, error) {
+	b, err := rc.r.ReadSlice('\n')
+	if err != nil {
+		if err == bufio.ErrBufferFull {
+			return "", protocolError("too big inline request")
+		}
+		if err == io.EOF && len(b) > 0 {
+			err = io.ErrUnexpectedEOF
+		}
+		return "", err
+	}
+	line := strings.TrimSuffix(string(b[:len(b)-1]), "\r")
+	return line, nil
+}
+
+func (rc *respConn) writeSimple(s string) {
+	rc.w.WriteByte('+')
+	rc.w.WriteString(s)
+	rc.w.WriteString("\r\n")
+}
+
+func (rc *respConn) writeError(s string) {
+	rc.w.WriteByte('-')
+	rc.w.WriteString(s)
+	rc.w.WriteString("\r\n")
+}
+
+func (rc *respConn) writeInt(n int64) {
+	rc.w.WriteByte(':')
+	rc.w.WriteString(strconv.FormatInt(n, 10))
+	rc.w.WriteString("\r\n")
+}
+
+func (rc *respConn) writeBulk(s string) {
+	rc.w.WriteByte('$')
+	rc.w.WriteString(strconv.Itoa(len(s)))
+	rc.w.WriteString("\r\n")
+	rc.w.WriteString(s)
+	rc.w.WriteString("\r\n")
+}
+
+func (rc *respConn) writeNull() {
+	if rc.proto == 3 {
+		rc.w.WriteString("_\r\n")
+	} else {
+		rc.w.WriteString("$-1\r\n")
+	}
+}
+
+func (rc *respConn) writeArray(n int) {
+	rc.w.WriteByte('*')
+	rc.w.WriteString(strconv.Itoa(n))
+	rc.w.WriteString("\r\n")
+}
+
+// writeMap starts a map of n pairs, which is an array of 2n elements in
+// RESP2.
+func (rc *respConn) writeMap(n int) {
+	if rc.proto == 3 {
+		rc.w.WriteByte('%')
+		rc.w.WriteString(strconv.Itoa(n))
+		rc.w.WriteString("\r\n")
+	} else {
+		rc.writeArray(2 * n)
+	}
+}
+
+// writeValue writes a value stored in the cache as a bulk string.
+func (rc *respConn) writeValue(x interface{}) {
+	switch x := x.(type) {
+	case string:
+		rc.writeBulk(x)
+	case []byte:
+		rc.writeBulk(string(x))
+	case MemcacheValue:
+		rc.writeBulk(x.Value)
+	default:
+		rc.writeBulk(fmt.Sprint(x))
+	}
+}
+
+type respCommand struct {
+	f func(rc *respConn, args []string) bool
+	// The number of arguments, including the command name, or its negation
+	// for the least number of arguments of a command taking a variable
+	// number.
+	arity int
+}
+
+var respCommands = map[string]respCommand{
+	"PING":     {(*respConn).ping, -1},
+	"ECHO":     {(*respConn).echo, 2},
+	"QUIT":     {(*respConn).quit, 1},
+	"HELLO":    {(*respConn).hello, -1},
+	"SELECT":   {(*respConn).selectDB, 2},
+	"COMMAND":  {(*respConn).command, -1},
+	"GET":      {(*respConn).get, 2},
+	"SET":      {(*respConn).set, -3},
+	"DEL":      {(*respConn).del, -2},
+	"EXISTS":   {(*respConn).exists, -2},
+	"EXPIRE":   {(*respConn).expire, 3},
+	"PEXPIRE":  {(*respConn).expire, 3},
+	"TTL":      {(*respConn).ttl, 2},
+	"PTTL":     {(*respConn).ttl, 2},
+	"PERSIST":  {(*respConn).persist, 2},
+	"INCR":     {(*respConn).incr, 2},
+	"DECR":     {(*respConn).incr, 2},
+	"INCRBY":   {(*respConn).incr, 3},
+	"DECRBY":   {(*respConn).incr, 3},
+	"MGET":     {(*respConn).mget, -2},
+	"MSET":     {(*respConn).mset, -3},
+	"KEYS":     {(*respConn).keys, 2},
+	"SCAN":     {(*respConn).scan, -2},
+	"FLUSHDB":  {(*respConn).flush, -1},
+	"FLUSHALL": {(*respConn).flush, -1},
+	"DBSIZE":   {(*respConn).dbsize, 1},
+	"INFO":     {(*respConn).info, -1},
+}
+
+// exec runs a command and reports whether the connection should be closed.
+func (rc *respConn) exec(args []string) bool {
+	name := strings.ToUpper(args[0])
+	cmd, ok := respCommands[name]
+	if !ok {
+		rc.writeError(fmt.Sprintf("ERR unknown command '%s'", args[0]))
+		return false
+	}
+	if (cmd.arity > 0 && len(args) != cmd.arity) || (cmd.arity < 0 && len(args) < -cmd.arity) {
+		rc.writeError(fmt.Sprintf("ERR wrong number of arguments for '%s' command", strings.ToLower(name)))
+		return false
+	}
+	args[0] = name
+	return cmd.f(rc, args)
+}
+
+const (
+	errNotInteger = "ERR value is not an integer or out of range"
+	errSyntax     = "ERR syntax error"
+)
+
+func (rc *respConn) ping(args []string) bool {
+	switch len(args) {
+	case 1:
+		rc.writeSimple("PONG")
+	case 2:
+		rc.writeBulk(args[1])
+	default:
+		rc.writeError("ERR wrong number of arguments for 'ping' command")
+	}
+	return false
+}
+
+func (rc *respConn) echo(args []string) bool {
+	rc.writeBulk(args[1])
+	return false
+}
+
+func (rc *respConn) quit(args []string) bool {
+	rc.writeSimple("OK")
+	return true
+}
+
+func (rc *respConn) hello(args []string) bool {
+	if len(args) > 1 {
+		proto, err := strconv.Atoi(args[1])
+		if err != nil {
+			rc.writeError("ERR Protocol version is not an integer or out of range")
+			return false
+		}
+		if proto != 2 && proto != 3 {
+			rc.writeError("NOPROTO unsupported protocol version")
+			return false
+		}
+		rc.proto = proto
+	}
+	rc.writeMap(7)
+	rc.writeBulk("server")
+	rc.writeBulk("go-cache")
+	rc.writeBulk("version")
+	rc.writeBulk(respVersion)
+	rc.writeBulk("proto")
+	rc.writeInt(int64(rc.proto))
+	rc.writeBulk("id")
+	rc.writeInt(rc.s.connections.Load())
+	rc.writeBulk("mode")
+	rc.writeBulk("standalone")
+	rc.writeBulk("role")
+	rc.writeBulk("master")
+	rc.writeBulk("modules")
+	rc.writeArray(0)
+	return false
+}
+
+func (rc *respConn) selectDB(args []string) bool {
+	if args[1] != "0" {
+		rc.writeError("ERR DB index is out of range")
+	} else {
+		rc.writeSimple("OK")
+	}
+	return false
+}
+
+// command answers COMMAND and its subcommands, which clients call to learn
+// about commands, with an empty list.
+func (rc *respConn) command(args []string) bool {
+	rc.writeArray(0)
+	return false
+}
+
+func (rc *respConn) get(args []string) bool {
+	if x, found := rc.s.c.Get(args[1]); found {
+		rc.writeValue(x)
+	} else {
+		rc.writeNull()
+	}
+	return false
+}
+
+func (rc *respConn) set(args []string) bool {
+	key, value := args[1], args[2]
+	d := cache.NoExpiration
+	var nx, xx, ttl bool
+	for i := 3; i < len(args); i++ {
+		switch opt := strings.ToUpper(args[i]); {
+		case opt == "NX" && !xx:
+			nx = true
+		case opt == "XX" && !nx:
+			xx = true
+		case opt == "KEEPTTL" && !ttl:
+			ttl = true
+			d = cache.KeepExpiration
+		case (opt == "EX" || opt == "PX") && !ttl && i+1 < len(args):
+			ttl = true
+			i++
+			n, err := strconv.ParseInt(args[i], 10, 64)
+			if err != nil {
+				rc.writeError(errNotInteger)
+				return false
+			}
+			unit := time.Second
+			if opt == "PX" {
+				unit = time.Millisecond
+			}
+			if n <= 0 || n > math.MaxInt64/int64(unit) {
+				rc.writeError("ERR invalid expire time in 'set' command")
+				return false
+			}
+			d = time.Duration(n) * unit
+		default:
+			rc.writeError(errSyntax)
+			return false
+		}
+	}
+	var ok bool
+	rc.s.c.Compute(key, func(old interface{}, found bool) (interface{}, time.Duration, cache.Op) {
+		ok = !(nx && found) && !(xx && !found)
+		if !ok {
+			return nil, 0, cache.OpKeep
+		}
+		return value, d, cache.OpSet
+	})
+	if ok {
+		rc.writeSimple("OK")
+	} else {
+		rc.writeNull()
+	}
+	return false
+}
+
+// remove deletes k and reports whether it was in the cache.
+func (rc *respConn) remove(k string) bool {
+	var deleted bool
+	rc.s.c.Compute(k, func(old interface{}, found bool) (interface{}, time.Duration, cache.Op) {
+		deleted = found
+		return nil, 0, cache.OpDelete
+	})
+	return deleted
+}
+
+func (rc *respConn) del(args []string) bool {
+	var n int64
+	for _, k := range args[1:] {
+		if rc.remove(k) {
+			n++
+		}
+	}
+	rc.writeInt(n)
+	return false
+}
+
+func (rc *respConn) exists(args []string) bool {
+	var n int64
+	for _, k := range args[1:] {
+		if _, found := rc.s.c.Get(k); found {
+			n++
+		}
+	}
+	rc.writeInt(n)
+	return false
+}
+
+func (rc *respConn) expire(args []string) bool {
+	n, err := strconv.ParseInt(args[2], 10, 64)
+	if err != nil {
+		rc.writeError(errNotInteger)
+		return false
+	}
+	unit := time.Second
+	if args[0] == "PEXPIRE" {
+		unit = time.Millisecond
+	}
+	if n > math.MaxInt64/int64(unit) {
+		rc.writeError(fmt.Sprintf("ERR invalid expire time in '%s' command", strings.ToLower(args[0])))
+		return false
+	}
+	if n <= 0 {
+		rc.writeInt(boolInt(rc.remove(args[1])))
+		return false
+	}
+	var set bool
+	rc.s.c.Compute(args[1], func(old interface{}, found bool) (interface{}, time.Duration, cache.Op) {
+		if set = found; !found {
+			return nil, 0, cache.OpKeep
+		}
+		return old, time.Duration(n) * unit, cache.OpSet
+	})
+	rc.writeInt(boolInt(set))
+	return false
+}
+
+// lookupExpiration returns the expiration time of an unexpired item.
+func (rc *respConn) lookupExpiration(k string) (time.Time, bool) {
+	_, e, found := rc.s.c.GetWithExpiration(k)
+	if !found || (!e.IsZero() && !e.After(time.Now())) {
+		return time.Time{}, false
+	}
+	return e, true
+}
+
+func (rc *respConn) ttl(args []string) bool {
+	e, found := rc.lookupExpiration(args[1])
+	switch {
+	case !found:
+		rc.writeInt(-2)
+	case e.IsZero():
+		rc.writeInt(-1)
+	case args[0] == "PTTL":
+		rc.writeInt(time.Until(e).Milliseconds())
+	default:
+		rc.writeInt((time.Until(e).Milliseconds() + 500) / 1000)
+	}
+	return false
+}
+
+func (rc *respConn) persist(args []string) bool {
+	e, found := rc.lookupExpiration(args[1])
+	if !found || e.IsZero() {
+		rc.writeInt(0)
+		return false
+	}
+	var set bool
+	rc.s.c.Compute(args[1], func(old interface{}, found bool) (interface{}, time.Duration, cache.Op) {
+		if set = found; !found {
+			return nil, 0, cache.OpKeep
+		}
+		return old, cache.NoExpiration, cache.OpSet
+	})
+	rc.writeInt(boolInt(set))
+	return false
+}
+
+func (rc *respConn) incr(args []string) bool {
+	delta := int64(1)
+	if len(args) == 3 {
+		n, err := strconv.ParseInt(args[2], 10, 64)
+		if err != nil {
+			rc.writeError(errNotInteger)
+			return false
+		}
+		delta = n
+	}
+	if args[0] == "DECR" || args[0] == "DECRBY" {
+		if delta == math.MinInt64 {
+			rc.writeError("ERR decrement would overflow")
+			return false
+		}
+		delta = -delta
+	}
+	var result int64
+	var errMsg string
+	rc.s.c.Compute(args[1], func(old interface{}, found bool) (interface{}, time.Duration, cache.Op) {
+		var n int64
+		var err error
+		if found {
+			n, err = integerOf(old)
+			if err != nil {
+				errMsg = errNotInteger
+				return nil, 0, cache.OpKeep
+			}
+		}
+		if (delta > 0 && n > math.MaxInt64-delta) || (delta < 0 && n < math.MinInt64-delta) {
+			errMsg = "ERR increment or decrement would overflow"
+			return nil, 0, cache.OpKeep
+		}
+		errMsg = ""
+		result = n + delta
+		switch old := old.(type) {
+		case int:
+			if int64(int(result)) == result {
+				return int(result), cache.KeepExpiration, cache.OpSet
+			}
+		case int64:
+			return result, cache.KeepExpiration, cache.OpSet
+		case MemcacheValue:
+			return MemcacheValue{Flags: old.Flags, Value: strconv.FormatInt(result, 10)}, cache.KeepExpiration, cache.OpSet
+		}
+		return strconv.FormatInt(result, 10), cache.KeepExpiration, cache.OpSet
+	})
+	if errMsg != "" {
+		rc.writeError(errMsg)
+	} else {
+		rc.writeInt(result)
+	}
+	return false
+}
+
+// integerOf returns the value of a cache value holding an integer.
+func integerOf(x interface{}) (int64, error) {
+	switch x := x.(type) {
+	case int:
+		return int64(x), nil
+	case int64:
+		return x, nil
+	case string:
+		return strconv.ParseInt(x, 10, 64)
+	case []byte:
+		return strconv.ParseInt(string(x), 10, 64)
+	case MemcacheValue:
+		return strconv.ParseInt(x.Value, 10, 64)
+	}
+	return 0, strconv.ErrSyntax
+}
+
+func boolInt(b bool) int64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func (rc *respConn) mget(args []string) bool {
+	values := rc.s.c.GetMulti(args[1:])
+	rc.writeArray(len(args) - 1)
+	for _, k := range args[1:] {
+		if x, found := values[k]; found {
+			rc.writeValue(x)
+		} else {
+			rc.writeNull()
+		}
+	}
+	return false
+}
+
+func (rc *respConn) mset(args []string) bool {
+	if len(args)%2 != 1 {
+		rc.writeError("ERR wrong number of arguments for 'mset' command")
+		return false
+	}
+	items := make(map[string]interface{}, len(args)/2)
+	for i := 1; i < len(args); i += 2 {
+		items[args[i]] = args[i+1]
+	}
+	rc.s.c.SetMulti(items, cache.NoExpiration)
+	rc.writeSimple("OK")
+	return false
+}
+
+// keys answers KEYS, which, as in Redis, walks the whole keyspace unless the
+// pattern has no wildcards and so names a single key.
+func (rc *respConn) keys(args []string) bool {
+	if k, ok := globLiteral(args[1]); ok {
+		if _, found := rc.s.c.Get(k); found {
+			rc.writeArray(1)
+			rc.writeBulk(k)
+		} else {
+			rc.writeArray(0)
+		}
+		return false
+	}
+	var keys []string
+	for k := range rc.s.c.Keys() {
+		if matchGlob(args[1], k) {
+			keys = append(keys, k)
+		}
+	}
+	rc.writeArray(len(keys))
+	for _, k := range keys {
+		rc.writeBulk(k)
+	}
+	return false
+}
+
+// scan answers SCAN from the keys the cache held when the scan began, which
+// the server keeps until it ends. The high 32 bits of a cursor are the id of
+// the scan and the low ones the position of the next key to return, so each
+// call after the first only looks at the keys it returns, and every key that
+// is in the cache for the whole of a scan is returned exactly once however
+// the cache changes. As in Redis, keys added or deleted during a scan may or
+// may not be returned. A cursor of a scan the server no longer keeps, because
+// it timed out or too many others were started, ends the scan.
+func (rc *respConn) scan(args []string) bool {
+	cursor, err := strconv.ParseUint(args[1], 10, 64)
+	if err != nil {
+		rc.writeError("ERR invalid cursor")
+		return false
+	}
+	pattern, count := "*", 10
+	for i := 2; i < len(args); i += 2 {
+		if i+1 == len(args) {
+			rc.writeError(errSyntax)
+			return false
+		}
+		switch strings.ToUpper(args[i]) {
+		case "MATCH":
+			pattern = args[i+1]
+		case "COUNT":
+			n, err := strconv.Atoi(args[i+1])
+			if err != nil {
+				rc.writeError(errNotInteger)
+				return false
+			}
+			if n < 1 {
+				rc.writeError(errSyntax)
+				return false
+			}
+			count = n
+		default:
+			rc.writeError(errSyntax)
+			return false
+		}
+	}
+
+	var keys []string
+	id, pos := uint32(cursor>>32), int(uint32(cursor))
+	if cursor == 0 {
+		for k := range rc.s.c.Keys() {
+			keys = append(keys, k)
+		}
+		if len(keys) > count {
+			id = rc.s.scans.start(keys)
+		}
+	} else {
+		var ok bool
+		if keys, ok = rc.s.scans.get(id); !ok || pos >= len(keys) {
+			keys, pos = nil, 0
+		}
+	}
+	n := min(pos+count, len(keys))
+	var next uint64
+	if n < len(keys) {
+		next = uint64(id)<<32 | uint64(n)
+	} else if id != 0 {
+		rc.s.scans.end(id)
+	}
+	var page []string
+	for _, k := range keys[pos:n] {
+		if matchGlob(pattern, k) {
+			page = append(page, k)
+		}
+	}
+	rc.writeArray(2)
+	rc.writeBulk(strconv.FormatUint(next, 10))
+	rc.writeArray(len(page))
+	for _, k := range page {
+		rc.writeBulk(k)
+	}
+	return false
+}
+
+const (
+	// The most scans a RESPServer keeps open, and how long it keeps one
+	// that is not continued.
+	maxScans    = 64
+	scanTimeout = 5 * time.Minute
+)
+
+// scans are the SCANs in progress on a RESPServer, by their ids.
+type scans struct {
+	mu   sync.Mutex
+	last uint32
+	open map[uint32]*scanState
+}
+
+// scanState holds the keys of a scan and when it was last continued.
+type scanState struct {
+	keys []string
+	used time.Time
+}
+
+// start opens a scan of keys and returns its id. It ends scans that have
+// timed out and, if there are still too many, the one continued longest ago.
+func (s *scans) start(keys []string) uint32 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.open == nil {
+		s.open = map[uint32]*scanState{}
+	}
+	now := time.Now()
+	var oldest uint32
+	for id, st := range s.open {
+		if now.Sub(st.used) > scanTimeout {
+			delete(s.open, id)
+		} else if oldest == 0 || st.used.Before(s.open[oldest].used) {
+			oldest = id
+		}
+	}
+	if len(s.open) >= maxScans {
+		delete(s.open, oldest)
+	}
+	for {
+		s.last++
+		if _, used := s.open[s.last]; s.last != 0 && !used {
+			break
+		}
+	}
+	s.open[s.last] = &scanState{keys: keys, used: now}
+	return s.last
+}
+
+// get returns the keys of the scan id, and false if it is not open.
+func (s *scans) get(id uint32) ([]string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	st, ok := s.open[id]
+	if !ok || time.Since(st.used) > scanTimeout {
+		delete(s.open, id)
+		return nil, false
+	}
+	st.used = time.Now()
+	return st.keys, true
+}
+
+func (s *scans) end(id uint32) {
+	s.mu.Lock()
+	delete(s.open, id)
+	s.mu.Unlock()
+}
+
+func (rc *respConn) flush(args []string) bool {
+	if len(args) > 2 || (len(args) == 2 && !strings.EqualFold(args[1], "SYNC") && !strings.EqualFold(args[1], "ASYNC")) {
+		rc.writeError(errSyntax)
+		return false
+	}
+	rc.s.c.Flush()
+	rc.writeSimple("OK")
+	return false
+}
+
+func (rc *respConn) dbsize(args []string) bool {
+	rc.writeInt(int64(rc.s.c.ItemCount()))
+	return false
+}
+
+func (rc *respConn) info(args []string) bool {
+	sections := map[string]bool{}
+	for _, a := range args[1:] {
+		sections[strings.ToLower(a)] = true
+	}
+	all := len(sections) == 0 || sections["all"] || sections["everything"] || sections["default"]
+	var b strings.Builder
+	section := func(name string, fields ...string) {
+		if !all && !sections[strings.ToLower(name)] {
+			return
+		}
+		if b.Len() > 0 {
+			b.WriteString("\r\n")
+		}
+		b.WriteString("# " + name + "\r\n")
+		for i := 0; i < len(fields); i += 2 {
+			b.WriteString(fields[i] + ":" + fields[i+1] + "\r\n")
+		}
+	}
+	s := rc.s
+	section("Server",
+		"redis_version", respVersion,
+		"redis_mode", "standalone",
+		"process_id", strconv.Itoa(os.Getpid()),
+		"uptime_in_seconds", strconv.FormatInt(int64(time.Since(s.start).Seconds()), 10))
+	section("Clients",
+		"connected_clients", strconv.FormatInt(s.clients.Load(), 10))
+	section("Stats",
+		"total_connections_received", strconv.FormatInt(s.connections.Load(), 10),
+		"total_commands_processed", strconv.FormatInt(s.commands.Load(), 10))
+	section("Keyspace",
+		"db0", "keys="+strconv.Itoa(s.c.ItemCount()))
+	rc.writeBulk(b.String())
+	return false
+}
@@ -0,0 +1,360 @@
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	cache "github.com/wyyadd/go-cache"
+)
+
+// respClient is a minimal RESP client. Replies are returned as string for
+// simple and bulk strings, int64 for integers, nil for nulls, []interface{}
+// for arrays, map[string]interface{} for maps and respError for errors.
+type respClient struct {
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+type respError string
+
+func dialRESP(t *testing.T, addr string) *respClient {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal("Couldn't dial the server:", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return &respClient{conn: conn, r: bufio.NewReader(conn)}
+}
+
+func (c *respClient) send(args ...string) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "*%d\r\n", len(args))
+	for _, a := range args {
+		fmt.Fprintf(&b, "$%d\r\n%s\r\n", len(a), a)
+	}
+	_, err := io.WriteString(c.conn, b.String())
+	return err
+}
+
+func (c *respClient) do(args ...string) (interface{}, error) {
+	if err := c.send(args...); err != nil {
+		return nil, err
+	}
+	return c.read()
+}
+
+func (c *respClient) read() (interface{}, error) {
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return respError(line[1:]), nil
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '_':
+		return nil, nil
+	case '$':
+		n, _ := strconv.Atoi(line[1:])
+		if n < 0 {
+			return nil, nil
+		}
+		b := make([]byte, n+2)
+		if _, err := io.ReadFull(c.r, b); err != nil {
+			return nil, err
+		}
+		return string(b[:n]), nil
+	case '*', '%':
+		n, _ := strconv.Atoi(line[1:])
+		if n < 0 {
+			return nil, nil
+		}
+		if line[0] == '%' {
+			m := map[string]interface{}{}
+			for i := 0; i < n; i++ {
+				k, err := c.read()
+				if err != nil {
+					return nil, err
+				}
+				v, err := c.read()
+				if err != nil {
+					return nil, err
+				}
+				m[k.(string)] = v
+			}
+			return m, nil
+		}
+		a := make([]interface{}, n)
+		for i := range a {
+			if a[i], err = c.read(); err != nil {
+				return nil, err
+			}
+		}
+		return a, nil
+	}
+	return nil, fmt.Errorf("unexpected reply %q", line)
+}
+
+func startRESP(t *testing.T, c *cache.Cache) (*RESPServer, string) {
+	t.Helper()
+	s := NewRESPServer(c)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("Couldn't listen:", err)
+	}
+	go s.Serve(l)
+	t.Cleanup(func() { s.Close() })
+	return s, l.Addr().String()
+}
+
+func TestRESPServer(t *testing.T) {
+	tc := cache.New(cache.NoExpiration, 0, cache.NewConcurrentMap())
+	_, addr := startRESP(t, tc)
+	c := dialRESP(t, addr)
+
+	tests := []struct {
+		args []string
+		want interface{}
+	}{
+		{[]string{"PING"}, "PONG"},
+		{[]string{"ping", "hi"}, "hi"},
+		{[]string{"ECHO", "hello"}, "hello"},
+		{[]string{"GET", "foo"}, nil},
+		{[]string{"SET", "foo", "bar"}, "OK"},
+		{[]string{"GET", "foo"}, "bar"},
+		{[]string{"SET", "foo", "baz", "NX"}, nil},
+		{[]string{"SET", "new", "baz", "XX"}, nil},
+		{[]string{"SET", "foo", "baz", "XX", "EX", "100"}, "OK"},
+		{[]string{"TTL", "foo"}, int64(100)},
+		{[]string{"SET", "foo", "qux", "KEEPTTL"}, "OK"},
+		{[]string{"TTL", "foo"}, int64(100)},
+		{[]string{"PERSIST", "foo"}, int64(1)},
+		{[]string{"PERSIST", "foo"}, int64(0)},
+		{[]string{"TTL", "foo"}, int64(-1)},
+		{[]string{"TTL", "missing"}, int64(-2)},
+		{[]string{"EXPIRE", "foo", "10"}, int64(1)},
+		{[]string{"EXPIRE", "missing", "10"}, int64(0)},
+		{[]string{"TTL", "foo"}, int64(10)},
+		{[]string{"SET", "px", "1", "PX", "100000"}, "OK"},
+		{[]string{"SET", "foo", "bar", "EX", "0"}, respError("ERR invalid expire time in 'set' command")},
+		{[]string{"SET", "foo", "bar", "NX", "XX"}, respError("ERR syntax error")},
+		{[]string{"SET", "foo", "bar", "EX"}, respError("ERR syntax error")},
+		{[]string{"INCR", "counter"}, int64(1)},
+		{[]string{"INCRBY", "counter", "10"}, int64(11)},
+		{[]string{"DECR", "counter"}, int64(10)},
+		{[]string{"DECRBY", "counter", "20"}, int64(-10)},
+		{[]string{"GET", "counter"}, "-10"},
+		{[]string{"INCR", "foo"}, respError("ERR value is not an integer or out of range")},
+		{[]string{"SET", "max", "9223372036854775807"}, "OK"},
+		{[]string{"INCR", "max"}, respError("ERR increment or decrement would overflow")},
+		{[]string{"MSET", "a", "1", "b", "2"}, "OK"},
+		{[]string{"MGET", "a", "missing", "b"}, []interface{}{"1", nil, "2"}},
+		{[]string{"MSET", "a"}, respError("ERR wrong number of arguments for 'mset' command")},
+		{[]string{"EXISTS", "a", "b", "a", "missing"}, int64(3)},
+		{[]string{"DEL", "a", "missing"}, int64(1)},
+		{[]string{"EXISTS", "a"}, int64(0)},
+		{[]string{"EXPIRE", "b", "0"}, int64(1)},
+		{[]string{"EXISTS", "b"}, int64(0)},
+		{[]string{"KEYS", "[cm]*"}, []interface{}{"counter", "max"}},
+		{[]string{"KEYS", "max"}, []interface{}{"max"}},
+		{[]string{"KEYS", "b"}, []interface{}{}},
+		{[]string{"DBSIZE"}, int64(4)},
+		{[]string{"SELECT", "0"}, "OK"},
+		{[]string{"SELECT", "1"}, respError("ERR DB index is out of range")},
+		{[]string{"GET"}, respError("ERR wrong number of arguments for 'get' command")},
+		{[]string{"NOSUCH"}, respError("ERR unknown command 'NOSUCH'")},
+		{[]string{"FLUSHDB"}, "OK"},
+		{[]string{"DBSIZE"}, int64(0)},
+	}
+	for _, tt := range tests {
+		got, err := c.do(tt.args...)
+		if err != nil {
+			t.Fatalf("%v failed: %v", tt.args, err)
+		}
+		if a, ok := got.([]interface{}); ok && tt.args[0] == "KEYS" {
+			sort.Slice(a, func(i, j int) bool { return a[i].(string) < a[j].(string) })
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%v returned %#v, want %#v", tt.args, got, tt.want)
+		}
+	}
+}
+
+func TestRESPServerSharesCache(t *testing.T) {
+	tc := cache.New(cache.NoExpiration, 0, cache.NewRwmMap())
+	tc.Set("int", 41, cache.NoExpiration)
+	tc.Set("bytes", []byte("raw"), cache.NoExpiration)
+	_, addr := startRESP(t, tc)
+	c := dialRESP(t, addr)
+
+	if got, _ := c.do("INCR", "int"); got != int64(42) {
+		t.Error("INCR of an int stored by Go code returned", got)
+	}
+	if x, _ := tc.Get("int"); x != 42 {
+		t.Errorf("INCR stored %#v, want int 42", x)
+	}
+	if got, _ := c.do("GET", "bytes"); got != "raw" {
+		t.Error("GET of a []byte returned", got)
+	}
+	c.do("SET", "fromresp", "v")
+	if x, _ := tc.Get("fromresp"); x != "v" {
+		t.Error("SET over RESP stored", x)
+	}
+}
+
+func TestRESPServerExpiry(t *testing.T) {
+	tc := cache.New(cache.NoExpiration, 0, cache.NewSyncMap())
+	_, addr := startRESP(t, tc)
+	c := dialRESP(t, addr)
+	c.do("SET", "short", "v", "PX", "20")
+	if got, _ := c.do("PTTL", "short"); got.(int64) <= 0 || got.(int64) > 20 {
+		t.Error("PTTL returned", got)
+	}
+	<-time.After(30 * time.Millisecond)
+	if got, _ := c.do("GET", "short"); got != nil {
+		t.Error("GET of an expired key returned", got)
+	}
+	if got, _ := c.do("PTTL", "short"); got != int64(-2) {
+		t.Error("PTTL of an expired key returned", got)
+	}
+	c.do("SET", "short", "1")
+	c.do("PEXPIRE", "short", "20")
+	if got, _ := c.do("INCR", "short"); got != int64(2) {
+		t.Error("INCR returned", got)
+	}
+	<-time.After(30 * time.Millisecond)
+	if got, _ := c.do("EXISTS", "short"); got != int64(0) {
+		t.Error("INCR did not keep the expiration set by PEXPIRE:", got)
+	}
+}
+
+func TestRESPServerScan(t *testing.T) {
+	tc := cache.New(cache.NoExpiration, 0, cache.NewConcurrentMap())
+	for i := 0; i < 100; i++ {
+		tc.Set("key"+strconv.Itoa(i), i, cache.NoExpiration)
+	}
+	_, addr := startRESP(t, tc)
+	c := dialRESP(t, addr)
+
+	seen := map[string]int{}
+	cursor := "0"
+	for i := 0; ; i++ {
+		reply, err := c.do("SCAN", cursor, "COUNT", "7", "MATCH", "key*")
+		if err != nil {
+			t.Fatal("SCAN failed:", err)
+		}
+		a := reply.([]interface{})
+		for _, k := range a[1].([]interface{}) {
+			seen[k.(string)]++
+		}
+		// Changes during a scan don't affect keys that are there throughout.
+		tc.Set("added"+strconv.Itoa(i), i, cache.NoExpiration)
+		if cursor = a[0].(string); cursor == "0" {
+			break
+		}
+	}
+	if len(seen) != 100 {
+		t.Error("SCAN returned", len(seen), "keys, want 100")
+	}
+	for k, n := range seen {
+		if n != 1 {
+			t.Error("SCAN returned", k, n, "times")
+		}
+	}
+	if got, _ := c.do("SCAN", "x"); got != respError("ERR invalid cursor") {
+		t.Error("SCAN with an invalid cursor returned", got)
+	}
+	// A cursor of a scan that has ended or was dropped ends the scan.
+	for _, cursor := range []string{"1", strconv.FormatUint(1<<32|7, 10)} {
+		if got, _ := c.do("SCAN", cursor); !reflect.DeepEqual(got, []interface{}{"0", []interface{}{}}) {
+			t.Errorf("SCAN with the stale cursor %s returned %v", cursor, got)
+		}
+	}
+}
+
+func TestRESPServerProtocol(t *testing.T) {
+	tc := cache.New(cache.NoExpiration, 0, cache.NewRwmMap())
+	s, addr := startRESP(t, tc)
+	c := dialRESP(t, addr)
+
+	// Inline commands.
+	io.WriteString(c.conn, "SET inline value\r\nGET inline\n")
+	if got, _ := c.read(); got != "OK" {
+		t.Error("inline SET returned", got)
+	}
+	if got, _ := c.read(); got != "value" {
+		t.Error("inline GET returned", got)
+	}
+
+	// Pipelined commands.
+	for i := 0; i < 10; i++ {
+		c.send("INCR", "pipelined")
+	}
+	for i := 1; i <= 10; i++ {
+		if got, _ := c.read(); got != int64(i) {
+			t.Errorf("pipelined INCR %d returned %v", i, got)
+		}
+	}
+
+	// RESP3.
+	reply, _ := c.do("HELLO", "3")
+	hello, ok := reply.(map[string]interface{})
+	if !ok || hello["proto"] != int64(3) || hello["server"] != "go-cache" {
+		t.Error("HELLO 3 returned", reply)
+	}
+	if got, _ := c.do("GET", "missing"); got != nil {
+		t.Error("GET of a missing key in RESP3 returned", got)
+	}
+	if got, _ := c.do("HELLO", "4"); got != respError("NOPROTO unsupported protocol version") {
+		t.Error("HELLO 4 returned", got)
+	}
+
+	info, _ := c.do("INFO", "keyspace")
+	if s := info.(string); !strings.Contains(s, "# Keyspace") || !strings.Contains(s, "db0:keys=2") || strings.Contains(s, "# Server") {
+		t.Errorf("INFO keyspace returned %q", s)
+	}
+
+	if got, _ := c.do("QUIT"); got != "OK" {
+		t.Error("QUIT returned", got)
+	}
+	if _, err := c.read(); err != io.EOF {
+		t.Error("connection was not closed after QUIT:", err)
+	}
+
+	c = dialRESP(t, addr)
+	io.WriteString(c.conn, "*1\r\n+PING\r\n")
+	if got, _ := c.read(); got != respError("ERR Protocol error: expected '$', got '+'") {
+		t.Error("malformed command returned", got)
+	}
+
+	c = dialRESP(t, addr)
+	io.WriteString(c.conn, strings.Repeat("a", maxInlineLine))
+	if got, _ := c.read(); got != respError("ERR Protocol error: too big inline request") {
+		t.Error("an overlong line returned", got)
+	}
+	if _, err := c.read(); err != io.EOF {
+		t.Error("connection was not closed after an overlong line:", err)
+	}
+
+	s.Close()
+	if _, err := c.do("PING"); err == nil {
+		t.Error("connection was not closed by Close")
+	}
+	if conn, err := net.Dial("tcp", addr); err == nil {
+		conn.Close()
+		t.Error("server accepted a connection after Close")
+	}
+}
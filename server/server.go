@@ -0,0 +1,79 @@
+// Package server exposes a cache.Cache to other processes over network
+// protocols.
+package server
+
+import (
+	"errors"
+	"io"
+	"net"
+	"sync"
+)
+
+// ErrServerClosed is returned by a server's Serve method after Close has been
+// called.
+var ErrServerClosed = errors.New("server: closed")
+
+// tracker keeps the listeners and connections of a server so that they can
+// be closed together.
+type tracker struct {
+	mu     sync.Mutex
+	open   map[io.Closer]struct{}
+	closed bool
+}
+
+// add starts tracking x and reports whether the server is open. x is not
+// tracked if it isn't.
+func (t *tracker) add(x io.Closer) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.closed {
+		return false
+	}
+	if t.open == nil {
+		t.open = map[io.Closer]struct{}{}
+	}
+	t.open[x] = struct{}{}
+	return true
+}
+
+func (t *tracker) remove(x io.Closer) {
+	t.mu.Lock()
+	delete(t.open, x)
+	t.mu.Unlock()
+}
+
+func (t *tracker) isClosed() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.closed
+}
+
+// close closes everything tracked and stops tracking anything new.
+func (t *tracker) close() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.closed = true
+	for x := range t.open {
+		x.Close()
+	}
+	t.open = nil
+}
+
+// serve accepts connections on l and calls serveConn for each in its own
+// goroutine, until Accept fails.
+func (t *tracker) serve(l net.Listener, serveConn func(net.Conn) error) error {
+	if !t.add(l) {
+		return ErrServerClosed
+	}
+	defer t.remove(l)
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if t.isClosed() {
+				return ErrServerClosed
+			}
+			return err
+		}
+		go serveConn(conn)
+	}
+}
@@ -0,0 +1,173 @@
+package cache
+
+import "reflect"
+
+// A Sizer is a value that reports its own size, for values whose size SizeOf
+// would estimate badly or slowly.
+type Sizer interface {
+	// Size returns the number of bytes of memory used by the value,
+	// including the memory it refers to.
+	Size() int64
+}
+
+// SizeOf estimates the number of bytes of memory used by x, including the
+// memory it refers to, such as the bytes of a string or the elements of a
+// slice. A Sizer reports its own size. Other values are measured with
+// reflection, which follows pointers, counting memory shared by several of
+// them once, and estimates the size of maps from their lengths. Channels and
+// functions count only for their headers.
+func SizeOf(x interface{}) int64 {
+	switch x := x.(type) {
+	case nil:
+		return 0
+	case Sizer:
+		return x.Size()
+	case string:
+		return int64(16 + len(x))
+	case []byte:
+		return int64(24 + cap(x))
+	}
+	v := reflect.ValueOf(x)
+	return int64(v.Type().Size()) + referenced(v, map[uintptr]bool{}, 0)
+}
+
+// maxSizeDepth is the deepest SizeOf looks into nested values.
+const maxSizeDepth = 64
+
+// mapEntryOverhead is the estimated number of bytes a map uses per entry,
+// besides the entry's key and value.
+const mapEntryOverhead = 16
+
+// referenced returns the number of bytes of memory referred to by v, besides
+// v itself, that aren't in seen.
+func referenced(v reflect.Value, seen map[uintptr]bool, depth int) int64 {
+	if depth > maxSizeDepth {
+		return 0
+	}
+	depth++
+	switch v.Kind() {
+	case reflect.String:
+		return int64(v.Len())
+	case reflect.Slice:
+		if v.IsNil() || seen[v.Pointer()] {
+			return 0
+		}
+		seen[v.Pointer()] = true
+		n := int64(v.Cap()) * int64(v.Type().Elem().Size())
+		if hasReferences(v.Type().Elem()) {
+			for i := 0; i < v.Len(); i++ {
+				n += referenced(v.Index(i), seen, depth)
+			}
+		}
+		return n
+	case reflect.Array:
+		var n int64
+		if hasReferences(v.Type().Elem()) {
+			for i := 0; i < v.Len(); i++ {
+				n += referenced(v.Index(i), seen, depth)
+			}
+		}
+		return n
+	case reflect.Map:
+		if v.IsNil() || seen[v.Pointer()] {
+			return 0
+		}
+		seen[v.Pointer()] = true
+		t := v.Type()
+		n := int64(v.Len()) * (int64(t.Key().Size()+t.Elem().Size()) + mapEntryOverhead)
+		if hasReferences(t.Key()) || hasReferences(t.Elem()) {
+			iter := v.MapRange()
+			for iter.Next() {
+				n += referenced(iter.Key(), seen, depth) + referenced(iter.Value(), seen, depth)
+			}
+		}
+		return n
+	case reflect.Pointer:
+		if v.IsNil() || seen[v.Pointer()] {
+			return 0
+		}
+		seen[v.Pointer()] = true
+		return int64(v.Type().Elem().Size()) + referenced(v.Elem(), seen, depth)
+	case reflect.Interface:
+		if v.IsNil() {
+			return 0
+		}
+		e := v.Elem()
+		return int64(e.Type().Size()) + referenced(e, seen, depth)
+	case reflect.Struct:
+		var n int64
+		for i := 0; i < v.NumField(); i++ {
+			n += referenced(v.Field(i), seen, depth)
+		}
+		return n
+	}
+	return 0
+}
+
+// hasReferences reports whether values of type t may refer to memory that
+// SizeOf counts.
+func hasReferences(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.String, reflect.Slice, reflect.Map, reflect.Pointer, reflect.Interface:
+		return true
+	case reflect.Array:
+		return hasReferences(t.Elem())
+	case reflect.Struct:
+		for i := 0; i < t.NumField(); i++ {
+			if hasReferences(t.Field(i).Type) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// MemoryUsage is an estimate of the memory used by a cache's items.
+type MemoryUsage struct {
+	Items int
+	// The bytes used by the items' keys and values, as estimated by SizeOf.
+	KeyBytes   int64
+	ValueBytes int64
+	// The bytes used by the cache to store the items, besides their keys
+	// and values.
+	OverheadBytes int64
+}
+
+// Total returns the total number of bytes used.
+func (m MemoryUsage) Total() int64 {
+	return m.KeyBytes + m.ValueBytes + m.OverheadBytes
+}
+
+// entrySizer is implemented by CacheMaps that know how much memory they use
+// for each entry, besides its key and value.
+type entrySizer interface {
+	EntryOverhead() int64
+}
+
+// defaultEntryOverhead is the overhead assumed for CacheMaps that don't
+// report theirs.
+const defaultEntryOverhead = 96
+
+// MemoryUsage estimates the memory used by the cache's items, including
+// expired items that have not yet been removed. It looks at every item, so
+// it takes time proportional to their number. Backends report their
+// overhead per item with an EntryOverhead method, as the backends of this
+// package do; for others, an overhead of 96 bytes is assumed.
+//
+// Unlike an LRUCache, a Cache can't be given a byte budget, as it keeps no
+// order of use to evict items by. The memory of a Cache built on a ByteMap
+// is bounded by the ByteMap's capacity instead.
+func (c *cache) MemoryUsage() MemoryUsage {
+	overhead := int64(defaultEntryOverhead)
+	if s, ok := c.cacheMap.(entrySizer); ok {
+		overhead = s.EntryOverhead()
+	}
+	var m MemoryUsage
+	c.cacheMap.Range(func(k string, v any) {
+		m.Items++
+		m.KeyBytes += int64(len(k))
+		m.ValueBytes += SizeOf(v.(Item).Object)
+	})
+	m.OverheadBytes = int64(m.Items) * overhead
+	return m
+}
@@ -0,0 +1,68 @@
+package cache
+
+import "testing"
+
+type fixedSize struct{}
+
+func (fixedSize) Size() int64 { return 1000 }
+
+func TestSizeOf(t *testing.T) {
+	type node struct {
+		Name string
+		Next *node
+	}
+	cycle := &node{Name: "ab"}
+	cycle.Next = cycle
+	shared := []int64{1, 2}
+
+	tests := []struct {
+		x    interface{}
+		want int64
+	}{
+		{nil, 0},
+		{1, 8},
+		{true, 1},
+		{"abc", 16 + 3},
+		{make([]byte, 2, 10), 24 + 10},
+		{[]string{"a", "bc"}, 24 + 2*16 + 3},
+		{[2]string{"a", "bc"}, 2*16 + 3},
+		{struct {
+			A string
+			B []int32
+		}{"ab", []int32{1, 2}}, 16 + 24 + 2 + 8},
+		{map[string]int{"a": 1, "bc": 2}, 8 + 2*(16+8+mapEntryOverhead) + 3},
+		{cycle, 8 + 24 + 2},
+		{[][]int64{shared, shared}, 24 + 2*24 + 16},
+		{[]interface{}{1, "a"}, 24 + 2*16 + 8 + 16 + 1},
+		{fixedSize{}, 1000},
+		{[]fixedSize{{}}, 24},
+	}
+	for _, tt := range tests {
+		if got := SizeOf(tt.x); got != tt.want {
+			t.Errorf("SizeOf(%#v) = %d, want %d", tt.x, got, tt.want)
+		}
+	}
+}
+
+func TestMemoryUsage(t *testing.T) {
+	maps := map[string]CacheMap{
+		"RwmMap":        NewRwmMap(),
+		"SyncMap":       NewSyncMap(),
+		"ConcurrentMap": NewConcurrentMap(),
+	}
+	for name, m := range maps {
+		tc := New(NoExpiration, 0, m)
+		tc.Set("a", "value", NoExpiration)
+		tc.Set("bc", []byte("value"), NoExpiration)
+		tc.Set("a", "longer value", NoExpiration)
+		want := MemoryUsage{
+			Items:         2,
+			KeyBytes:      3,
+			ValueBytes:    16 + 12 + 24 + 5,
+			OverheadBytes: 2 * m.(entrySizer).EntryOverhead(),
+		}
+		if got := tc.MemoryUsage(); got != want {
+			t.Errorf("%s: MemoryUsage is %+v, want %+v", name, got, want)
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package cache
+
+import (
+	"encoding/gob"
+	"fmt"
+	"io"
+	"os"
+)
+
+// Write the cache's unexpired items (using Gob) to an io.Writer. The types of
+// the items' values are registered with gob.Register, so values of the same
+// types can be read back by Load in this process; other processes must
+// register them before loading.
+func (c *cache) Save(w io.Writer) (err error) {
+	return WriteSnapshot(w, c.Items())
+}
+
+// WriteSnapshot writes items to w in the format Save writes, registering the
+// types of their values with gob.Register.
+func WriteSnapshot(w io.Writer, items map[string]Item) (err error) {
+	enc := gob.NewEncoder(w)
+	defer func() {
+		if x := recover(); x != nil {
+			err = fmt.Errorf("error registering item types with Gob library: %v", x)
+		}
+	}()
+	for _, v := range items {
+		if v.Object != nil {
+			gob.Register(v.Object)
+		}
+	}
+	err = enc.Encode(&items)
+	return
+}
+
+// Save the cache's items to the given filename, creating the file if it
+// doesn't exist, and overwriting it if it does.
+func (c *cache) SaveFile(fname string) error {
+	fp, err := os.Create(fname)
+	if err != nil {
+		return err
+	}
+	err = c.Save(fp)
+	if err != nil {
+		fp.Close()
+		return err
+	}
+	return fp.Close()
+}
+
+// Add (Gob-serialized) cache items from an io.Reader, excluding any items that
+// have expired or with keys that already exist (and haven't expired) in the
+// current cache. Loaded items get new versions.
+func (c *cache) Load(r io.Reader) error {
+	items, err := ReadSnapshot(r)
+	if err != nil {
+		return err
+	}
+	for k, v := range items {
+		if v.Expired() {
+			continue
+		}
+		c.add(k, v.Object, v.Expiration)
+	}
+	return nil
+}
+
+// ReadSnapshot returns the items written to r by Save or WriteSnapshot,
+// including any that have expired. The types of the items' values must have
+// been registered with gob.Register.
+func ReadSnapshot(r io.Reader) (map[string]Item, error) {
+	dec := gob.NewDecoder(r)
+	items := map[string]Item{}
+	if err := dec.Decode(&items); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+// add sets k to x with expiration time e if k is not in the cache or has
+// expired, and reports whether it did.
+func (c *cache) add(k string, x interface{}, e int64) bool {
+	item := Item{Object: x, Expiration: e, Version: c.version.Add(1)}
+	i, observed := c.lockKey(k)
+	defer c.unlockKey(i, observed)
+	var old interface{}
+	var found bool
+	added := c.cacheMap.CompareAndSwap(k, item, func(v interface{}, ok bool) bool {
+		old, found = v, ok
+		return versionOf(v, ok) == 0
+	})
+	if added && observed {
+		c.publishSet(k, old, found, item)
+	}
+	return added
+}
+
+// Load and add cache items from the given filename, excluding any items that
+// have expired or with keys that already exist in the current cache.
+func (c *cache) LoadFile(fname string) error {
+	fp, err := os.Open(fname)
+	if err != nil {
+		return err
+	}
+	err = c.Load(fp)
+	if err != nil {
+		fp.Close()
+		return err
+	}
+	return fp.Close()
+}
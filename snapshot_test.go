@@ -0,0 +1,63 @@
+package cache
+
+import (
+	"bytes"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSaveLoad(t *testing.T) {
+	testSaveLoad(t, NewRwmMap())
+	testSaveLoad(t, NewSyncMap())
+	testSaveLoad(t, NewConcurrentMap())
+}
+
+func testSaveLoad(t *testing.T, m CacheMap) {
+	tc := New(DefaultExpiration, 0, m)
+	tc.Set("a", "a", DefaultExpiration)
+	tc.Set("b", "b", DefaultExpiration)
+	tc.Set("c", &TestStruct{Num: 1}, time.Hour)
+	tc.Set("expired", 1, time.Nanosecond)
+	<-time.After(time.Millisecond)
+
+	buf := &bytes.Buffer{}
+	if err := tc.Save(buf); err != nil {
+		t.Fatal("Couldn't save cache to buffer:", err)
+	}
+
+	oc := New(DefaultExpiration, 0, NewRwmMap())
+	oc.Set("a", "aa", DefaultExpiration)
+	if err := oc.Load(buf); err != nil {
+		t.Fatal("Couldn't load cache from buffer:", err)
+	}
+	if x, _ := oc.Get("a"); x != "aa" {
+		t.Error("Load replaced an existing item a:", x)
+	}
+	if x, _ := oc.Get("b"); x != "b" {
+		t.Error("b was not loaded:", x)
+	}
+	x, e, found := oc.GetWithExpiration("c")
+	if !found || x.(*TestStruct).Num != 1 || e.IsZero() {
+		t.Error("c was not loaded with its value and expiration:", x, e)
+	}
+	if _, found := oc.Get("expired"); found {
+		t.Error("an expired item was loaded")
+	}
+}
+
+func TestSaveLoadFile(t *testing.T) {
+	fname := filepath.Join(t.TempDir(), "cache.gob")
+	tc := New(DefaultExpiration, 0, NewConcurrentMap())
+	tc.Set("foo", "bar", DefaultExpiration)
+	if err := tc.SaveFile(fname); err != nil {
+		t.Fatal("Couldn't save cache to file:", err)
+	}
+	oc := New(DefaultExpiration, 0, NewSyncMap())
+	if err := oc.LoadFile(fname); err != nil {
+		t.Fatal("Couldn't load cache from file:", err)
+	}
+	if x, _ := oc.Get("foo"); x != "bar" {
+		t.Error("foo was not loaded from the file:", x)
+	}
+}
@@ -0,0 +1,106 @@
+package cache
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// Stats are counters of a cache's activity since it was created, and its
+// current number of items.
+type Stats struct {
+	// Lookups of a key that was found and had not expired, and of one that
+	// wasn't. Lookups made by a transaction are not counted.
+	Hits   uint64
+	Misses uint64
+	// Items removed because they expired, and items removed to make room
+	// for others.
+	Expirations uint64
+	Evictions   uint64
+	// The number of items, as returned by ItemCount.
+	Items int
+	// The number of sweeps for expired items, and the time spent in them.
+	JanitorRuns uint64
+	JanitorTime time.Duration
+}
+
+// HitRatio returns the fraction of lookups that were hits, or 0 if there
+// have been none.
+func (s Stats) HitRatio() float64 {
+	if s.Hits+s.Misses == 0 {
+		return 0
+	}
+	return float64(s.Hits) / float64(s.Hits+s.Misses)
+}
+
+// statStripeCount is the number of stripes hits and misses are counted in,
+// so that concurrent lookups of different keys rarely update the same
+// counter.
+const statStripeCount = 16
+
+type statStripe struct {
+	hits   atomic.Uint64
+	misses atomic.Uint64
+	// Padding to keep stripes in separate cache lines.
+	_ [48]byte
+}
+
+type stats struct {
+	stripes     [statStripeCount]statStripe
+	expirations atomic.Uint64
+	evictions   atomic.Uint64
+	janitorRuns atomic.Uint64
+	janitorTime atomic.Int64
+}
+
+// stripe returns the stripe to count a lookup of k in. It is chosen from the
+// key's length and last byte rather than a hash of the key, which would cost
+// as much again as counting.
+func (s *stats) stripe(k string) *statStripe {
+	if len(k) == 0 {
+		return &s.stripes[0]
+	}
+	return &s.stripes[(uint(len(k))*31+uint(k[len(k)-1]))%statStripeCount]
+}
+
+// lookup counts a lookup of k.
+func (s *stats) lookup(k string, hit bool) {
+	st := s.stripe(k)
+	if hit {
+		st.hits.Add(1)
+	} else {
+		st.misses.Add(1)
+	}
+}
+
+// lookups counts a batch of lookups that includes k.
+func (s *stats) lookups(k string, hits, misses int) {
+	st := s.stripe(k)
+	st.hits.Add(uint64(hits))
+	st.misses.Add(uint64(misses))
+}
+
+// sweep counts a sweep for expired items that started at start.
+func (s *stats) sweep(start time.Time) {
+	s.janitorRuns.Add(1)
+	s.janitorTime.Add(int64(time.Since(start)))
+}
+
+func (s *stats) read() Stats {
+	var st Stats
+	for i := range s.stripes {
+		st.Hits += s.stripes[i].hits.Load()
+		st.Misses += s.stripes[i].misses.Load()
+	}
+	st.Expirations = s.expirations.Load()
+	st.Evictions = s.evictions.Load()
+	st.JanitorRuns = s.janitorRuns.Load()
+	st.JanitorTime = time.Duration(s.janitorTime.Load())
+	return st
+}
+
+// Stats returns the cache's counters and its number of items.
+func (c *cache) Stats() Stats {
+	st := c.stats.read()
+	st.Items = c.ItemCount()
+	return st
+}
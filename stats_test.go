@@ -0,0 +1,89 @@
+package cache
+
+import (
+	"testing"
+	"time"
+)
+
+func TestStats(t *testing.T) {
+	testStats(t, NewRwmMap())
+	testStats(t, NewSyncMap())
+	testStats(t, NewConcurrentMap())
+}
+
+func testStats(t *testing.T, m CacheMap) {
+	tc := New(DefaultExpiration, 0, m)
+	tc.Set("a", 1, DefaultExpiration)
+	tc.Set("b", 2, DefaultExpiration)
+	tc.Set("expired", 3, time.Nanosecond)
+	<-time.After(time.Millisecond)
+
+	tc.Get("a")
+	tc.Get("missing")
+	tc.Get("expired")
+	tc.GetWithVersion("b")
+	tc.GetWithExpiration("missing")
+	tc.GetMulti([]string{"a", "b", "missing", "expired"})
+	tc.DeleteExpired()
+
+	st := tc.Stats()
+	if st.Hits != 4 || st.Misses != 5 {
+		t.Errorf("Stats counted %d hits and %d misses, want 4 and 5", st.Hits, st.Misses)
+	}
+	if st.Expirations != 1 {
+		t.Error("Stats counted", st.Expirations, "expirations, want 1")
+	}
+	if st.Items != 2 {
+		t.Error("Stats counted", st.Items, "items, want 2")
+	}
+	if r := st.HitRatio(); r < 0.44 || r > 0.45 {
+		t.Error("HitRatio is", r, "want 4/9")
+	}
+}
+
+func TestStatsJanitor(t *testing.T) {
+	tc := New(DefaultExpiration, time.Millisecond, NewConcurrentMap())
+	tc.Set("expired", 1, time.Nanosecond)
+	var st Stats
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); {
+		<-time.After(5 * time.Millisecond)
+		if st = tc.Stats(); st.Expirations > 0 {
+			break
+		}
+	}
+	if st.JanitorRuns == 0 || st.JanitorTime <= 0 {
+		t.Error("Stats did not count the janitor's sweeps:", st.JanitorRuns, st.JanitorTime)
+	}
+	if st.Expirations != 1 {
+		t.Error("Stats counted", st.Expirations, "expirations, want 1")
+	}
+}
+
+func TestLRUCache_Stats(t *testing.T) {
+	cache := NewLRUCache(2, time.Minute, time.Millisecond)
+	cache.Set("a", 1, DefaultExpiration)
+	cache.Set("b", 2, DefaultExpiration)
+	cache.Get("a")
+	cache.Get("missing")
+	cache.Set("c", 3, DefaultExpiration)
+	cache.GetMulti([]string{"a", "b"})
+	st := cache.Stats()
+	if st.Hits != 2 || st.Misses != 2 {
+		t.Errorf("Stats counted %d hits and %d misses, want 2 and 2", st.Hits, st.Misses)
+	}
+	if st.Evictions != 1 || st.Items != 2 {
+		t.Errorf("Stats counted %d evictions and %d items, want 1 and 2", st.Evictions, st.Items)
+	}
+	cache.Compute("short", func(old interface{}, found bool) (interface{}, time.Duration, Op) {
+		return 1, time.Nanosecond, OpSet
+	})
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); {
+		<-time.After(5 * time.Millisecond)
+		if st = cache.Stats(); st.Expirations > 0 {
+			break
+		}
+	}
+	if st.Expirations != 1 || st.JanitorRuns == 0 {
+		t.Errorf("Stats counted %d expirations and %d sweeps, want 1 and some", st.Expirations, st.JanitorRuns)
+	}
+}
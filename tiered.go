@@ -0,0 +1,303 @@
+package cache
+
+import (
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// A Tier is a cache that can be a level of a Tiered cache. Cache, LRUCache
+// and Tiered are tiers, as is any Cacher with a Compute method, such as a
+// client of a remote store.
+//
+// Compute must apply f's result without other writes to the key happening in
+// between, but may call f more than once before it does.
+type Tier interface {
+	Cacher
+	Compute(k string, f func(old interface{}, found bool) (interface{}, time.Duration, Op)) (interface{}, bool)
+}
+
+// TieredOptions configure a Tiered cache.
+type TieredOptions struct {
+	// The longest an item is kept in the first tier. Items set with a
+	// shorter duration are kept for that, and items promoted from the second
+	// tier for L1TTL. Zero means a minute; a negative duration means items
+	// are kept for the durations they are set with, and promoted items for
+	// the first tier's default expiration time. Promoted items are never
+	// kept past their expiration time in the second tier.
+	L1TTL time.Duration
+}
+
+// TierStats are counters of lookups in one tier of a Tiered cache.
+type TierStats struct {
+	Hits   uint64
+	Misses uint64
+}
+
+// TieredStats are counters of a Tiered cache's activity.
+type TieredStats struct {
+	L1, L2 TierStats
+	// Items copied to the first tier after being found in the second.
+	Promotions uint64
+	// Copies in the first tier dropped because their items were changed or
+	// removed in the second by writes that didn't go through the Tiered
+	// cache.
+	Invalidations uint64
+}
+
+// A Tiered cache puts a small, fast cache, such as an LRUCache, in front of a
+// larger or shared one, such as a Cache or a remote store. Lookups that miss
+// the first tier are looked up in the second, and the items found are
+// promoted to the first. Writes go through to both.
+//
+// If the second tier has a Watch method, as Cache and LRUCache do, items
+// changed or removed in it other than through the Tiered cache, such as by
+// another Tiered cache in front of it or an Invalidator, are dropped from the
+// first tier too. Otherwise the first tier may serve stale copies of such
+// items until L1TTL has passed. Items set through the Tiered cache are told
+// apart from others by their values, so items whose values can't be compared,
+// such as structs holding slices, are dropped from the first tier soon after
+// they are set, and aren't promoted.
+type Tiered struct {
+	l1, l2 Tier
+	ttl    time.Duration
+	mu     sync.Mutex
+	cancel func()
+	closed bool
+	done   chan struct{}
+	// Held while a key is written or promoted, so that the first tier's
+	// copy is updated in the same order as the item in the second.
+	locks [txLockCount]sync.Mutex
+
+	l1Hits, l1Misses atomic.Uint64
+	l2Hits, l2Misses atomic.Uint64
+	promotions       atomic.Uint64
+	invalidations    atomic.Uint64
+}
+
+// watchable is implemented by tiers whose changes can be watched.
+type watchable interface {
+	Watch(key string, opts WatchOptions) (<-chan Event, func())
+}
+
+// NewTiered returns a Tiered cache with tiers l1 and l2. It must be closed
+// when it is no longer needed, which doesn't close the tiers.
+func NewTiered(l1, l2 Tier, opts TieredOptions) *Tiered {
+	if opts.L1TTL == 0 {
+		opts.L1TTL = time.Minute
+	}
+	t := &Tiered{l1: l1, l2: l2, ttl: opts.L1TTL, done: make(chan struct{})}
+	if w, ok := l2.(watchable); ok {
+		events, cancel := w.Watch("", WatchOptions{Prefix: true, Buffer: 1024, Policy: Disconnect})
+		t.cancel = cancel
+		go t.invalidate(w, events)
+	} else {
+		close(t.done)
+	}
+	return t
+}
+
+// l1Duration returns the duration to keep an item set with duration d in
+// the first tier.
+func (t *Tiered) l1Duration(d time.Duration) time.Duration {
+	if t.ttl < 0 || (d > 0 && d < t.ttl) {
+		return d
+	}
+	return t.ttl
+}
+
+// setTier sets k to x in tier.
+func setTier(tier Tier, k string, x interface{}, d time.Duration) {
+	tier.Compute(k, func(interface{}, bool) (interface{}, time.Duration, Op) {
+		return x, d, OpSet
+	})
+}
+
+// Get returns the value of k from the first tier that has it, promoting it
+// to the first tier if it was found in the second.
+func (t *Tiered) Get(k string) (interface{}, bool) {
+	if x, found := t.l1.Get(k); found {
+		t.l1Hits.Add(1)
+		return x, true
+	}
+	t.l1Misses.Add(1)
+	x, found := t.promote(k)
+	if !found {
+		t.l2Misses.Add(1)
+		return nil, false
+	}
+	t.l2Hits.Add(1)
+	t.promotions.Add(1)
+	return x, true
+}
+
+// promote copies k from the second tier to the first, for no longer than it
+// has left to live, and returns its value.
+func (t *Tiered) promote(k string) (interface{}, bool) {
+	mu := &t.locks[txLockIndex(k)]
+	mu.Lock()
+	defer mu.Unlock()
+	x, expiration, found := t.l2.GetWithExpiration(k)
+	if !found {
+		return nil, false
+	}
+	d := t.l1Duration(DefaultExpiration)
+	if !expiration.IsZero() {
+		// GetWithExpiration may return items that have expired.
+		left := time.Until(expiration)
+		if left <= 0 {
+			return nil, false
+		}
+		if d <= 0 || left < d {
+			d = left
+		}
+	}
+	setTier(t.l1, k, x, d)
+	// A write that didn't go through t may have changed the item after it
+	// was read, and been published before the copy was made.
+	if y, found := t.l2.Get(k); !found || !sameValue(x, y) {
+		t.l1.Delete(k)
+	}
+	return x, true
+}
+
+// GetWithExpiration returns the value of k from the second tier, which holds
+// its expiration time, and whether it was found. It doesn't promote the item
+// or count the lookup in Stats.
+func (t *Tiered) GetWithExpiration(k string) (interface{}, time.Time, bool) {
+	return t.l2.GetWithExpiration(k)
+}
+
+// Set sets k to x in both tiers, with duration d, interpreted as in
+// Cache.Set by each tier and limited to L1TTL in the first.
+func (t *Tiered) Set(k string, x interface{}, d time.Duration) {
+	t.Compute(k, func(interface{}, bool) (interface{}, time.Duration, Op) {
+		return x, d, OpSet
+	})
+}
+
+// SetDefault sets k to x in both tiers with DefaultExpiration.
+func (t *Tiered) SetDefault(k string, x interface{}) {
+	t.Set(k, x, DefaultExpiration)
+}
+
+// Delete deletes k from both tiers.
+func (t *Tiered) Delete(k string) {
+	t.Compute(k, func(interface{}, bool) (interface{}, time.Duration, Op) {
+		return nil, 0, OpDelete
+	})
+}
+
+// Compute atomically updates the item for k in the second tier, like
+// Cache.Compute, and makes the same change to the first.
+func (t *Tiered) Compute(k string, f func(old interface{}, found bool) (interface{}, time.Duration, Op)) (interface{}, bool) {
+	mu := &t.locks[txLockIndex(k)]
+	mu.Lock()
+	defer mu.Unlock()
+	// The first tier's copy is dropped before the second tier is written,
+	// so that the write's event doesn't find it stale and count it as an
+	// invalidation.
+	t.l1.Delete(k)
+	// The second tier may call f more than once; the result of the last
+	// call is the one applied.
+	var x interface{}
+	var d time.Duration
+	var op Op
+	v, found := t.l2.Compute(k, func(old interface{}, found bool) (interface{}, time.Duration, Op) {
+		x, d, op = f(old, found)
+		return x, d, op
+	})
+	if op == OpSet {
+		setTier(t.l1, k, x, t.l1Duration(d))
+	}
+	return v, found
+}
+
+// ItemCount returns the number of items in the second tier, which holds
+// every item of the Tiered cache.
+func (t *Tiered) ItemCount() int {
+	return t.l2.ItemCount()
+}
+
+// Flush deletes all items from both tiers.
+func (t *Tiered) Flush() {
+	t.l2.Flush()
+	t.l1.Flush()
+}
+
+// invalidate drops copies from the first tier when their items are changed
+// in the second. Changes made through t drop the first tier's copy before
+// they are published, and copy the new value after, so a set whose value is
+// the copy's is t's own.
+func (t *Tiered) invalidate(w watchable, events <-chan Event) {
+	defer close(t.done)
+	for {
+		for ev := range events {
+			switch ev.Type {
+			case EventSet, EventDelete, EventExpire, EventEvict:
+				t.l1.Compute(ev.Key, func(old interface{}, found bool) (interface{}, time.Duration, Op) {
+					if !found || (ev.Type == EventSet && sameValue(old, ev.NewValue)) {
+						return old, 0, OpKeep
+					}
+					t.invalidations.Add(1)
+					return nil, 0, OpDelete
+				})
+			case EventFlush:
+				t.l1.Flush()
+			}
+		}
+		// The watch was stopped by Close, or fell behind and was
+		// disconnected, in which case changes may have been missed.
+		t.mu.Lock()
+		if t.closed {
+			t.mu.Unlock()
+			return
+		}
+		t.l1.Flush()
+		events, t.cancel = w.Watch("", WatchOptions{Prefix: true, Buffer: 1024, Policy: Disconnect})
+		t.mu.Unlock()
+	}
+}
+
+// sameValue reports whether a and b are equal, or for slices and maps,
+// whether they are the same slice or map. It returns false for values that
+// can't be compared.
+func sameValue(a, b interface{}) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	va, vb := reflect.ValueOf(a), reflect.ValueOf(b)
+	if va.Type() != vb.Type() {
+		return false
+	}
+	switch va.Kind() {
+	case reflect.Slice:
+		return va.Pointer() == vb.Pointer() && va.Len() == vb.Len()
+	case reflect.Map:
+		return va.Pointer() == vb.Pointer()
+	}
+	return va.Comparable() && va.Equal(vb)
+}
+
+// Stats returns the Tiered cache's counters.
+func (t *Tiered) Stats() TieredStats {
+	return TieredStats{
+		L1:            TierStats{Hits: t.l1Hits.Load(), Misses: t.l1Misses.Load()},
+		L2:            TierStats{Hits: t.l2Hits.Load(), Misses: t.l2Misses.Load()},
+		Promotions:    t.promotions.Load(),
+		Invalidations: t.invalidations.Load(),
+	}
+}
+
+// Close stops watching the second tier. It doesn't close the tiers.
+func (t *Tiered) Close() error {
+	t.mu.Lock()
+	if !t.closed && t.cancel != nil {
+		t.cancel()
+	}
+	t.closed = true
+	t.mu.Unlock()
+	<-t.done
+	return nil
+}
@@ -0,0 +1,201 @@
+package cache
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTiered(t *testing.T) {
+	l1 := NewLRUCache(2, time.Minute, time.Minute)
+	l2 := New(NoExpiration, 0, NewConcurrentMap())
+	tc := NewTiered(l1, l2, TieredOptions{})
+	defer tc.Close()
+
+	tc.Set("a", 1, NoExpiration)
+	tc.Set("b", 2, NoExpiration)
+	tc.Set("c", 3, NoExpiration)
+	if _, found := l1.Get("a"); found {
+		t.Error("the first tier kept more items than it can hold")
+	}
+	for _, k := range []string{"a", "b", "c"} {
+		if _, found := l2.Get(k); !found {
+			t.Errorf("%s was not written through to the second tier", k)
+		}
+	}
+	for i := 0; i < 2; i++ {
+		if x, found := tc.Get("a"); !found || x != 1 {
+			t.Errorf("Get returned %v, %v", x, found)
+		}
+	}
+	if _, found := tc.Get("missing"); found {
+		t.Error("Get found a missing key")
+	}
+	tc.Delete("a")
+	_, in1 := l1.Get("a")
+	_, in2 := l2.Get("a")
+	if in1 || in2 {
+		t.Error("Delete left the item in a tier:", in1, in2)
+	}
+	if x, ok := tc.Compute("b", func(old interface{}, found bool) (interface{}, time.Duration, Op) {
+		return old.(int) + 1, KeepExpiration, OpSet
+	}); !ok || x != 3 {
+		t.Error("Compute returned", x, ok)
+	}
+	if x, _ := l1.Get("b"); x != 3 {
+		t.Error("Compute did not update the first tier:", x)
+	}
+
+	want := TieredStats{
+		L1:         TierStats{Hits: 1, Misses: 2},
+		L2:         TierStats{Hits: 1, Misses: 1},
+		Promotions: 1,
+	}
+	if st := tc.Stats(); st != want {
+		t.Errorf("Stats are %+v, want %+v", st, want)
+	}
+}
+
+func TestTieredInvalidation(t *testing.T) {
+	l1 := New(NoExpiration, 0, NewConcurrentMap())
+	l2 := NewLRUCache(100, NoExpiration, time.Minute)
+	tc := NewTiered(l1, l2, TieredOptions{})
+	defer tc.Close()
+
+	for _, k := range []string{"a", "b", "c"} {
+		tc.Set(k, k, NoExpiration)
+	}
+	tc.Set("slice", []int{1}, NoExpiration)
+	l2.Set("a", "changed", DefaultExpiration)
+	l2.Delete("b")
+	waitFor(t, "the first tier's copies to be dropped", func() bool {
+		_, a := l1.Get("a")
+		_, b := l1.Get("b")
+		return !a && !b
+	})
+	if x, _ := tc.Get("a"); x != "changed" {
+		t.Error("Get returned a stale copy:", x)
+	}
+	l2.Flush()
+	waitFor(t, "the first tier to be flushed", func() bool { return l1.ItemCount() == 0 })
+	if st := tc.Stats(); st.Invalidations != 2 {
+		t.Error("Stats counted", st.Invalidations, "invalidations, want 2")
+	}
+
+	// Writes through the Tiered cache are not invalidations.
+	tc.Set("d", "d", NoExpiration)
+	tc.Set("slice", []int{1}, NoExpiration)
+	tc.Delete("c")
+	<-time.After(10 * time.Millisecond)
+	_, d := l1.Get("d")
+	_, slice := l1.Get("slice")
+	if !d || !slice {
+		t.Error("the first tier's copies of items set through the Tiered cache were dropped:", d, slice)
+	}
+	if st := tc.Stats(); st.Invalidations != 2 {
+		t.Error("Stats counted", st.Invalidations, "invalidations, want 2")
+	}
+}
+
+func TestTieredL1TTL(t *testing.T) {
+	l1 := NewLRUCache(10, NoExpiration, time.Minute)
+	l2 := New(NoExpiration, 0, NewSyncMap())
+	tc := NewTiered(l1, l2, TieredOptions{L1TTL: 20 * time.Millisecond})
+	defer tc.Close()
+	tc.Set("a", 1, NoExpiration)
+	<-time.After(30 * time.Millisecond)
+	if _, found := l1.Get("a"); found {
+		t.Error("the first tier kept an item longer than L1TTL")
+	}
+	if x, found := tc.Get("a"); !found || x != 1 {
+		t.Error("Get returned", x, found)
+	}
+	if st := tc.Stats(); st.Promotions != 1 {
+		t.Error("Stats counted", st.Promotions, "promotions, want 1")
+	}
+}
+
+// remoteTier is a Tier without a Watch method.
+type remoteTier struct {
+	Cacher
+	c *Cache
+}
+
+func (r remoteTier) Compute(k string, f func(interface{}, bool) (interface{}, time.Duration, Op)) (interface{}, bool) {
+	return r.c.Compute(k, f)
+}
+
+func TestTieredUnwatchable(t *testing.T) {
+	l1 := NewLRUCache(10, time.Minute, time.Minute)
+	l2 := New(NoExpiration, 0, NewConcurrentMap())
+	tc := NewTiered(l1, remoteTier{l2, l2}, TieredOptions{})
+	tc.Set("a", 1, NoExpiration)
+	l2.Set("b", 2, NoExpiration)
+	if x, found := tc.Get("b"); !found || x != 2 {
+		t.Error("Get returned", x, found)
+	}
+	tc.Flush()
+	if _, found := tc.Get("a"); found {
+		t.Error("Flush left an item")
+	}
+	tc.Close()
+}
+
+func TestTieredPromotionTTL(t *testing.T) {
+	l1 := NewLRUCache(10, NoExpiration, time.Minute)
+	l2 := New(NoExpiration, 0, NewConcurrentMap())
+	tc := NewTiered(l1, l2, TieredOptions{})
+	defer tc.Close()
+	l2.Set("a", 1, 20*time.Millisecond)
+	if x, found := tc.Get("a"); !found || x != 1 {
+		t.Error("Get returned", x, found)
+	}
+	if _, expiration, _ := l1.GetWithExpiration("a"); expiration.After(time.Now().Add(20 * time.Millisecond)) {
+		t.Error("a promoted item outlives its expiration in the second tier:", expiration)
+	}
+	<-time.After(30 * time.Millisecond)
+	if _, found := tc.Get("a"); found {
+		t.Error("Get returned a promoted copy of an expired item")
+	}
+}
+
+func TestTieredEvictions(t *testing.T) {
+	l1 := New(NoExpiration, 0, NewConcurrentMap())
+	l2 := NewLRUCache(1, NoExpiration, time.Minute)
+	tc := NewTiered(l1, l2, TieredOptions{})
+	defer tc.Close()
+	tc.Set("a", 1, NoExpiration)
+	tc.Set("b", 2, NoExpiration)
+	waitFor(t, "the copy of an evicted item to be dropped", func() bool {
+		_, found := l1.Get("a")
+		return !found
+	})
+}
+
+// retryingTier is a Tier whose Compute calls f twice, like a map that
+// retries a compare-and-swap.
+type retryingTier struct {
+	*Cache
+}
+
+func (r retryingTier) Compute(k string, f func(interface{}, bool) (interface{}, time.Duration, Op)) (interface{}, bool) {
+	f(r.Get(k))
+	return r.Cache.Compute(k, f)
+}
+
+func TestTieredComputeRetries(t *testing.T) {
+	l1 := New(NoExpiration, 0, NewConcurrentMap())
+	l2 := New(NoExpiration, 0, NewConcurrentMap())
+	tc := NewTiered(l1, retryingTier{l2}, TieredOptions{})
+	defer tc.Close()
+	calls := 0
+	tc.Compute("a", func(interface{}, bool) (interface{}, time.Duration, Op) {
+		calls++
+		if calls == 1 {
+			return "first", NoExpiration, OpSet
+		}
+		return nil, 0, OpDelete
+	})
+	if x, found := l1.Get("a"); found {
+		t.Error("the first tier kept the result of a call of f that was not applied:", x)
+	}
+}
@@ -0,0 +1,220 @@
+package cache
+
+import (
+	"errors"
+	"slices"
+	"sync"
+	"time"
+)
+
+// ErrTxnConflict is returned by Txn when a transaction keeps conflicting with
+// concurrent writes and gives up.
+var ErrTxnConflict = errors.New("cache: transaction conflicted too many times")
+
+// maxTxnAttempts bounds how many times Txn runs a transaction that keeps
+// conflicting.
+const maxTxnAttempts = 100
+
+// txLockCount is the number of locks that keys are spread over to keep
+// writers out of the keys a transaction is committing.
+const txLockCount = 64
+
+// txLocks are held by every write to a key in their stripe, and exclusively
+// by a transaction while it validates and applies its writes.
+type txLocks [txLockCount]sync.RWMutex
+
+func txLockIndex(k string) int {
+	return int(fnv32(k) % txLockCount)
+}
+
+// stripes returns the sorted, distinct indexes of the stripes of keys.
+func stripes(keys []string) []int {
+	idx := make([]int, len(keys))
+	for i, k := range keys {
+		idx[i] = txLockIndex(k)
+	}
+	slices.Sort(idx)
+	return slices.Compact(idx)
+}
+
+// lockKey locks the stripe of k for a write to k, and returns the stripe and
+// whether the cache has subscribers for unlockKey. Writes share their stripe
+// unless there are subscribers, in which case they lock it exclusively, so
+// that events for the same key are published in the order the writes were
+// applied.
+func (c *cache) lockKey(k string) (int, bool) {
+	i := txLockIndex(k)
+	observed := c.events.active()
+	if observed {
+		c.txLocks[i].Lock()
+	} else {
+		c.txLocks[i].RLock()
+	}
+	return i, observed
+}
+
+func (c *cache) unlockKey(i int, observed bool) {
+	if observed {
+		c.txLocks[i].Unlock()
+	} else {
+		c.txLocks[i].RUnlock()
+	}
+}
+
+// lockKeys is like lockKey for a write to several keys. It locks their
+// stripes in ascending order, like commit.
+func (c *cache) lockKeys(keys []string) ([]int, bool) {
+	idx := stripes(keys)
+	observed := c.events.active()
+	for _, i := range idx {
+		if observed {
+			c.txLocks[i].Lock()
+		} else {
+			c.txLocks[i].RLock()
+		}
+	}
+	return idx, observed
+}
+
+func (c *cache) unlockKeys(idx []int, observed bool) {
+	for _, i := range idx {
+		c.unlockKey(i, observed)
+	}
+}
+
+// Tx is a transaction passed to the function given to Txn. Reads through a Tx
+// see the transaction's own writes, and writes are buffered until the
+// transaction commits. A Tx must not be used after the function returns or
+// from more than one goroutine.
+type Tx struct {
+	c      *cache
+	reads  map[string]uint64
+	writes map[string]txWrite
+}
+
+type txWrite struct {
+	object  interface{}
+	d       time.Duration
+	deleted bool
+}
+
+// Get gets an item like Cache.Get and adds its key to the transaction's read
+// set. The transaction only commits if none of the items it read have been
+// replaced or deleted by then.
+func (tx *Tx) Get(k string) (interface{}, bool) {
+	if w, ok := tx.writes[k]; ok {
+		if w.deleted {
+			return nil, false
+		}
+		return w.object, true
+	}
+	v, found := tx.c.cacheMap.Get(k)
+	if _, ok := tx.reads[k]; !ok {
+		tx.reads[k] = versionOf(v, found)
+	}
+	return objectOf(v, found)
+}
+
+// GetWithVersion gets an item and its version like Cache.GetWithVersion, and
+// adds its key to the transaction's read set like Get. An item written by the
+// transaction has version 0 until it commits.
+func (tx *Tx) GetWithVersion(k string) (interface{}, uint64, bool) {
+	if _, ok := tx.writes[k]; ok {
+		x, found := tx.Get(k)
+		return x, 0, found
+	}
+	v, found := tx.c.cacheMap.Get(k)
+	version := versionOf(v, found)
+	if _, ok := tx.reads[k]; !ok {
+		tx.reads[k] = version
+	}
+	x, found := objectOf(v, found)
+	return x, version, found
+}
+
+// Set buffers an item to be added to the cache, replacing any existing item,
+// when the transaction commits. The duration is interpreted as in Cache.Set,
+// counting from the commit.
+func (tx *Tx) Set(k string, x interface{}, d time.Duration) {
+	tx.writes[k] = txWrite{object: x, d: d}
+}
+
+// Delete buffers the deletion of an item when the transaction commits.
+func (tx *Tx) Delete(k string) {
+	tx.writes[k] = txWrite{deleted: true}
+}
+
+// Txn runs f in a transaction and commits the writes it made through tx if f
+// returns nil. Committing checks that no item f read through tx has been
+// replaced, deleted or has expired since, using the items' versions, and then
+// applies all of the writes without any other write to the keys involved
+// landing in between. If the check fails, Txn discards the writes and runs f
+// again, so f should be free of side effects other than through tx. If f
+// returns an error, Txn discards the writes and returns the error. If f keeps
+// conflicting, Txn gives up and returns ErrTxnConflict.
+//
+// Transactions are serializable with respect to each other and to other
+// writes, but reads made outside a transaction may observe some of a
+// transaction's writes before the rest are applied.
+func (c *cache) Txn(f func(tx *Tx) error) error {
+	for attempt := 0; attempt < maxTxnAttempts; attempt++ {
+		tx := &Tx{c: c, reads: map[string]uint64{}, writes: map[string]txWrite{}}
+		if err := f(tx); err != nil {
+			return err
+		}
+		if c.commit(tx) {
+			return nil
+		}
+	}
+	return ErrTxnConflict
+}
+
+// commit validates tx's reads and applies its writes, and reports whether it
+// did.
+func (c *cache) commit(tx *Tx) bool {
+	if len(tx.reads) == 0 && len(tx.writes) == 0 {
+		return true
+	}
+	keys := make([]string, 0, len(tx.reads)+len(tx.writes))
+	for k := range tx.reads {
+		keys = append(keys, k)
+	}
+	for k := range tx.writes {
+		keys = append(keys, k)
+	}
+	idx := stripes(keys)
+	for _, i := range idx {
+		c.txLocks[i].Lock()
+	}
+	defer func() {
+		for _, i := range idx {
+			c.txLocks[i].Unlock()
+		}
+	}()
+	for k, version := range tx.reads {
+		if versionOf(c.cacheMap.Get(k)) != version {
+			return false
+		}
+	}
+	observed := c.events.active()
+	for k, w := range tx.writes {
+		var old interface{}
+		var found bool
+		if observed {
+			old, found = c.cacheMap.Get(k)
+		}
+		if w.deleted {
+			c.cacheMap.Delete(k)
+			if found {
+				c.publishDelete(k, old)
+			}
+			continue
+		}
+		item := c.newItem(w.object, w.d)
+		c.cacheMap.Set(k, item)
+		if observed {
+			c.publishSet(k, old, found, item)
+		}
+	}
+	return true
+}
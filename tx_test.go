@@ -0,0 +1,179 @@
+package cache
+
+import (
+	"errors"
+	"math/rand"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestTxn(t *testing.T) {
+	testTxn(t, NewRwmMap())
+	testTxn(t, NewSyncMap())
+	testTxn(t, NewConcurrentMap())
+}
+
+func testTxn(t *testing.T, m CacheMap) {
+	tc := New(DefaultExpiration, 0, m)
+	tc.Set("a", 1, DefaultExpiration)
+	tc.Set("b", 2, DefaultExpiration)
+
+	err := tc.Txn(func(tx *Tx) error {
+		a, _ := tx.Get("a")
+		b, _ := tx.Get("b")
+		tx.Set("a", b, DefaultExpiration)
+		tx.Set("b", a, DefaultExpiration)
+		tx.Delete("c")
+		if x, _ := tx.Get("a"); x != 2 {
+			t.Error("Tx did not read its own write of a:", x)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal("Txn failed:", err)
+	}
+	if a, _ := tc.Get("a"); a != 2 {
+		t.Error("a was not swapped:", a)
+	}
+	if b, _ := tc.Get("b"); b != 1 {
+		t.Error("b was not swapped:", b)
+	}
+
+	errAbort := errors.New("abort")
+	err = tc.Txn(func(tx *Tx) error {
+		tx.Set("a", 100, DefaultExpiration)
+		tx.Delete("b")
+		return errAbort
+	})
+	if err != errAbort {
+		t.Error("Txn did not return the error of its function:", err)
+	}
+	if a, _ := tc.Get("a"); a != 2 {
+		t.Error("an aborted transaction wrote a:", a)
+	}
+	if _, found := tc.Get("b"); !found {
+		t.Error("an aborted transaction deleted b")
+	}
+
+	attempts := 0
+	err = tc.Txn(func(tx *Tx) error {
+		attempts++
+		a, _ := tx.Get("a")
+		if attempts == 1 {
+			tc.Set("a", 10, DefaultExpiration)
+		}
+		tx.Set("b", a, DefaultExpiration)
+		return nil
+	})
+	if err != nil {
+		t.Fatal("Txn failed:", err)
+	}
+	if attempts != 2 {
+		t.Errorf("Txn ran %d times after a conflicting write, want 2", attempts)
+	}
+	if b, _ := tc.Get("b"); b != 10 {
+		t.Error("b was not set from the value of a after the conflict:", b)
+	}
+
+	err = tc.Txn(func(tx *Tx) error {
+		tx.Get("a")
+		tc.Set("a", 11, DefaultExpiration)
+		tx.Set("b", 0, DefaultExpiration)
+		return nil
+	})
+	if err != ErrTxnConflict {
+		t.Error("Txn that always conflicts did not give up:", err)
+	}
+
+	_, want, _ := tc.GetWithVersion("a")
+	err = tc.Txn(func(tx *Tx) error {
+		if x, version, found := tx.GetWithVersion("a"); !found || x != 11 || version != want {
+			t.Errorf("Tx.GetWithVersion(a) = %v, %d, %t, want 11, %d, true", x, version, found, want)
+		}
+		if _, version, found := tx.GetWithVersion("missing"); found || version != 0 {
+			t.Error("Tx.GetWithVersion found a missing item with version", version)
+		}
+		tx.Set("a", 12, DefaultExpiration)
+		if x, version, _ := tx.GetWithVersion("a"); x != 12 || version != 0 {
+			t.Errorf("Tx.GetWithVersion(a) after a write = %v, %d, want 12, 0", x, version)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal("Txn failed:", err)
+	}
+}
+
+func TestTxnTransfer(t *testing.T) {
+	testTxnTransfer(t, NewRwmMap())
+	testTxnTransfer(t, NewSyncMap())
+	testTxnTransfer(t, NewConcurrentMap())
+}
+
+// testTxnTransfer moves money between accounts from many goroutines while
+// others check that the total never changes.
+func testTxnTransfer(t *testing.T, m CacheMap) {
+	const accounts, balance = 10, 100
+	tc := New(DefaultExpiration, 0, m)
+	for i := 0; i < accounts; i++ {
+		tc.Set("account"+strconv.Itoa(i), balance, DefaultExpiration)
+	}
+	total := func(tx *Tx) int {
+		sum := 0
+		for i := 0; i < accounts; i++ {
+			x, _ := tx.Get("account" + strconv.Itoa(i))
+			sum += x.(int)
+		}
+		return sum
+	}
+
+	wg := new(sync.WaitGroup)
+	workers, each := 16, 200
+	wg.Add(workers)
+	for w := 0; w < workers; w++ {
+		go func(seed int64) {
+			defer wg.Done()
+			r := rand.New(rand.NewSource(seed))
+			for j := 0; j < each; j++ {
+				from := "account" + strconv.Itoa(r.Intn(accounts))
+				to := "account" + strconv.Itoa(r.Intn(accounts))
+				amount := r.Intn(10)
+				err := tc.Txn(func(tx *Tx) error {
+					x, _ := tx.Get(from)
+					if x.(int) < amount {
+						return nil
+					}
+					tx.Set(from, x.(int)-amount, DefaultExpiration)
+					y, _ := tx.Get(to)
+					tx.Set(to, y.(int)+amount, NoExpiration)
+					return nil
+				})
+				if err != nil && err != ErrTxnConflict {
+					t.Error("transfer failed:", err)
+				}
+				if j%10 == 0 {
+					tc.Txn(func(tx *Tx) error {
+						if sum := total(tx); sum != accounts*balance {
+							// A torn read only fails the commit.
+							tx.Set("torn", sum, time.Minute)
+						}
+						return nil
+					})
+				}
+			}
+		}(int64(w))
+	}
+	wg.Wait()
+
+	tc.Txn(func(tx *Tx) error {
+		if sum := total(tx); sum != accounts*balance {
+			t.Errorf("accounts total %d after transfers, want %d", sum, accounts*balance)
+		}
+		return nil
+	})
+	if x, found := tc.Get("torn"); found {
+		t.Error("a transaction committed after reading a torn total:", x)
+	}
+}
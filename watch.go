@@ -0,0 +1,218 @@
+package cache
+
+import (
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// EventType is the kind of change an Event describes.
+type EventType int
+
+const (
+	// An item was added or replaced.
+	EventSet EventType = iota + 1
+	// An item was deleted.
+	EventDelete
+	// An expired item was removed from the cache.
+	EventExpire
+	// An item was removed to make room for others.
+	EventEvict
+	// All items were deleted. Flush events have an empty Key and are
+	// delivered to every watcher.
+	EventFlush
+)
+
+func (t EventType) String() string {
+	switch t {
+	case EventSet:
+		return "set"
+	case EventDelete:
+		return "delete"
+	case EventExpire:
+		return "expire"
+	case EventEvict:
+		return "evict"
+	case EventFlush:
+		return "flush"
+	}
+	return "unknown"
+}
+
+// Event describes a change to an item in a cache.
+type Event struct {
+	Type EventType
+	Key  string
+	// The value of the item before the change, or nil if there was none. An
+	// item that had expired counts as none, except in expire events.
+	OldValue interface{}
+	// The value of the item after a set, or nil.
+	NewValue interface{}
+	// The expiration time of the item after a set, in Unix nanoseconds, or 0
+	// if it never expires.
+	Expiration int64
+	// The version of the item after a set, if the cache versions its items.
+	Version uint64
+}
+
+// SlowConsumerPolicy says what a cache does with an event for a watcher whose
+// buffer is full.
+type SlowConsumerPolicy int
+
+const (
+	// Drop the event. The watcher misses it but the writer is not delayed.
+	DropEvents SlowConsumerPolicy = iota
+	// Make the writer wait until the watcher has room or is cancelled.
+	// Other writes to keys that share a lock with the written key wait too,
+	// and the watcher must not write to the cache while it has events
+	// pending.
+	BlockWriters
+	// Stop the watch: close its channel after the events already in it.
+	Disconnect
+)
+
+// DefaultWatchBuffer is the number of events buffered for a watcher if
+// WatchOptions.Buffer is not positive.
+const DefaultWatchBuffer = 64
+
+// WatchOptions configure a watch. The zero value watches a single key with a
+// buffer of DefaultWatchBuffer events and drops events when it is full.
+type WatchOptions struct {
+	// Watch every key that starts with the given key, rather than only the
+	// key itself. An empty prefix watches the whole cache.
+	Prefix bool
+	Buffer int
+	Policy SlowConsumerPolicy
+}
+
+// subscriber receives the events of a cache. notify is called with the lock
+// of the event's key held, so events for the same key arrive in the order the
+// changes were made.
+type subscriber interface {
+	notify(ev Event)
+}
+
+// eventHub delivers the events of a cache to its subscribers.
+type eventHub struct {
+	mu   sync.RWMutex
+	subs map[subscriber]struct{}
+	n    atomic.Int32
+}
+
+// active reports whether there are any subscribers, so that writers can
+// skip the work of building events when there are none.
+func (h *eventHub) active() bool {
+	return h.n.Load() > 0
+}
+
+func (h *eventHub) subscribe(s subscriber) {
+	h.mu.Lock()
+	if h.subs == nil {
+		h.subs = map[subscriber]struct{}{}
+	}
+	h.subs[s] = struct{}{}
+	h.n.Store(int32(len(h.subs)))
+	h.mu.Unlock()
+}
+
+// unsubscribe removes s and returns once no event is being delivered to it.
+func (h *eventHub) unsubscribe(s subscriber) {
+	h.mu.Lock()
+	delete(h.subs, s)
+	h.n.Store(int32(len(h.subs)))
+	h.mu.Unlock()
+}
+
+func (h *eventHub) publish(ev Event) {
+	h.publishExcept(ev, nil)
+}
+
+// publishExcept delivers ev to every subscriber but skip, which made the
+// change and doesn't need to hear of it.
+func (h *eventHub) publishExcept(ev Event, skip subscriber) {
+	h.mu.RLock()
+	for s := range h.subs {
+		if s != skip {
+			s.notify(ev)
+		}
+	}
+	h.mu.RUnlock()
+}
+
+// watcher is a subscriber that sends matching events to a channel.
+type watcher struct {
+	hub    *eventHub
+	key    string
+	prefix bool
+	policy SlowConsumerPolicy
+	ch     chan Event
+	done   chan struct{}
+	once   sync.Once
+	// Set once a Disconnect watcher has fallen behind.
+	dropped atomic.Bool
+}
+
+func (h *eventHub) watch(key string, opts WatchOptions) (<-chan Event, func()) {
+	if opts.Buffer <= 0 {
+		opts.Buffer = DefaultWatchBuffer
+	}
+	w := &watcher{
+		hub:    h,
+		key:    key,
+		prefix: opts.Prefix,
+		policy: opts.Policy,
+		ch:     make(chan Event, opts.Buffer),
+		done:   make(chan struct{}),
+	}
+	h.subscribe(w)
+	return w.ch, w.stop
+}
+
+func (w *watcher) matches(key string) bool {
+	if w.prefix {
+		return strings.HasPrefix(key, w.key)
+	}
+	return key == w.key
+}
+
+func (w *watcher) notify(ev Event) {
+	if ev.Type != EventFlush && !w.matches(ev.Key) {
+		return
+	}
+	if w.dropped.Load() {
+		return
+	}
+	select {
+	case <-w.done:
+		return
+	default:
+	}
+	select {
+	case w.ch <- ev:
+		return
+	default:
+	}
+	switch w.policy {
+	case BlockWriters:
+		select {
+		case w.ch <- ev:
+		case <-w.done:
+		}
+	case Disconnect:
+		// The hub's lock is held while notifying, so the watcher is removed
+		// from another goroutine.
+		if w.dropped.CompareAndSwap(false, true) {
+			go w.stop()
+		}
+	}
+}
+
+// stop cancels the watch and closes its channel. It is safe to call more
+// than once.
+func (w *watcher) stop() {
+	w.once.Do(func() {
+		close(w.done)
+		w.hub.unsubscribe(w)
+		close(w.ch)
+	})
+}
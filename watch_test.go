@@ -0,0 +1,176 @@
+package cache
+
+import (
+	"testing"
+	"time"
+)
+
+func TestWatch(t *testing.T) {
+	testWatch(t, NewRwmMap())
+	testWatch(t, NewSyncMap())
+	testWatch(t, NewConcurrentMap())
+}
+
+func testWatch(t *testing.T, m CacheMap) {
+	tc := New(DefaultExpiration, 0, m)
+	tc.Set("foo", "before", DefaultExpiration)
+	events, cancel := tc.Watch("foo", WatchOptions{})
+
+	tc.Set("foo", "bar", DefaultExpiration)
+	tc.Set("other", "x", DefaultExpiration)
+	_, version, _ := tc.GetWithVersion("foo")
+	tc.CompareAndSwap("foo", version, "baz", NoExpiration)
+	tc.Compute("foo", func(old interface{}, found bool) (interface{}, time.Duration, Op) {
+		return old.(string) + "!", 50 * time.Millisecond, OpSet
+	})
+	tc.Delete("foo")
+	tc.Delete("foo")
+	tc.Set("foo", 1, time.Nanosecond)
+	<-time.After(time.Millisecond)
+	tc.DeleteExpired()
+	tc.Flush()
+
+	want := []Event{
+		{Type: EventSet, Key: "foo", OldValue: "before", NewValue: "bar"},
+		{Type: EventSet, Key: "foo", OldValue: "bar", NewValue: "baz"},
+		{Type: EventSet, Key: "foo", OldValue: "baz", NewValue: "baz!"},
+		{Type: EventDelete, Key: "foo", OldValue: "baz!"},
+		{Type: EventSet, Key: "foo", NewValue: 1},
+		{Type: EventExpire, Key: "foo", OldValue: 1},
+		{Type: EventFlush},
+	}
+	for i, w := range want {
+		ev := <-events
+		if ev.Type != w.Type || ev.Key != w.Key || ev.OldValue != w.OldValue || ev.NewValue != w.NewValue {
+			t.Errorf("event %d is %+v, want %+v", i, ev, w)
+		}
+		if i == 1 && ev.Expiration != 0 {
+			t.Error("event for an item that never expires has an expiration:", ev.Expiration)
+		}
+		if i == 2 && ev.Expiration <= time.Now().UnixNano() {
+			t.Error("event for an expiring item has no expiration in the future:", ev.Expiration)
+		}
+	}
+
+	cancel()
+	cancel()
+	tc.Set("foo", "after", DefaultExpiration)
+	if ev, ok := <-events; ok {
+		t.Error("received an event after cancelling the watch:", ev)
+	}
+}
+
+func TestWatchPrefix(t *testing.T) {
+	tc := New(DefaultExpiration, 0, NewConcurrentMap())
+	events, cancel := tc.Watch("user:", WatchOptions{Prefix: true})
+	defer cancel()
+	tc.Set("user:1", 1, DefaultExpiration)
+	tc.Set("session:1", 1, DefaultExpiration)
+	tc.SetMulti(map[string]interface{}{"user:2": 2, "session:2": 2}, DefaultExpiration)
+	tc.DeleteMulti([]string{"user:1", "session:1"})
+	tc.Txn(func(tx *Tx) error {
+		tx.Set("user:3", 3, DefaultExpiration)
+		tx.Set("session:3", 3, DefaultExpiration)
+		return nil
+	})
+	want := []string{"set user:1", "set user:2", "delete user:1", "set user:3"}
+	for _, w := range want {
+		ev := <-events
+		if got := ev.Type.String() + " " + ev.Key; got != w {
+			t.Errorf("received %q, want %q", got, w)
+		}
+	}
+	select {
+	case ev := <-events:
+		t.Error("received an event for a key without the prefix:", ev)
+	default:
+	}
+}
+
+func TestWatchSlowConsumer(t *testing.T) {
+	tc := New(DefaultExpiration, 0, NewRwmMap())
+
+	dropped, cancel := tc.Watch("foo", WatchOptions{Buffer: 1, Policy: DropEvents})
+	for i := 0; i < 3; i++ {
+		tc.Set("foo", i, DefaultExpiration)
+	}
+	if ev := <-dropped; ev.NewValue != 0 {
+		t.Error("DropEvents watcher did not keep the first event:", ev)
+	}
+	select {
+	case ev := <-dropped:
+		t.Error("DropEvents watcher received an event that did not fit:", ev)
+	default:
+	}
+	cancel()
+
+	disconnected, _ := tc.Watch("foo", WatchOptions{Buffer: 1, Policy: Disconnect})
+	for i := 0; i < 3; i++ {
+		tc.Set("foo", i, DefaultExpiration)
+	}
+	if ev := <-disconnected; ev.NewValue != 0 {
+		t.Error("Disconnect watcher did not keep the first event:", ev)
+	}
+	if ev, ok := <-disconnected; ok {
+		t.Error("Disconnect watcher was not closed after falling behind:", ev)
+	}
+
+	blocked, cancel := tc.Watch("foo", WatchOptions{Buffer: 1, Policy: BlockWriters})
+	defer cancel()
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < 10; i++ {
+			tc.Set("foo", i, DefaultExpiration)
+		}
+		close(done)
+	}()
+	for i := 0; i < 10; i++ {
+		if ev := <-blocked; ev.NewValue != i {
+			t.Errorf("BlockWriters watcher received %v, want %d", ev.NewValue, i)
+		}
+	}
+	<-done
+}
+
+func TestWatchCancelUnblocksWriter(t *testing.T) {
+	tc := New(DefaultExpiration, 0, NewSyncMap())
+	_, cancel := tc.Watch("foo", WatchOptions{Buffer: 1, Policy: BlockWriters})
+	done := make(chan struct{})
+	go func() {
+		tc.Set("foo", 1, DefaultExpiration)
+		tc.Set("foo", 2, DefaultExpiration)
+		close(done)
+	}()
+	<-time.After(10 * time.Millisecond)
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("writer blocked on a watcher was not released by cancelling it")
+	}
+}
+
+func TestLRUCache_Watch(t *testing.T) {
+	cache := NewLRUCache(2, time.Minute, time.Minute)
+	events, cancel := cache.Watch("", WatchOptions{Prefix: true})
+	defer cancel()
+	cache.Set("a", 1, DefaultExpiration)
+	cache.Set("b", 2, DefaultExpiration)
+	cache.Set("a", 3, DefaultExpiration)
+	cache.Set("c", 4, DefaultExpiration)
+	cache.Delete("a")
+	want := []Event{
+		{Type: EventSet, Key: "a", NewValue: 1},
+		{Type: EventSet, Key: "b", NewValue: 2},
+		{Type: EventSet, Key: "a", OldValue: 1, NewValue: 3},
+		{Type: EventSet, Key: "c", NewValue: 4},
+		{Type: EventEvict, Key: "b", OldValue: 2},
+		{Type: EventDelete, Key: "a", OldValue: 3},
+	}
+	for i, w := range want {
+		ev := <-events
+		if ev.Type != w.Type || ev.Key != w.Key || ev.OldValue != w.OldValue || ev.NewValue != w.NewValue {
+			t.Errorf("event %d is %+v, want %+v", i, ev, w)
+		}
+	}
+}